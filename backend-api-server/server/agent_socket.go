@@ -0,0 +1,257 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+)
+
+// Types of the messages exchanged over the socket of an agent in push mode.
+// The agent sends ready whenever it is idle and gets a task assigned as soon
+// as one is queued. Heartbeats are answered with the renewed lease, or with a
+// cancel once the task is being aborted, results with finished. Replies carry
+// the id of the message they answer.
+const (
+	socketReady     = "ready"
+	socketHeartbeat = "heartbeat"
+	socketResult    = "result"
+
+	socketAssign    = "assign"
+	socketLease     = "lease"
+	socketCancel    = "cancel"
+	socketLeaseLost = "lease_lost"
+	socketFinished  = "finished"
+	socketError     = "error"
+)
+
+const (
+	socketPingInterval = 30 * time.Second
+	socketPongWait     = 2 * socketPingInterval
+	socketWriteWait    = 10 * time.Second
+	// socketPickRetryDelay is the pause after a failed pick of a task for
+	// an agent.
+	socketPickRetryDelay = 5 * time.Second
+)
+
+// SocketMessage is a message of the agent socket, the fields that are set
+// depend on its type.
+type SocketMessage struct {
+	Type    string      `json:"type"`
+	ID      string      `json:"id,omitempty"`
+	TaskID  *uuid.UUID  `json:"task_id,omitempty"`
+	LeaseID *uuid.UUID  `json:"lease_id,omitempty"`
+	Task    *PickedTask `json:"task,omitempty"`
+	Lease   *TaskLease  `json:"lease,omitempty"`
+	Result  *TaskResult `json:"result,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+var errSocketClosed = errors.New("socket closed")
+
+// agentSession serves the socket of one agent. Messages are read by serve,
+// while a single writer goroutine sends the replies and assignments queued
+// on send, as a websocket connection has to be written by one goroutine only.
+type agentSession struct {
+	s    *Server
+	conn *websocket.Conn
+	req  pickRequest
+	send chan outgoingMessage
+	// writerDone is closed once the writer goroutine has stopped.
+	writerDone chan struct{}
+
+	mu          sync.Mutex
+	dispatching bool
+}
+
+// outgoingMessage is a message queued for the writer goroutine. delivered,
+// if set, gets the outcome of writing it.
+type outgoingMessage struct {
+	msg       SocketMessage
+	delivered chan<- error
+}
+
+func (a *agentSession) serve() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go a.writeMessages(ctx)
+
+	_ = a.conn.SetReadDeadline(time.Now().Add(socketPongWait))
+	a.conn.SetPongHandler(func(string) error {
+		return a.conn.SetReadDeadline(time.Now().Add(socketPongWait))
+	})
+
+	for {
+		var msg SocketMessage
+		if err := a.conn.ReadJSON(&msg); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Warnf("Socket of agent %s dropped: %v", a.req.AgentID, err)
+			}
+			return
+		}
+		_ = a.conn.SetReadDeadline(time.Now().Add(socketPongWait))
+
+		switch msg.Type {
+		case socketReady:
+			a.dispatch(ctx)
+		case socketHeartbeat:
+			a.reply(ctx, a.heartbeat(msg))
+		case socketResult:
+			a.reply(ctx, a.finish(msg))
+		default:
+			a.reply(ctx, SocketMessage{Type: socketError, ID: msg.ID, Error: "unknown message type"})
+		}
+	}
+}
+
+// dispatch assigns the next task to the agent once one is available. An
+// agent has at most one assignment pending.
+func (a *agentSession) dispatch(ctx context.Context) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.dispatching {
+		return
+	}
+	a.dispatching = true
+
+	go func() {
+		defer func() {
+			a.mu.Lock()
+			a.dispatching = false
+			a.mu.Unlock()
+		}()
+		var task *PickedTask
+		for {
+			var err error
+			task, err = a.s.awaitTask(ctx, a.req, time.Time{})
+			if err == nil {
+				break
+			}
+			if errors.Is(err, errNoQueuedTask) {
+				// The socket was closed while waiting.
+				return
+			}
+			log.Errorf("failed to pick task for agent %s: %v", a.req.AgentID, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(socketPickRetryDelay):
+			}
+		}
+		// An assignment that never reached the agent is released right
+		// away. Once it was written, a socket that drops leaves the task to
+		// the reaper.
+		log.Infof("Assigning task %s to agent %s", task.ID, a.req.AgentID)
+		if err := a.deliver(ctx, SocketMessage{Type: socketAssign, TaskID: &task.ID, Task: task}); err != nil {
+			log.Warnf("failed to assign task %s to agent %s: %v", task.ID, a.req.AgentID, err)
+			a.s.releaseLease(task)
+		}
+	}()
+}
+
+func (a *agentSession) heartbeat(msg SocketMessage) SocketMessage {
+	reply := SocketMessage{ID: msg.ID, TaskID: msg.TaskID}
+	if msg.TaskID == nil || msg.LeaseID == nil {
+		reply.Type, reply.Error = socketError, "task_id and lease_id are required"
+		return reply
+	}
+	lease, err := a.s.renewTaskLease(*msg.TaskID, *msg.LeaseID)
+	switch {
+	case errors.Is(err, errTaskNotFound), errors.Is(err, errLeaseNotHeld):
+		reply.Type, reply.Error = socketLeaseLost, err.Error()
+	case err != nil:
+		log.Error("failed to renew task lease: " + err.Error())
+		reply.Type, reply.Error = socketError, "failed to renew task lease"
+	case lease.Status == statusCancelling:
+		reply.Type, reply.Lease = socketCancel, &lease
+	default:
+		reply.Type, reply.Lease = socketLease, &lease
+	}
+	return reply
+}
+
+func (a *agentSession) finish(msg SocketMessage) SocketMessage {
+	reply := SocketMessage{ID: msg.ID, TaskID: msg.TaskID}
+	if msg.TaskID == nil || msg.Result == nil {
+		reply.Type, reply.Error = socketError, "task_id and result are required"
+		return reply
+	}
+	task, err := a.s.finishTask(*msg.TaskID, *msg.Result)
+	switch {
+	case errors.Is(err, errInvalidStream), errors.Is(err, errLeaseRequired), errors.Is(err, errTaskNotFound),
+		errors.Is(err, errTaskNotInProgress), errors.Is(err, errLeaseNotHeld):
+		reply.Type, reply.Error = socketError, err.Error()
+	case err != nil:
+		log.Error("failed to finish task: " + err.Error())
+		reply.Type, reply.Error = socketError, "failed to finish task"
+	default:
+		log.Infof("Agent %s finished task %s: %s", a.req.AgentID, task.ID, task.Status)
+		reply.Type = socketFinished
+	}
+	return reply
+}
+
+func (a *agentSession) reply(ctx context.Context, msg SocketMessage) {
+	select {
+	case a.send <- outgoingMessage{msg: msg}:
+	case <-ctx.Done():
+	}
+}
+
+// deliver queues a message like reply and waits until it is written to the
+// socket. It fails if the message could not be written.
+func (a *agentSession) deliver(ctx context.Context, msg SocketMessage) error {
+	delivered := make(chan error, 1)
+	select {
+	case a.send <- outgoingMessage{msg: msg, delivered: delivered}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-delivered:
+		return err
+	case <-a.writerDone:
+		// The writer reports the outcome of the message before stopping.
+		select {
+		case err := <-delivered:
+			return err
+		default:
+			return errSocketClosed
+		}
+	}
+}
+
+// writeMessages sends the queued messages and keeps the connection alive with
+// pings until ctx is done.
+func (a *agentSession) writeMessages(ctx context.Context) {
+	ticker := time.NewTicker(socketPingInterval)
+	defer ticker.Stop()
+	defer close(a.writerDone)
+	defer a.conn.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case out := <-a.send:
+			_ = a.conn.SetWriteDeadline(time.Now().Add(socketWriteWait))
+			err := a.conn.WriteJSON(out.msg)
+			if out.delivered != nil {
+				out.delivered <- err
+			}
+			if err != nil {
+				log.Warnf("failed to write to socket of agent %s: %v", a.req.AgentID, err)
+				return
+			}
+		case <-ticker.C:
+			_ = a.conn.SetWriteDeadline(time.Now().Add(socketWriteWait))
+			if err := a.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
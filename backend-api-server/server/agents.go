@@ -0,0 +1,120 @@
+package server
+
+import (
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	agentStateOnline  = "online"
+	agentStateOffline = "offline"
+)
+
+var agentIDPattern = regexp.MustCompile(`^[A-Za-z0-9_.:-]{1,128}$`)
+
+var (
+	errInvalidAgentID = errors.New("invalid agent id")
+	errUnknownAgent   = errors.New("unknown agent, register it first")
+)
+
+// AgentData is an executor agent known to the server. Agents register on
+// startup and keep LastSeenAt fresh with heartbeats.
+type AgentData struct {
+	ID           string `gorm:"primaryKey"`
+	Hostname     string
+	Version      string
+	Capabilities []string          `gorm:"serializer:json"`
+	Labels       map[string]string `gorm:"serializer:json"`
+	RegisteredAt time.Time
+	LastSeenAt   time.Time `gorm:"index"`
+}
+
+type Agent struct {
+	ID           string            `json:"id"`
+	Hostname     string            `json:"hostname"`
+	Version      string            `json:"version"`
+	Capabilities []string          `json:"capabilities"`
+	Labels       map[string]string `json:"labels"`
+	RegisteredAt time.Time         `json:"registered_at"`
+	LastSeenAt   time.Time         `json:"last_seen_at"`
+	// State is online while the agent keeps sending heartbeats.
+	State string `json:"state"`
+	// CurrentTaskID is the task the agent is running, if any.
+	CurrentTaskID *uuid.UUID `json:"current_task_id"`
+}
+
+func (d *AgentData) toAgent(now time.Time, offlineAfter time.Duration, currentTaskID *uuid.UUID) Agent {
+	state := agentStateOnline
+	if now.Sub(d.LastSeenAt) > offlineAfter {
+		state = agentStateOffline
+	}
+	return Agent{
+		ID:            d.ID,
+		Hostname:      d.Hostname,
+		Version:       d.Version,
+		Capabilities:  d.Capabilities,
+		Labels:        d.Labels,
+		RegisteredAt:  d.RegisteredAt,
+		LastSeenAt:    d.LastSeenAt,
+		State:         state,
+		CurrentTaskID: currentTaskID,
+	}
+}
+
+// checkAgent reports errUnknownAgent unless the agent is registered.
+func checkAgent(db *gorm.DB, agentID string) error {
+	var count int64
+	if err := db.Model(&AgentData{}).Where("id = ?", agentID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return errUnknownAgent
+	}
+	return nil
+}
+
+// loadCurrentTasks maps the given agents to the tasks they are running.
+func loadCurrentTasks(db *gorm.DB, agentIDs []string) (map[string]uuid.UUID, error) {
+	currentTasks := map[string]uuid.UUID{}
+	if len(agentIDs) == 0 {
+		return currentTasks, nil
+	}
+	var running []TaskData
+	err := db.
+		Select("id", "agent_id").
+		Where("agent_id IN ? AND status IN ?", agentIDs, []string{statusInProgress, statusCancelling}).
+		Find(&running).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, taskData := range running {
+		currentTasks[*taskData.AgentID] = taskData.ID
+	}
+	return currentTasks, nil
+}
+
+func (s *Server) toAgents(agentsData []AgentData) ([]Agent, error) {
+	agentIDs := make([]string, len(agentsData))
+	for i, agentData := range agentsData {
+		agentIDs[i] = agentData.ID
+	}
+	currentTasks, err := loadCurrentTasks(s.db, agentIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	agents := make([]Agent, len(agentsData))
+	for i, agentData := range agentsData {
+		var currentTaskID *uuid.UUID
+		if taskID, ok := currentTasks[agentData.ID]; ok {
+			currentTaskID = &taskID
+		}
+		agents[i] = agentData.toAgent(now, s.cfg.AgentOfflineAfter, currentTaskID)
+	}
+	return agents, nil
+}
@@ -0,0 +1,243 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestAgentDataToAgent(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Now()
+	taskID := uuid.New()
+	agentData := AgentData{ID: "agent-1", Hostname: "builder-1", Version: "1.2.0", LastSeenAt: now.Add(-30 * time.Second)}
+
+	agent := agentData.toAgent(now, time.Minute, &taskID)
+	assert.Equal(agentStateOnline, agent.State)
+	assert.Equal(&taskID, agent.CurrentTaskID)
+
+	agent = agentData.toAgent(now.Add(time.Minute), time.Minute, nil)
+	assert.Equal(agentStateOffline, agent.State)
+	assert.Nil(agent.CurrentTaskID)
+}
+
+func TestHandlerAgentHeartbeat(t *testing.T) {
+	assert := assert.New(t)
+
+	// Mock
+	mockDB, mock, err := sqlmock.New()
+	assert.NoError(err)
+	defer func() { _ = mockDB.Close() }()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:                 mockDB,
+		DriverName:           "postgres",
+		PreferSimpleProtocol: true,
+	})
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	assert.NoError(err)
+
+	server := Server{db: db}
+	updateQuery := regexp.QuoteMeta(`UPDATE "agent_data" SET "last_seen_at"=$1 WHERE id = $2`)
+
+	heartbeat := func(id string) *http.Response {
+		req := httptest.NewRequest(http.MethodPost, "/agents/"+id+"/heartbeat", nil)
+		req = mux.SetURLVars(req, map[string]string{"id": id})
+		w := httptest.NewRecorder()
+		server.handleAgentHeartbeat(w, req)
+		return w.Result()
+	}
+
+	// Registered agent
+	mock.ExpectBegin()
+	mock.ExpectExec(updateQuery).
+		WithArgs(sqlmock.AnyArg(), "agent-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	resp := heartbeat("agent-1")
+	assert.Equal(http.StatusNoContent, resp.StatusCode)
+
+	// Unknown agent has to register again
+	mock.ExpectBegin()
+	mock.ExpectExec(updateQuery).
+		WithArgs(sqlmock.AnyArg(), "agent-2").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	resp = heartbeat("agent-2")
+	assert.Equal(http.StatusNotFound, resp.StatusCode)
+
+	assert.NoError(mock.ExpectationsWereMet())
+}
+
+func TestHandlerAgentSocket(t *testing.T) {
+	assert := assert.New(t)
+
+	// Mock
+	mockDB, mock, err := sqlmock.New()
+	assert.NoError(err)
+	defer func() { _ = mockDB.Close() }()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:                 mockDB,
+		DriverName:           "postgres",
+		PreferSimpleProtocol: true,
+	})
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	assert.NoError(err)
+
+	server := Server{db: db, cfg: &Config{LeaseDuration: time.Minute}, notifier: newTaskNotifier()}
+	router := mux.NewRouter()
+	router.HandleFunc("/agents/{id}/socket", server.handleAgentSocket)
+	httpServer := httptest.NewServer(router)
+	defer httpServer.Close()
+
+	countQuery := regexp.QuoteMeta(`SELECT count(*) FROM "agent_data" WHERE id = $1`)
+
+	// Unknown agent cannot open a socket
+	mock.ExpectQuery(countQuery).
+		WithArgs("agent-2").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+	_, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(httpServer.URL, "http")+"/agents/agent-2/socket", nil)
+	assert.Error(err)
+	assert.Equal(http.StatusForbidden, resp.StatusCode)
+
+	mock.ExpectQuery(countQuery).
+		WithArgs("agent-1").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	socketURL := "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/agents/agent-1/socket?queue=default"
+	conn, _, err := websocket.DefaultDialer.Dial(socketURL, nil)
+	assert.NoError(err)
+	defer conn.Close()
+
+	// Heartbeat of a task the agent does not hold
+	taskID, leaseID := uuid.New(), uuid.New()
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "task_data" WHERE id = $1`)).
+		WithArgs(taskID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectRollback()
+
+	assert.NoError(conn.WriteJSON(SocketMessage{Type: socketHeartbeat, ID: "1", TaskID: &taskID, LeaseID: &leaseID}))
+	var reply SocketMessage
+	assert.NoError(conn.ReadJSON(&reply))
+	assert.Equal(socketLeaseLost, reply.Type)
+	assert.Equal("1", reply.ID)
+
+	// Idle agent gets a queued task assigned
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "task_data" WHERE status = $1`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "command", "date", "status", "queue"}).
+			AddRow(taskID, "echo hello", time.Now(), statusQueued, defaultQueue))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "queue_data" WHERE name = $1`)).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "paused", "max_in_flight"}))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "task_data" SET`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "webhook_events"`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_notify($1, $2)`)).
+		WithArgs(taskLogsChannel, taskID.String()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	assert.NoError(conn.WriteJSON(SocketMessage{Type: socketReady}))
+	reply = SocketMessage{}
+	assert.NoError(conn.ReadJSON(&reply))
+	assert.Equal(socketAssign, reply.Type)
+	if assert.NotNil(reply.Task) {
+		assert.Equal(taskID, reply.Task.ID)
+		assert.Equal("agent-1", *reply.Task.AgentID)
+		assert.Equal(statusInProgress, reply.Task.Status)
+	}
+
+	assert.NoError(mock.ExpectationsWereMet())
+}
+
+func TestAgentSessionDispatchUndelivered(t *testing.T) {
+	assert := assert.New(t)
+
+	// Mock
+	mockDB, mock, err := sqlmock.New()
+	assert.NoError(err)
+	defer func() { _ = mockDB.Close() }()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:                 mockDB,
+		DriverName:           "postgres",
+		PreferSimpleProtocol: true,
+	})
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	assert.NoError(err)
+
+	server := Server{db: db, cfg: &Config{LeaseDuration: time.Minute}, notifier: newTaskNotifier()}
+	conns := make(chan *websocket.Conn, 1)
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := socketUpgrader.Upgrade(w, r, nil)
+		assert.NoError(err)
+		conns <- conn
+	}))
+	defer httpServer.Close()
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(httpServer.URL, "http"), nil)
+	assert.NoError(err)
+	defer client.Close()
+	conn := <-conns
+	// Writes to the socket fail from now on
+	assert.NoError(conn.UnderlyingConn().Close())
+
+	// The picked task is released as soon as the assignment cannot be written
+	taskID := uuid.New()
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "task_data" WHERE status = $1`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "command", "date", "status", "queue"}).
+			AddRow(taskID, "echo hello", time.Now(), statusQueued, defaultQueue))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "queue_data" WHERE name = $1`)).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "paused", "max_in_flight"}))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "task_data" SET`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "webhook_events"`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_notify($1, $2)`)).
+		WithArgs(taskLogsChannel, taskID.String()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "task_data" WHERE id = $1 ORDER BY "task_data"."id" LIMIT $2 FOR UPDATE`)).
+		WithArgs(taskID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status", "lease_id"}).
+			// Lease already taken back by the reaper
+			AddRow(taskID, statusQueued, nil))
+	mock.ExpectRollback()
+
+	session := agentSession{
+		s:          &server,
+		conn:       conn,
+		req:        pickRequest{AgentID: "agent-1", Queues: []string{defaultQueue}},
+		send:       make(chan outgoingMessage),
+		writerDone: make(chan struct{}),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go session.writeMessages(ctx)
+	session.dispatch(ctx)
+
+	assert.Eventually(func() bool {
+		return mock.ExpectationsWereMet() == nil
+	}, time.Second, 10*time.Millisecond)
+}
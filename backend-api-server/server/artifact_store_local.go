@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// localArtifactStore keeps artifacts as files below a directory.
+type localArtifactStore struct {
+	dir string
+}
+
+func newLocalArtifactStore(dir string) (*localArtifactStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	return &localArtifactStore{dir: dir}, nil
+}
+
+func (s *localArtifactStore) path(key string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(key))
+}
+
+// Put writes the contents to a temporary file first, so that readers never see
+// a partially written artifact.
+func (s *localArtifactStore) Put(ctx context.Context, key string, r io.ReadSeeker, size int64) error {
+	target := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(target), 0o750); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(target), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := io.CopyN(file, r, size); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), target)
+}
+
+func (s *localArtifactStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	file, err := os.Open(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errArtifactNotFound
+	}
+	return file, err
+}
+
+func (s *localArtifactStore) Delete(ctx context.Context, key string) error {
+	err := os.Remove(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// s3ArtifactStore keeps artifacts as objects of a bucket of an S3 compatible
+// object storage.
+type s3ArtifactStore struct {
+	client *s3.Client
+	bucket string
+}
+
+func newS3ArtifactStore(cfg *Config) *s3ArtifactStore {
+	client := s3.New(s3.Options{
+		Region:       cfg.S3Region,
+		Credentials:  credentials.NewStaticCredentialsProvider(cfg.S3AccessKeyID, cfg.S3SecretAccessKey, ""),
+		UsePathStyle: cfg.S3UsePathStyle,
+		BaseEndpoint: endpointOrNil(cfg.S3Endpoint),
+	})
+	return &s3ArtifactStore{client: client, bucket: cfg.S3Bucket}
+}
+
+// endpointOrNil lets the client resolve the AWS endpoint when no custom one
+// is configured.
+func endpointOrNil(endpoint string) *string {
+	if endpoint == "" {
+		return nil
+	}
+	return aws.String(endpoint)
+}
+
+func (s *s3ArtifactStore) Put(ctx context.Context, key string, r io.ReadSeeker, size int64) error {
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(s.bucket),
+		Key:           aws.String(key),
+		Body:          r,
+		ContentLength: aws.Int64(size),
+	})
+	return err
+}
+
+func (s *s3ArtifactStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return nil, errArtifactNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+func (s *s3ArtifactStore) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	artifactStoreLocal = "local"
+	artifactStoreS3    = "s3"
+)
+
+const maxArtifactPatterns = 32
+
+var errArtifactNotFound = errors.New("artifact not found")
+
+// ArtifactStore keeps the contents of the artifacts uploaded by executor
+// agents. Keys are slash separated paths.
+type ArtifactStore interface {
+	// Put stores size bytes read from r under key, replacing any earlier
+	// contents.
+	Put(ctx context.Context, key string, r io.ReadSeeker, size int64) error
+	// Get opens the contents stored under key, it returns errArtifactNotFound
+	// if there are none.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the contents stored under key, keys without contents
+	// are ignored.
+	Delete(ctx context.Context, key string) error
+}
+
+// ArtifactData describes a file a task has produced. Its contents live in the
+// artifact store, a later attempt replaces the artifacts of the same name.
+// The contents are kept under the id of the upload that stored them, so that
+// an upload never overwrites contents it has not recorded yet.
+type ArtifactData struct {
+	TaskID    uuid.UUID `json:"task_id" gorm:"type:uuid;primaryKey"`
+	Name      string    `json:"name" gorm:"primaryKey"`
+	Size      int64     `json:"size"`
+	Attempt   int       `json:"attempt"`
+	UploadID  uuid.UUID `json:"-" gorm:"type:uuid"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func artifactKey(taskID, uploadID uuid.UUID, name string) string {
+	return taskID.String() + "/" + uploadID.String() + "/" + name
+}
+
+func (d *ArtifactData) key() string {
+	return artifactKey(d.TaskID, d.UploadID, d.Name)
+}
+
+// deleteArtifactContents removes the contents of the artifacts from the
+// store. A failure only leaves unreferenced files behind.
+func (s *Server) deleteArtifactContents(artifacts []ArtifactData) {
+	for i := range artifacts {
+		key := artifacts[i].key()
+		if err := s.artifacts.Delete(context.Background(), key); err != nil {
+			log.Errorf("failed to delete %s from the artifact store: %v", key, err)
+		}
+	}
+}
+
+// validateArtifactName accepts clean relative slash separated paths, which
+// can neither escape the directory of the task nor collide with each other.
+func validateArtifactName(name string) error {
+	if name == "" || len(name) > 255 || path.IsAbs(name) || path.Clean(name) != name ||
+		name == ".." || strings.HasPrefix(name, "../") {
+		return fmt.Errorf("invalid artifact name: %q", name)
+	}
+	return nil
+}
+
+// validateArtifactPatterns checks the glob patterns of the artifacts a task
+// declares. They are matched relative to the working directory of the task.
+func validateArtifactPatterns(patterns []string) error {
+	if len(patterns) > maxArtifactPatterns {
+		return fmt.Errorf("a task can declare at most %d artifact patterns", maxArtifactPatterns)
+	}
+	for _, pattern := range patterns {
+		if err := validateArtifactName(pattern); err != nil {
+			return fmt.Errorf("invalid artifact pattern: %q", pattern)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid artifact pattern: %q", pattern)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestValidateArtifactPatterns(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError(validateArtifactPatterns(nil))
+	assert.NoError(validateArtifactPatterns([]string{"dist/*.tar.gz", "report.xml", "build/[a-z]*"}))
+	assert.Error(validateArtifactPatterns([]string{"/etc/passwd"}))
+	assert.Error(validateArtifactPatterns([]string{"../secrets/*"}))
+	assert.Error(validateArtifactPatterns([]string{"dist/../../x"}))
+	assert.Error(validateArtifactPatterns([]string{"dist/[a-"}))
+	assert.Error(validateArtifactPatterns([]string{""}))
+
+	assert.NoError(validateArtifactName("dist/app.tar.gz"))
+	assert.Error(validateArtifactName("./app"))
+	assert.Error(validateArtifactName(".."))
+}
+
+// testArtifactStore checks the behavior every artifact store has to provide.
+func testArtifactStore(t *testing.T, store ArtifactStore) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	key := artifactKey(uuid.New(), uuid.New(), "dist/app.tar.gz")
+
+	_, err := store.Get(ctx, key)
+	assert.ErrorIs(err, errArtifactNotFound)
+
+	assert.NoError(store.Put(ctx, key, strings.NewReader("first"), 5))
+	assert.NoError(store.Put(ctx, key, strings.NewReader("second"), 6))
+
+	contents, err := store.Get(ctx, key)
+	assert.NoError(err)
+	data, err := io.ReadAll(contents)
+	assert.NoError(err)
+	assert.NoError(contents.Close())
+	assert.Equal("second", string(data))
+
+	assert.NoError(store.Delete(ctx, key))
+	_, err = store.Get(ctx, key)
+	assert.ErrorIs(err, errArtifactNotFound)
+	assert.NoError(store.Delete(ctx, key))
+}
+
+func TestLocalArtifactStore(t *testing.T) {
+	store, err := newLocalArtifactStore(t.TempDir())
+	assert.NoError(t, err)
+	testArtifactStore(t, store)
+}
+
+func TestS3ArtifactStore(t *testing.T) {
+	backend := s3mem.New()
+	assert.NoError(t, backend.CreateBucket("artifacts"))
+	fake := httptest.NewServer(gofakes3.New(backend).Server())
+	defer fake.Close()
+
+	store := newS3ArtifactStore(&Config{
+		S3Endpoint:        fake.URL,
+		S3Region:          "us-east-1",
+		S3Bucket:          "artifacts",
+		S3AccessKeyID:     "test",
+		S3SecretAccessKey: "test",
+		S3UsePathStyle:    true,
+	})
+	testArtifactStore(t, store)
+}
+
+func TestHandlerArtifactsUpload(t *testing.T) {
+	assert := assert.New(t)
+
+	// Mock
+	mockDB, mock, err := sqlmock.New()
+	assert.NoError(err)
+	defer func() { _ = mockDB.Close() }()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:                 mockDB,
+		DriverName:           "postgres",
+		PreferSimpleProtocol: true,
+	})
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	assert.NoError(err)
+
+	dir := t.TempDir()
+	store, err := newLocalArtifactStore(dir)
+	assert.NoError(err)
+	server := Server{db: db, cfg: &Config{MaxArtifactSize: 16}, artifacts: store}
+	selectQuery := regexp.QuoteMeta(`SELECT * FROM "task_data" WHERE id = $1`)
+	insertQuery := regexp.QuoteMeta(`INSERT INTO "artifact_data"`)
+	artifactsQuery := regexp.QuoteMeta(`SELECT * FROM "artifact_data" WHERE task_id = $1 AND name IN ($2) FOR UPDATE`)
+	columns := []string{"id", "command", "date", "status", "attempt", "lease_id"}
+
+	uploadParts := func(id, leaseID uuid.UUID, files [][2]string) *http.Response {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		assert.NoError(form.WriteField("lease_id", leaseID.String()))
+		for _, file := range files {
+			part, err := form.CreateFormFile("file", file[0])
+			assert.NoError(err)
+			_, err = part.Write([]byte(file[1]))
+			assert.NoError(err)
+		}
+		assert.NoError(form.Close())
+
+		req := httptest.NewRequest(http.MethodPost, "/tasks/"+id.String()+"/artifacts", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		req = mux.SetURLVars(req, map[string]string{"id": id.String()})
+		w := httptest.NewRecorder()
+		server.handleUploadArtifacts(w, req)
+		return w.Result()
+	}
+	upload := func(id, leaseID uuid.UUID, files map[string]string) *http.Response {
+		var parts [][2]string
+		for name, contents := range files {
+			parts = append(parts, [2]string{name, contents})
+		}
+		return uploadParts(id, leaseID, parts)
+	}
+	storedFiles := func(taskID uuid.UUID) []string {
+		var files []string
+		root := filepath.Join(dir, taskID.String())
+		_ = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err == nil && entry.Type().IsRegular() {
+				rel, _ := filepath.Rel(root, path)
+				files = append(files, filepath.ToSlash(rel))
+			}
+			return nil
+		})
+		return files
+	}
+
+	// Artifact is stored and recorded, replacing the contents uploaded by an
+	// earlier attempt
+	taskID := uuid.New()
+	leaseID := uuid.New()
+	oldUploadID := uuid.New()
+	oldKey := artifactKey(taskID, oldUploadID, "dist/app.tar.gz")
+	assert.NoError(store.Put(context.Background(), oldKey, strings.NewReader("old"), 3))
+	var uploadID uuid.UUID
+	mock.ExpectQuery(selectQuery).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(taskID, "make", time.Now(), statusInProgress, 1, leaseID))
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectQuery).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(taskID, "make", time.Now(), statusInProgress, 1, leaseID))
+	mock.ExpectQuery(artifactsQuery).
+		WithArgs(taskID, "dist/app.tar.gz").
+		WillReturnRows(sqlmock.NewRows([]string{"task_id", "name", "upload_id"}).AddRow(taskID, "dist/app.tar.gz", oldUploadID))
+	mock.ExpectExec(insertQuery).
+		WithArgs(taskID, "dist/app.tar.gz", 4, 1, capturedUUID{&uploadID}, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	resp := upload(taskID, leaseID, map[string]string{"dist/app.tar.gz": "data"})
+	assert.Equal(http.StatusCreated, resp.StatusCode)
+	contents, err := store.Get(context.Background(), artifactKey(taskID, uploadID, "dist/app.tar.gz"))
+	assert.NoError(err)
+	data, _ := io.ReadAll(contents)
+	contents.Close()
+	assert.Equal("data", string(data))
+	_, err = store.Get(context.Background(), oldKey)
+	assert.ErrorIs(err, errArtifactNotFound)
+
+	// Lease lost while uploading - the stored contents are removed
+	mock.ExpectQuery(selectQuery).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(taskID, "make", time.Now(), statusInProgress, 1, leaseID))
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectQuery).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(taskID, "make", time.Now(), statusInProgress, 2, uuid.New()))
+	mock.ExpectRollback()
+
+	resp = upload(taskID, leaseID, map[string]string{"dist/app.tar.gz": "late"})
+	assert.Equal(http.StatusConflict, resp.StatusCode)
+	assert.Equal([]string{uploadID.String() + "/dist/app.tar.gz"}, storedFiles(taskID))
+
+	// Duplicate artifact names
+	mock.ExpectQuery(selectQuery).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(taskID, "make", time.Now(), statusInProgress, 1, leaseID))
+
+	resp = uploadParts(taskID, leaseID, [][2]string{{"report.txt", "a"}, {"report.txt", "b"}})
+	assert.Equal(http.StatusBadRequest, resp.StatusCode)
+	assert.Equal([]string{uploadID.String() + "/dist/app.tar.gz"}, storedFiles(taskID))
+
+	// Stale lease
+	mock.ExpectQuery(selectQuery).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(taskID, "make", time.Now(), statusInProgress, 2, uuid.New()))
+
+	resp = upload(taskID, leaseID, map[string]string{"dist/app.tar.gz": "data"})
+	assert.Equal(http.StatusConflict, resp.StatusCode)
+
+	// Artifact escaping the working directory
+	mock.ExpectQuery(selectQuery).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(taskID, "make", time.Now(), statusInProgress, 1, leaseID))
+
+	resp = upload(taskID, leaseID, map[string]string{"../../etc/passwd": "root"})
+	assert.Equal(http.StatusBadRequest, resp.StatusCode)
+
+	// Artifact over the size limit
+	mock.ExpectQuery(selectQuery).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(taskID, "make", time.Now(), statusInProgress, 1, leaseID))
+
+	resp = upload(taskID, leaseID, map[string]string{"big.bin": strings.Repeat("x", 17)})
+	assert.Equal(http.StatusRequestEntityTooLarge, resp.StatusCode)
+
+	assert.NoError(mock.ExpectationsWereMet())
+}
+
+// capturedUUID matches any UUID argument and keeps it.
+type capturedUUID struct {
+	id *uuid.UUID
+}
+
+func (c capturedUUID) Match(v driver.Value) bool {
+	value, ok := v.(string)
+	if !ok {
+		return false
+	}
+	id, err := uuid.Parse(value)
+	*c.id = id
+	return err == nil
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+var errInvalidBundle = errors.New("invalid input files")
+
+// bundleKey is where the input files of a task are kept in the artifact
+// store, as a single tarball the agent unpacks into the working directory.
+func bundleKey(taskID uuid.UUID) string {
+	return taskID.String() + "/bundle"
+}
+
+// deleteBundle removes the input files of a task that has not been created. A
+// failure only leaves an unreferenced file behind.
+func (s *Server) deleteBundle(taskID uuid.UUID) {
+	if err := s.artifacts.Delete(context.Background(), bundleKey(taskID)); err != nil {
+		log.Errorf("failed to delete the input files of task %s: %v", taskID, err)
+	}
+}
+
+// partFileName returns the file name of a multipart part as sent by the
+// client. Unlike multipart.Part.FileName it keeps the directories.
+func partFileName(part *multipart.Part) string {
+	_, params, _ := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+	return params["filename"]
+}
+
+// storeBundle stores the input files following the task in a multipart create
+// request. They are either a single "bundle" part holding a tar or tar.gz
+// archive, or "file" parts named by their path in the working directory,
+// which are packed into a tarball. It returns the size of the stored bundle.
+func (s *Server) storeBundle(r *http.Request, taskID uuid.UUID, reader *multipart.Reader) (int64, error) {
+	part, err := reader.NextPart()
+	if err == io.EOF {
+		return 0, fmt.Errorf("%w: no input files given", errInvalidBundle)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", errInvalidBundle, err)
+	}
+
+	if part.FormName() == "bundle" {
+		defer part.Close()
+		size, err := s.storeArtifact(r, bundleKey(taskID), part)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := reader.NextPart(); err != io.EOF {
+			return 0, fmt.Errorf("%w: bundle must be the only input", errInvalidBundle)
+		}
+		return size, nil
+	}
+
+	file, err := os.CreateTemp("", "bundle-*")
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		file.Close()
+		os.Remove(file.Name())
+	}()
+
+	compressed := gzip.NewWriter(file)
+	archive := tar.NewWriter(compressed)
+	for ; err == nil; part, err = reader.NextPart() {
+		if err := s.addBundleFile(archive, part); err != nil {
+			return 0, err
+		}
+	}
+	if err != io.EOF {
+		return 0, fmt.Errorf("%w: %v", errInvalidBundle, err)
+	}
+	if err := archive.Close(); err != nil {
+		return 0, err
+	}
+	if err := compressed.Close(); err != nil {
+		return 0, err
+	}
+
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	if size > s.cfg.MaxArtifactSize {
+		return 0, errArtifactTooLarge
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return size, s.artifacts.Put(r.Context(), bundleKey(taskID), file, size)
+}
+
+// addBundleFile appends a "file" part to the tarball. The tar header needs the
+// size of the file, so the part is spooled to a temporary file first.
+func (s *Server) addBundleFile(archive *tar.Writer, part *multipart.Part) error {
+	defer part.Close()
+	name := partFileName(part)
+	if part.FormName() != "file" {
+		return fmt.Errorf("%w: unexpected part %q", errInvalidBundle, part.FormName())
+	}
+	if err := validateArtifactName(name); err != nil {
+		return fmt.Errorf("%w: invalid file name %q", errInvalidBundle, name)
+	}
+
+	spool, err := os.CreateTemp("", "bundle-file-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		spool.Close()
+		os.Remove(spool.Name())
+	}()
+	size, err := io.Copy(spool, io.LimitReader(part, s.cfg.MaxArtifactSize+1))
+	if err != nil {
+		return err
+	}
+	if size > s.cfg.MaxArtifactSize {
+		return errArtifactTooLarge
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	// Uploaded files are made executable, so that scripts can be run
+	// directly.
+	err = archive.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0o755,
+		Size:     size,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(archive, spool)
+	return err
+}
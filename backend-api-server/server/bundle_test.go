@@ -0,0 +1,144 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestHandlerTaskCreateWithFiles(t *testing.T) {
+	assert := assert.New(t)
+
+	// Mock
+	mockDB, mock, err := sqlmock.New()
+	assert.NoError(err)
+	defer func() { _ = mockDB.Close() }()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:                 mockDB,
+		DriverName:           "postgres",
+		PreferSimpleProtocol: true,
+	})
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	assert.NoError(err)
+
+	dir := t.TempDir()
+	store, err := newLocalArtifactStore(dir)
+	assert.NoError(err)
+	server := Server{db: db, cfg: &Config{MaxArtifactSize: 1024}, artifacts: store}
+	storedBundles := func() int {
+		count := 0
+		_ = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+			if err == nil && entry.Type().IsRegular() {
+				count++
+			}
+			return nil
+		})
+		return count
+	}
+
+	create := func(task string, files map[string]string) *http.Response {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		assert.NoError(form.WriteField("task", task))
+		for name, contents := range files {
+			part, err := form.CreateFormFile("file", name)
+			assert.NoError(err)
+			_, err = part.Write([]byte(contents))
+			assert.NoError(err)
+		}
+		assert.NoError(form.Close())
+
+		req := httptest.NewRequest(http.MethodPost, "/tasks", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		w := httptest.NewRecorder()
+		server.handleCreateTask(w, req)
+		return w.Result()
+	}
+
+	// Files are packed into the bundle of the task
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "task_data"`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "webhook_events"`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_notify(`)).
+		WithArgs(taskQueuedChannel, defaultQueue).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	resp := create(`{"command": "./build.sh"}`, map[string]string{
+		"build.sh":   "#!/bin/sh\ncat src/main.c\n",
+		"src/main.c": "int main() {}\n",
+	})
+	assert.Equal(http.StatusCreated, resp.StatusCode)
+	var task Task
+	assert.NoError(json.NewDecoder(resp.Body).Decode(&task))
+	assert.NotNil(task.BundleSize)
+
+	contents, err := store.Get(context.Background(), bundleKey(task.ID))
+	assert.NoError(err)
+	defer contents.Close()
+	uncompressed, err := gzip.NewReader(contents)
+	assert.NoError(err)
+	archive := tar.NewReader(uncompressed)
+	files := map[string]string{}
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(err)
+		data, _ := io.ReadAll(archive)
+		files[header.Name] = string(data)
+		assert.Equal(int64(0o755), header.Mode)
+	}
+	assert.Equal(map[string]string{
+		"build.sh":   "#!/bin/sh\ncat src/main.c\n",
+		"src/main.c": "int main() {}\n",
+	}, files)
+
+	// Files escaping the working directory
+	resp = create(`{"command": "ls"}`, map[string]string{"../evil": "x"})
+	assert.Equal(http.StatusBadRequest, resp.StatusCode)
+
+	// Input files replace the working directory
+	resp = create(`{"command": "ls", "workdir": "/srv"}`, map[string]string{"a": "x"})
+	assert.Equal(http.StatusBadRequest, resp.StatusCode)
+
+	// Task part must lead the form
+	resp = create(`not json`, nil)
+	assert.Equal(http.StatusBadRequest, resp.StatusCode)
+
+	// Oversized bundle
+	resp = create(`{"command": "ls"}`, map[string]string{"big": string(bytes.Repeat([]byte{'x'}, 2048))})
+	assert.Equal(http.StatusRequestEntityTooLarge, resp.StatusCode)
+
+	// Task that fails to be saved leaves no bundle behind
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "task_data"`)).
+		WillReturnError(errors.New("connection reset"))
+	mock.ExpectRollback()
+
+	resp = create(`{"command": "./build.sh"}`, map[string]string{"build.sh": "#!/bin/sh\n"})
+	assert.Equal(http.StatusInternalServerError, resp.StatusCode)
+	assert.Equal(1, storedBundles())
+
+	assert.NoError(mock.ExpectationsWereMet())
+}
@@ -1,25 +1,109 @@
 package server
 
 import (
+	"time"
+
 	"github.com/caarlos0/env"
 	log "github.com/sirupsen/logrus"
 )
 
 type Config struct {
 	ServerPort string `env:"SERVER_PORT,required"`
+	// GRPCPort is where the gRPC API listens, it is disabled if empty.
+	GRPCPort   string `env:"GRPC_PORT" envDefault:"50051"`
 	DBHost     string `env:"DB_HOST,required"`
 	DBPort     string `env:"DB_PORT,required"`
 	DBUser     string `env:"DB_USER,required"`
 	DBPassword string `env:"DB_PASSWORD,required"`
 	DBName     string `env:"DB_NAME,required"`
+
+	LeaseDuration       time.Duration `env:"LEASE_DURATION" envDefault:"30s"`
+	ReaperInterval      time.Duration `env:"REAPER_INTERVAL" envDefault:"10s"`
+	MaxLeaseExpirations int           `env:"MAX_LEASE_EXPIRATIONS" envDefault:"3"`
+
+	// PriorityAgingInterval is the time a queued task has to wait to gain one
+	// extra priority level, so that low priority tasks cannot starve.
+	PriorityAgingInterval time.Duration `env:"PRIORITY_AGING_INTERVAL" envDefault:"5m"`
+
+	SchedulerInterval time.Duration `env:"SCHEDULER_INTERVAL" envDefault:"10s"`
+
+	// MaxPickWait caps the time a pick request waits for a task. Waiting
+	// requests are woken up by new tasks and look for a task every
+	// PickPollInterval anyway, e.g. for delayed tasks becoming due.
+	MaxPickWait      time.Duration `env:"MAX_PICK_WAIT" envDefault:"60s"`
+	PickPollInterval time.Duration `env:"PICK_POLL_INTERVAL" envDefault:"5s"`
+
+	// AgentOfflineAfter is the time without heartbeats after which an agent
+	// is shown as offline.
+	AgentOfflineAfter time.Duration `env:"AGENT_OFFLINE_AFTER" envDefault:"1m"`
+
+	// DefaultTaskTimeout applies to tasks created without timeout_seconds,
+	// MaxTaskTimeout caps the timeout of every task.
+	DefaultTaskTimeout time.Duration `env:"DEFAULT_TASK_TIMEOUT" envDefault:"1h"`
+	MaxTaskTimeout     time.Duration `env:"MAX_TASK_TIMEOUT" envDefault:"24h"`
+
+	// MaxBatchSize caps the number of tasks created by one batch request.
+	MaxBatchSize int `env:"MAX_BATCH_SIZE" envDefault:"1000"`
+
+	// IdempotencyKeyTTL is how long the Idempotency-Key of a task creation
+	// is remembered, retries within it get the task created first.
+	IdempotencyKeyTTL time.Duration `env:"IDEMPOTENCY_KEY_TTL" envDefault:"24h"`
+
+	// WebhookPollInterval is how often task events are dispatched to the
+	// webhooks and due deliveries are sent. A delivery is given up after
+	// WebhookMaxAttempts failed attempts.
+	WebhookPollInterval time.Duration `env:"WEBHOOK_POLL_INTERVAL" envDefault:"1s"`
+	WebhookTimeout      time.Duration `env:"WEBHOOK_TIMEOUT" envDefault:"10s"`
+	WebhookMaxAttempts  int           `env:"WEBHOOK_MAX_ATTEMPTS" envDefault:"10"`
+
+	// WebhookAllowPrivateTargets lets webhooks point to private, loopback and
+	// link-local addresses, e.g. for receivers next to the server.
+	WebhookAllowPrivateTargets bool `env:"WEBHOOK_ALLOW_PRIVATE_TARGETS"`
+
+	// WebhookRetention is how long dispatched task events and completed
+	// deliveries are kept.
+	WebhookRetention time.Duration `env:"WEBHOOK_RETENTION" envDefault:"168h"`
+
+	// SecretsKey is the base64 encoded 256 bit key secrets are encrypted
+	// with. It must not be checked in along with the deployment.
+	SecretsKey string `env:"SECRETS_KEY,required"`
+
+	// LogFollowInterval is how often followed task logs and watched tasks
+	// are read again without being notified of a change.
+	LogFollowInterval time.Duration `env:"LOG_FOLLOW_INTERVAL" envDefault:"30s"`
+
+	// LogRetention is how long the log chunks, and with them the output, of
+	// completed tasks are kept. Zero, the default, keeps them forever.
+	LogRetention time.Duration `env:"LOG_RETENTION" envDefault:"0"`
+
+	// ArtifactStore selects where artifacts are kept, "local" stores them
+	// below ArtifactDir, "s3" in a bucket of an S3 compatible storage.
+	ArtifactStore   string `env:"ARTIFACT_STORE" envDefault:"local"`
+	ArtifactDir     string `env:"ARTIFACT_DIR" envDefault:"artifacts"`
+	MaxArtifactSize int64  `env:"MAX_ARTIFACT_SIZE" envDefault:"1073741824"`
+
+	S3Endpoint        string `env:"S3_ENDPOINT"`
+	S3Region          string `env:"S3_REGION" envDefault:"us-east-1"`
+	S3Bucket          string `env:"S3_BUCKET"`
+	S3AccessKeyID     string `env:"S3_ACCESS_KEY_ID"`
+	S3SecretAccessKey string `env:"S3_SECRET_ACCESS_KEY"`
+	S3UsePathStyle    bool   `env:"S3_USE_PATH_STYLE"`
 }
 
+const redactedValue = "[REDACTED]"
+
 func NewConfig() *Config {
 	cfg := Config{}
 	if err := env.Parse(&cfg); err != nil {
 		log.Fatalf("Failed to parse env: %v", err)
 	}
-	log.Infof("Created config: %+v", cfg)
+	redacted := cfg
+	redacted.DBPassword = redactedValue
+	redacted.SecretsKey = redactedValue
+	if redacted.S3SecretAccessKey != "" {
+		redacted.S3SecretAccessKey = redactedValue
+	}
+	log.Infof("Created config: %+v", redacted)
 
 	return &cfg
 }
@@ -1,54 +1,357 @@
 package server
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
 )
 
 type TaskData struct {
-	ID         uuid.UUID  `json:"id" gorm:"type:uuid;primaryKey"`
+	ID         uuid.UUID  `json:"id" gorm:"type:uuid;primaryKey;index:idx_task_data_date_id,priority:2;index:idx_task_data_status_date_id,priority:3"`
 	Command    string     `json:"command"`
-	Date       time.Time  `json:"date" gorm:"autoCreateTime"`
+	Date       time.Time  `json:"date" gorm:"autoCreateTime;index:idx_task_data_date_id,priority:1;index:idx_task_data_status_date_id,priority:2;index:idx_task_data_pick,priority:2"`
+	StartedAt  *time.Time `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+	Status     string     `json:"status" gorm:"index:idx_task_data_status_date_id,priority:1"`
+	// The output of the last attempt is stored as its log chunks only, the
+	// ones with seq in (LogFrom, LogTo]. Output and its Stdout and Stderr
+	// views are filled from them, see loadOutput.
+	Stdout  *string      `json:"stdout" gorm:"-"`
+	Stderr  *string      `json:"stderr" gorm:"-"`
+	Output  []OutputLine `json:"output" gorm:"-"`
+	LogFrom int          `json:"-"`
+	LogTo   int          `json:"-"`
+	// The output stored in the task itself by earlier versions, it is only
+	// read for tasks that completed before the upgrade.
+	LegacyStdout     *string       `json:"-" gorm:"column:stdout"`
+	LegacyStderr     *string       `json:"-" gorm:"column:stderr"`
+	LegacyOutput     []OutputLine  `json:"-" gorm:"column:output;serializer:json"`
+	ExitCode         *int          `json:"exit_code"`
+	StatusReason     *string       `json:"status_reason"`
+	LeaseID          *uuid.UUID    `json:"lease_id" gorm:"type:uuid"`
+	AgentID          *string       `json:"agent_id" gorm:"index"`
+	LeaseExpiresAt   *time.Time    `json:"lease_expires_at"`
+	LeaseExpirations int           `json:"lease_expirations"`
+	MaxAttempts      int           `json:"max_attempts" gorm:"default:1"`
+	Attempt          int           `json:"attempt"`
+	Backoff          BackoffPolicy `json:"backoff" gorm:"embedded;embeddedPrefix:backoff_"`
+	NotBefore        *time.Time    `json:"not_before" gorm:"index"`
+	RunAt            *time.Time    `json:"run_at" gorm:"index"`
+	Priority         int           `json:"priority" gorm:"index"`
+	// PickRank orders queued tasks for picking, see rank.
+	PickRank       int64             `json:"-" gorm:"index:idx_task_data_pick,priority:1,sort:desc,where:status = 'queued'"`
+	ScheduleID     *uuid.UUID        `json:"schedule_id" gorm:"type:uuid;uniqueIndex:idx_task_data_schedule_fire"`
+	ScheduledFor   *time.Time        `json:"scheduled_for" gorm:"uniqueIndex:idx_task_data_schedule_fire"`
+	DependsOn      []uuid.UUID       `json:"depends_on" gorm:"-"`
+	PipelineID     *uuid.UUID        `json:"pipeline_id" gorm:"type:uuid;index"`
+	PipelineStep   *int              `json:"pipeline_step"`
+	TimeoutSeconds *int              `json:"timeout_seconds"`
+	Env            map[string]string `json:"env" gorm:"serializer:json"`
+	Secrets        map[string]string `json:"secrets" gorm:"serializer:json"`
+	Workdir        string            `json:"workdir"`
+	Artifacts      []string          `json:"artifacts" gorm:"serializer:json"`
+	BundleSize     *int64            `json:"bundle_size"`
+	Labels         map[string]string `json:"labels" gorm:"type:jsonb;serializer:json"`
+	Queue          string            `json:"queue" gorm:"index;default:default"`
+}
+
+// TaskAttempt keeps the result of a single execution of a task, so that
+// retried tasks don't lose the output of their earlier attempts.
+type TaskAttempt struct {
+	ID         uuid.UUID  `json:"id" gorm:"type:uuid;primaryKey"`
+	TaskID     uuid.UUID  `json:"task_id" gorm:"type:uuid;index"`
+	Number     int        `json:"number"`
 	StartedAt  *time.Time `json:"started_at"`
 	FinishedAt *time.Time `json:"finished_at"`
 	Status     string     `json:"status"`
-	Stdout     *string    `json:"stdout"`
-	Stderr     *string    `json:"stderr"`
-	ExitCode   *int       `json:"exit_code"`
+	AgentID    *string    `json:"agent_id"`
+	// Like the ones of the task, the output fields are filled from the log
+	// chunks with seq in (LogFrom, LogTo].
+	Stdout  *string      `json:"stdout" gorm:"-"`
+	Stderr  *string      `json:"stderr" gorm:"-"`
+	Output  []OutputLine `json:"output" gorm:"-"`
+	LogFrom int          `json:"-"`
+	LogTo   int          `json:"-"`
+	// Like the ones of the task, only read for attempts made before the
+	// upgrade.
+	LegacyStdout *string      `json:"-" gorm:"column:stdout"`
+	LegacyStderr *string      `json:"-" gorm:"column:stderr"`
+	LegacyOutput []OutputLine `json:"-" gorm:"column:output;serializer:json"`
+	ExitCode     *int         `json:"exit_code"`
+	Reason       *string      `json:"reason"`
 }
 
 func (t *Task) toTaskData() TaskData {
 	return TaskData{
-		ID:         t.ID,
-		Command:    t.Command,
-		StartedAt:  t.StartedAt,
-		FinishedAt: t.FinishedAt,
-		Status:     t.Status,
-		Stdout:     t.Stdout,
-		Stderr:     t.Stderr,
-		ExitCode:   t.ExitCode,
+		ID:             t.ID,
+		Command:        t.Command,
+		StartedAt:      t.StartedAt,
+		FinishedAt:     t.FinishedAt,
+		Status:         t.Status,
+		Stdout:         t.Stdout,
+		Stderr:         t.Stderr,
+		Output:         t.Output,
+		ExitCode:       t.ExitCode,
+		StatusReason:   t.StatusReason,
+		MaxAttempts:    t.MaxAttempts,
+		Attempt:        t.Attempt,
+		Backoff:        t.Backoff,
+		NotBefore:      t.NotBefore,
+		RunAt:          t.RunAt,
+		Priority:       t.Priority,
+		ScheduleID:     t.ScheduleID,
+		ScheduledFor:   t.ScheduledFor,
+		DependsOn:      t.DependsOn,
+		PipelineID:     t.PipelineID,
+		PipelineStep:   t.PipelineStep,
+		TimeoutSeconds: t.TimeoutSeconds,
+		Env:            t.Env,
+		Secrets:        t.Secrets,
+		Workdir:        t.Workdir,
+		Artifacts:      t.Artifacts,
+		BundleSize:     t.BundleSize,
+		Labels:         t.Labels,
+		Queue:          t.Queue,
 	}
 }
 
 func (d *TaskData) toTask() Task {
 	return Task{
-		ID:         d.ID,
-		Command:    d.Command,
-		StartedAt:  d.StartedAt,
-		FinishedAt: d.FinishedAt,
-		Status:     d.Status,
-		Stdout:     d.Stdout,
-		Stderr:     d.Stderr,
-		ExitCode:   d.ExitCode,
+		ID:             d.ID,
+		Command:        d.Command,
+		Date:           d.Date,
+		StartedAt:      d.StartedAt,
+		FinishedAt:     d.FinishedAt,
+		Status:         d.Status,
+		Stdout:         d.Stdout,
+		Stderr:         d.Stderr,
+		Output:         d.Output,
+		ExitCode:       d.ExitCode,
+		StatusReason:   d.StatusReason,
+		AgentID:        d.AgentID,
+		MaxAttempts:    d.MaxAttempts,
+		Attempt:        d.Attempt,
+		Backoff:        d.Backoff,
+		NotBefore:      d.NotBefore,
+		RunAt:          d.RunAt,
+		Priority:       d.Priority,
+		ScheduleID:     d.ScheduleID,
+		ScheduledFor:   d.ScheduledFor,
+		DependsOn:      d.DependsOn,
+		PipelineID:     d.PipelineID,
+		PipelineStep:   d.PipelineStep,
+		TimeoutSeconds: d.TimeoutSeconds,
+		Env:            d.Env,
+		Secrets:        d.Secrets,
+		Workdir:        d.Workdir,
+		Artifacts:      d.Artifacts,
+		BundleSize:     d.BundleSize,
+		Labels:         d.Labels,
+		Queue:          d.Queue,
+	}
+}
+
+// lease hands the task over to an executor agent until the lease expires. The agent has to renew it with heartbeats while running the task.
+func (d *TaskData) lease(duration time.Duration) {
+	now := time.Now()
+	leaseID := uuid.New()
+	expiresAt := now.Add(duration)
+	d.Status = statusInProgress
+	d.StartedAt = &now
+	d.NotBefore = nil
+	d.Attempt++
+	d.LeaseID = &leaseID
+	d.LeaseExpiresAt = &expiresAt
+}
+
+// rank stamps the creation date of a new task and the rank it is picked by.
+// Every agingInterval spent waiting since its creation is worth one priority
+// level, so that low priority tasks cannot starve. As the age of all tasks
+// grows alike, ordering by priority*agingInterval - date does the same as
+// ordering by priority plus age without depending on the current time, which
+// lets the pick use an index. Without an aging interval the rank is the
+// priority.
+func (d *TaskData) rank(agingInterval time.Duration) {
+	if d.Date.IsZero() {
+		d.Date = time.Now()
+	}
+	d.PickRank = int64(d.Priority)
+	if seconds := int64(agingInterval / time.Second); seconds > 0 {
+		d.PickRank = int64(d.Priority)*seconds - d.Date.Unix()
+	}
+}
+
+// releaseLease undoes the lease of a task that never reached the agent. A
+// task that was aborted in the meantime is cancelled.
+func (d *TaskData) releaseLease() {
+	d.LeaseID = nil
+	d.LeaseExpiresAt = nil
+	d.StartedAt = nil
+	d.AgentID = nil
+	d.Attempt--
+	if d.Status == statusCancelling {
+		now := time.Now()
+		reason := "cancelled before the agent got the task"
+		d.Status = statusCancelled
+		d.FinishedAt = &now
+		d.StatusReason = &reason
+		return
+	}
+	d.Status = statusQueued
+}
+
+// leasedTo reports whether the task is running under the given lease.
+func (d *TaskData) leasedTo(leaseID uuid.UUID) bool {
+	inProgress := d.Status == statusInProgress || d.Status == statusCancelling
+	return inProgress && d.LeaseID != nil && *d.LeaseID == leaseID
+}
+
+func (d *TaskData) renewLease(duration time.Duration) {
+	expiresAt := time.Now().Add(duration)
+	d.LeaseExpiresAt = &expiresAt
+}
+
+// expireLease takes back the task from an executor agent that stopped sending
+// heartbeats. The task is requeued unless it has lost its lease too many
+// times, or it was being cancelled anyway. The returned attempt records the
+// lost execution.
+func (d *TaskData) expireLease(maxExpirations int) TaskAttempt {
+	now := time.Now()
+	d.LeaseExpirations++
+	d.LeaseID = nil
+	d.LeaseExpiresAt = nil
+
+	attempt := d.newAttempt(now)
+	attempt.Status = statusFailed
+
+	var reason string
+	switch {
+	case d.Status == statusCancelling:
+		d.Status = statusCancelled
+		d.FinishedAt = &now
+		reason = "lease expired while cancelling"
+	case d.LeaseExpirations >= maxExpirations:
+		d.Status = statusFailed
+		d.FinishedAt = &now
+		reason = fmt.Sprintf("lease expired %d times, giving up", d.LeaseExpirations)
+	default:
+		d.Status = statusQueued
+		d.StartedAt = nil
+		reason = fmt.Sprintf("lease expired (%d of %d), requeued", d.LeaseExpirations, maxExpirations)
 	}
+	d.StatusReason = &reason
+	attempt.Reason = &reason
+
+	return attempt
 }
 
-func (d *TaskData) finish(u TaskResult) {
+// finish stores the result reported by the executor agent. A failed task is
+// put back to the queue with a backoff delay until it runs out of attempts.
+// The returned attempt keeps the result in the history of the task.
+func (d *TaskData) finish(u TaskResult) TaskAttempt {
 	now := time.Now()
 	d.FinishedAt = &now
-	d.Status = u.Status
+	if u.Output != nil {
+		u.Stdout = outputView(u.Output, streamStdout)
+		u.Stderr = outputView(u.Output, streamStderr)
+	}
 	d.Stdout = u.Stdout
 	d.Stderr = u.Stderr
+	d.Output = u.Output
 	d.ExitCode = u.ExitCode
+	d.LeaseID = nil
+	d.LeaseExpiresAt = nil
+
+	attempt := d.newAttempt(now)
+	attempt.Status = u.Status
+	attempt.Stdout = u.Stdout
+	attempt.Stderr = u.Stderr
+	attempt.Output = u.Output
+	attempt.ExitCode = u.ExitCode
+
+	switch {
+	case d.Status == statusCancelling:
+		d.Status = statusCancelled
+		attempt.Status = statusCancelled
+	case u.failed() && d.Attempt < d.MaxAttempts:
+		notBefore := now.Add(d.Backoff.delay(d.Attempt))
+		reason := fmt.Sprintf("attempt %d of %d failed, retrying after %s",
+			d.Attempt, d.MaxAttempts, notBefore.Format(time.RFC3339))
+		// The results of the failed attempt are kept in its attempt only.
+		d.Status = statusQueued
+		d.StartedAt = nil
+		d.FinishedAt = nil
+		d.Stdout = nil
+		d.Stderr = nil
+		d.Output = nil
+		d.ExitCode = nil
+		d.NotBefore = &notBefore
+		d.StatusReason = &reason
+		attempt.Reason = &reason
+	default:
+		d.Status = u.Status
+	}
+
+	return attempt
+}
+
+// cancel cancels a queued task right away, while a running task is flagged as
+// cancelling until its executor agent has killed the command. It reports
+// false if the task has already completed.
+func (d *TaskData) cancel() bool {
+	switch d.Status {
+	case statusQueued:
+		now := time.Now()
+		d.Status = statusCancelled
+		d.FinishedAt = &now
+	case statusInProgress, statusCancelling:
+		d.Status = statusCancelling
+	default:
+		return false
+	}
+	return true
+}
+
+// requeue puts a completed task back to the queue to run it once more. The
+// results of its earlier attempts are kept in its attempts.
+func (d *TaskData) requeue() {
+	reason := "requeued"
+	d.Status = statusQueued
+	d.StartedAt = nil
+	d.FinishedAt = nil
+	d.NotBefore = nil
+	d.Stdout = nil
+	d.Stderr = nil
+	d.Output = nil
+	d.LegacyStdout = nil
+	d.LegacyStderr = nil
+	d.LegacyOutput = nil
+	d.ExitCode = nil
+	d.Attempt = 0
+	d.LeaseExpirations = 0
+	d.StatusReason = &reason
+}
+
+// fail completes the task as failed without executing it.
+func (d *TaskData) fail(reason string) {
+	now := time.Now()
+	d.Status = statusFailed
+	d.FinishedAt = &now
+	d.StatusReason = &reason
+}
+
+func (d *TaskData) newAttempt(finishedAt time.Time) TaskAttempt {
+	return TaskAttempt{
+		ID:         uuid.New(),
+		TaskID:     d.ID,
+		Number:     d.Attempt,
+		StartedAt:  d.StartedAt,
+		FinishedAt: &finishedAt,
+		AgentID:    d.AgentID,
+	}
+}
+
+func intPointer(i int) *int {
+	return &i
 }
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTaskDataExpireLease(t *testing.T) {
+	assert := assert.New(t)
+
+	taskData := TaskData{ID: uuid.New(), Command: "sleep 100", Status: statusQueued}
+
+	// Expired lease - task is requeued
+	taskData.lease(time.Minute)
+	assert.Equal(statusInProgress, taskData.Status)
+	assert.NotNil(taskData.LeaseID)
+	assert.NotNil(taskData.StartedAt)
+
+	taskData.expireLease(2)
+	assert.Equal(statusQueued, taskData.Status)
+	assert.Nil(taskData.LeaseID)
+	assert.Nil(taskData.LeaseExpiresAt)
+	assert.Nil(taskData.StartedAt)
+	assert.Equal(1, taskData.LeaseExpirations)
+	assert.Contains(*taskData.StatusReason, "requeued")
+
+	// Expired lease too many times - task fails
+	taskData.lease(time.Minute)
+	taskData.expireLease(2)
+	assert.Equal(statusFailed, taskData.Status)
+	assert.NotNil(taskData.FinishedAt)
+	assert.Contains(*taskData.StatusReason, "giving up")
+
+	// Expired lease while cancelling - task is cancelled
+	taskData = TaskData{ID: uuid.New(), Command: "sleep 100", Status: statusQueued}
+	taskData.lease(time.Minute)
+	taskData.Status = statusCancelling
+	taskData.expireLease(2)
+	assert.Equal(statusCancelled, taskData.Status)
+	assert.NotNil(taskData.FinishedAt)
+}
+
+func TestTaskDataLeasedTo(t *testing.T) {
+	assert := assert.New(t)
+
+	taskData := TaskData{ID: uuid.New(), Command: "true", Status: statusQueued}
+	assert.False(taskData.leasedTo(uuid.Nil))
+	taskData.lease(time.Minute)
+
+	assert.True(taskData.leasedTo(*taskData.LeaseID))
+	assert.False(taskData.leasedTo(uuid.Nil))
+	assert.False(taskData.leasedTo(uuid.New()))
+
+	// A task that lost its lease is not leased to anyone
+	leaseID := *taskData.LeaseID
+	taskData.expireLease(3)
+	assert.False(taskData.leasedTo(leaseID))
+}
+
+func TestTaskDataReleaseLease(t *testing.T) {
+	assert := assert.New(t)
+
+	taskData := TaskData{ID: uuid.New(), Command: "true", Status: statusQueued}
+	agentID := "agent-1"
+	taskData.lease(time.Minute)
+	taskData.AgentID = &agentID
+
+	// Lease that never reached the agent does not count as an attempt
+	taskData.releaseLease()
+	assert.Equal(statusQueued, taskData.Status)
+	assert.Equal(0, taskData.Attempt)
+	assert.Nil(taskData.LeaseID)
+	assert.Nil(taskData.StartedAt)
+	assert.Nil(taskData.AgentID)
+
+	// Task aborted in the meantime is cancelled
+	taskData.lease(time.Minute)
+	taskData.Status = statusCancelling
+	taskData.releaseLease()
+	assert.Equal(statusCancelled, taskData.Status)
+	assert.NotNil(taskData.FinishedAt)
+}
+
+func TestTaskDataSkip(t *testing.T) {
+	assert := assert.New(t)
+
+	taskData := TaskData{ID: uuid.New(), Command: "true", Status: statusQueued}
+
+	// Skipped task is completed without having started
+	taskData.skip("upstream task failed")
+	assert.Equal(statusSkipped, taskData.Status)
+	assert.Equal("upstream task failed", *taskData.StatusReason)
+	assert.NotNil(taskData.FinishedAt)
+	assert.Nil(taskData.StartedAt)
+}
+
+func TestTaskDataRequeue(t *testing.T) {
+	assert := assert.New(t)
+
+	exitCode := 1
+	stdout := "boom"
+	notBefore := time.Now().Add(time.Minute)
+	taskData := TaskData{
+		ID: uuid.New(), Command: "false", Status: statusFailed, Attempt: 3, MaxAttempts: 3,
+		ExitCode: &exitCode, Stdout: &stdout, NotBefore: &notBefore, LeaseExpirations: 1,
+	}
+
+	// Requeued task gets all its attempts back
+	taskData.requeue()
+	assert.Equal(statusQueued, taskData.Status)
+	assert.Equal(0, taskData.Attempt)
+	assert.Equal(0, taskData.LeaseExpirations)
+	assert.Nil(taskData.ExitCode)
+	assert.Nil(taskData.Stdout)
+	assert.Nil(taskData.NotBefore)
+	assert.Nil(taskData.FinishedAt)
+}
+
+func TestTaskDataFinishRetries(t *testing.T) {
+	assert := assert.New(t)
+
+	taskData := TaskData{
+		ID:          uuid.New(),
+		Command:     "flaky",
+		Status:      statusQueued,
+		MaxAttempts: 2,
+		Backoff:     BackoffPolicy{InitialSeconds: 30, Multiplier: 2, MaxSeconds: 60},
+	}
+	stdout := "first"
+
+	// First attempt fails - task is requeued with backoff
+	taskData.lease(time.Minute)
+	attempt := taskData.finish(TaskResult{Status: statusFinished, Stdout: &stdout, ExitCode: intPointer(1)})
+	assert.Equal(statusQueued, taskData.Status)
+	assert.Nil(taskData.StartedAt)
+	assert.Nil(taskData.FinishedAt)
+	assert.NotNil(taskData.NotBefore)
+	assert.WithinDuration(time.Now().Add(30*time.Second), *taskData.NotBefore, time.Second)
+	assert.Equal(1, attempt.Number)
+	assert.Equal(taskData.ID, attempt.TaskID)
+	assert.Equal("first", *attempt.Stdout)
+	assert.NotNil(attempt.Reason)
+	assert.Nil(taskData.Stdout)
+	assert.Nil(taskData.ExitCode)
+
+	// Second attempt fails - attempts are used up
+	stdout = "second"
+	taskData.lease(time.Minute)
+	assert.Nil(taskData.NotBefore)
+	attempt = taskData.finish(TaskResult{Status: statusFailed, Stdout: &stdout, ExitCode: intPointer(1)})
+	assert.Equal(statusFailed, taskData.Status)
+	assert.NotNil(taskData.FinishedAt)
+	assert.Equal(2, attempt.Number)
+	assert.Equal("second", *attempt.Stdout)
+	assert.Equal("second", *taskData.Stdout)
+	assert.Equal(1, *taskData.ExitCode)
+}
+
+func TestTaskDataFinishOutput(t *testing.T) {
+	assert := assert.New(t)
+
+	taskData := TaskData{ID: uuid.New(), Command: "build", Status: statusQueued, MaxAttempts: 1}
+	now := time.Now()
+	output := []OutputLine{
+		{Stream: streamStdout, Data: "compiling\n", Time: now},
+		{Stream: streamStderr, Data: "warning: unused\n", Time: now.Add(time.Millisecond)},
+		{Stream: streamStdout, Data: "done\n", Time: now.Add(2 * time.Millisecond)},
+	}
+
+	// Stdout and stderr are derived from the interleaved output
+	taskData.lease(time.Minute)
+	attempt := taskData.finish(TaskResult{Status: statusFinished, Output: output, ExitCode: intPointer(0)})
+	assert.Equal(statusFinished, taskData.Status)
+	assert.Equal(output, taskData.Output)
+	assert.Equal("compiling\ndone\n", *taskData.Stdout)
+	assert.Equal("warning: unused\n", *taskData.Stderr)
+	assert.Equal(output, attempt.Output)
+	assert.Equal("compiling\ndone\n", *attempt.Stdout)
+
+	assert.NoError(validateOutput(output))
+	assert.Equal(errInvalidStream, validateOutput([]OutputLine{{Stream: "stdin"}}))
+}
+
+func TestTaskDataRank(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Now()
+	urgent := TaskData{Priority: 1, Date: now}
+	old := TaskData{Priority: 0, Date: now.Add(-2 * time.Minute)}
+	urgent.rank(time.Minute)
+	old.rank(time.Minute)
+
+	// Waiting two aging intervals beats one priority level
+	assert.Greater(old.PickRank, urgent.PickRank)
+	old.Date = now.Add(-30 * time.Second)
+	old.rank(time.Minute)
+	assert.Less(old.PickRank, urgent.PickRank)
+
+	// Without aging only the priority counts
+	old.rank(0)
+	assert.Equal(int64(0), old.PickRank)
+
+	fresh := TaskData{Priority: 3}
+	fresh.rank(time.Minute)
+	assert.False(fresh.Date.IsZero())
+}
+
+func TestBackoffPolicyDelay(t *testing.T) {
+	assert := assert.New(t)
+
+	backoff := BackoffPolicy{InitialSeconds: 10, Multiplier: 3, MaxSeconds: 100}
+	assert.Equal(10*time.Second, backoff.delay(1))
+	assert.Equal(30*time.Second, backoff.delay(2))
+	assert.Equal(90*time.Second, backoff.delay(3))
+	assert.Equal(100*time.Second, backoff.delay(4))
+
+	// Policies without a maximum are capped all the same, however many
+	// attempts failed
+	backoff = BackoffPolicy{InitialSeconds: 10, Multiplier: 10}
+	assert.Equal(maxBackoffSeconds*time.Second, backoff.delay(100))
+	assert.Equal(maxBackoffSeconds*time.Second, backoff.delay(100000))
+	assert.Equal(time.Duration(0), BackoffPolicy{Multiplier: 10}.delay(100000))
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TaskDependency is an edge of the task graph: the task can only be picked
+// once its parent has finished successfully. With AllowFailure it is enough
+// for the parent to complete in any way.
+type TaskDependency struct {
+	TaskID       uuid.UUID `gorm:"type:uuid;primaryKey"`
+	ParentID     uuid.UUID `gorm:"type:uuid;primaryKey;index"`
+	AllowFailure bool
+}
+
+var errUnknownParent = errors.New("depends_on refers to an unknown task")
+
+// pendingDependencies matches queued tasks that still wait for a parent.
+const pendingDependencies = `EXISTS (
+	SELECT 1 FROM task_dependencies d JOIN task_data p ON p.id = d.parent_id
+	WHERE d.task_id = task_data.id AND NOT (
+		(p.status = 'finished' AND p.exit_code = 0) OR
+		(d.allow_failure AND p.status IN ('finished', 'failed', 'cancelled', 'skipped', 'timed_out'))))`
+
+// blockedDependencies matches tasks with a parent that completed without
+// success and does not allow failure, such tasks would never become pickable.
+const blockedDependencies = `EXISTS (
+	SELECT 1 FROM task_dependencies d JOIN task_data p ON p.id = d.parent_id
+	WHERE d.task_id = task_data.id AND NOT d.allow_failure AND
+		p.status IN ('finished', 'failed', 'cancelled', 'skipped', 'timed_out') AND
+		NOT (p.status = 'finished' AND p.exit_code IS NOT DISTINCT FROM 0))`
+
+func (d *TaskData) completed() bool {
+	switch d.Status {
+	case statusFinished, statusFailed, statusCancelled, statusSkipped, statusTimedOut:
+		return true
+	}
+	return false
+}
+
+func (d *TaskData) succeeded() bool {
+	return d.Status == statusFinished && d.ExitCode != nil && *d.ExitCode == 0
+}
+
+// skip completes a task that will never run, it has no start time.
+func (d *TaskData) skip(reason string) {
+	now := time.Now()
+	d.Status = statusSkipped
+	d.StatusReason = &reason
+	d.FinishedAt = &now
+}
+
+// createTask saves a new task together with its dependencies. A task whose
+// parent has already failed is skipped right away, unless allowFailure is set.
+func createTask(tx *gorm.DB, taskData *TaskData, allowFailure bool) error {
+	if len(taskData.DependsOn) > 0 {
+		// Parents are share locked so that none of them can complete before
+		// the dependencies are saved, which would leave the task waiting
+		// forever.
+		var parentsData []TaskData
+		err := tx.
+			Select("id", "status", "exit_code").
+			Where("id IN ?", taskData.DependsOn).
+			Clauses(clause.Locking{Strength: "SHARE"}).
+			Find(&parentsData).Error
+		if err != nil {
+			return err
+		}
+		if len(parentsData) != len(uniqueIDs(taskData.DependsOn)) {
+			return errUnknownParent
+		}
+		for _, parentData := range parentsData {
+			if !allowFailure && parentData.completed() && !parentData.succeeded() {
+				taskData.skip(fmt.Sprintf("upstream task %s did not succeed", parentData.ID))
+				break
+			}
+		}
+	}
+
+	if err := tx.Create(taskData).Error; err != nil {
+		return err
+	}
+	if err := recordTaskEvent(tx, eventTaskCreated, taskData); err != nil {
+		return err
+	}
+
+	if len(taskData.DependsOn) == 0 {
+		return nil
+	}
+	dependencies := make([]TaskDependency, 0, len(taskData.DependsOn))
+	for _, parentID := range uniqueIDs(taskData.DependsOn) {
+		dependencies = append(dependencies, TaskDependency{
+			TaskID:       taskData.ID,
+			ParentID:     parentID,
+			AllowFailure: allowFailure,
+		})
+	}
+	return tx.Create(&dependencies).Error
+}
+
+// skipDependents skips the queued tasks depending on a task that completed
+// without success, and the tasks depending on those, and so on. Each skipped
+// task gets its event recorded.
+func skipDependents(tx *gorm.DB, taskData *TaskData) error {
+	if !taskData.completed() || taskData.succeeded() {
+		return nil
+	}
+
+	parentIDs := []uuid.UUID{taskData.ID}
+	for len(parentIDs) > 0 {
+		var dependentsData []TaskData
+		err := tx.
+			Where("status = ?", statusQueued).
+			Where("id IN (SELECT task_id FROM task_dependencies WHERE parent_id IN ? AND NOT allow_failure)", parentIDs).
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Find(&dependentsData).Error
+		if err != nil {
+			return err
+		}
+
+		parentIDs = parentIDs[:0]
+		for i := range dependentsData {
+			dependentData := &dependentsData[i]
+			dependentData.skip(fmt.Sprintf("upstream task %s did not succeed", taskData.ID))
+			if err := tx.Save(dependentData).Error; err != nil {
+				return err
+			}
+			if err := recordTaskEvent(tx, eventTaskSkipped, dependentData); err != nil {
+				return err
+			}
+			parentIDs = append(parentIDs, dependentData.ID)
+		}
+	}
+	return nil
+}
+
+// loadDependencies fills the parents of the given tasks.
+func loadDependencies(db *gorm.DB, tasksData []TaskData) error {
+	if len(tasksData) == 0 {
+		return nil
+	}
+	byID := make(map[uuid.UUID]*TaskData, len(tasksData))
+	ids := make([]uuid.UUID, len(tasksData))
+	for i := range tasksData {
+		byID[tasksData[i].ID] = &tasksData[i]
+		ids[i] = tasksData[i].ID
+	}
+
+	var dependencies []TaskDependency
+	if err := db.Where("task_id IN ?", ids).Find(&dependencies).Error; err != nil {
+		return err
+	}
+	for _, dependency := range dependencies {
+		taskData := byID[dependency.TaskID]
+		taskData.DependsOn = append(taskData.DependsOn, dependency.ParentID)
+	}
+	return nil
+}
+
+func uniqueIDs(ids []uuid.UUID) []uuid.UUID {
+	seen := make(map[uuid.UUID]bool, len(ids))
+	unique := make([]uuid.UUID, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"backend-api-server/taskpb"
+
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// The gRPC messages mirror the JSON payloads of the REST API, these helpers
+// convert between the two.
+
+func toProtoTask(t Task) *taskpb.Task {
+	task := &taskpb.Task{
+		Id:             t.ID.String(),
+		Command:        t.Command,
+		StartedAt:      toTimestamp(t.StartedAt),
+		FinishedAt:     toTimestamp(t.FinishedAt),
+		Status:         t.Status,
+		Stdout:         t.Stdout,
+		Stderr:         t.Stderr,
+		Output:         toProtoOutput(t.Output),
+		ExitCode:       toInt32(t.ExitCode),
+		StatusReason:   t.StatusReason,
+		MaxAttempts:    int32(t.MaxAttempts),
+		Attempt:        int32(t.Attempt),
+		Backoff:        toProtoBackoff(t.Backoff),
+		NotBefore:      toTimestamp(t.NotBefore),
+		RunAt:          toTimestamp(t.RunAt),
+		Priority:       int32(t.Priority),
+		ScheduleId:     uuidString(t.ScheduleID),
+		ScheduledFor:   toTimestamp(t.ScheduledFor),
+		DependsOn:      uuidStrings(t.DependsOn),
+		PipelineId:     uuidString(t.PipelineID),
+		PipelineStep:   toInt32(t.PipelineStep),
+		TimeoutSeconds: toInt32(t.TimeoutSeconds),
+		Env:            t.Env,
+		Workdir:        t.Workdir,
+		Secrets:        t.Secrets,
+		Artifacts:      t.Artifacts,
+		BundleSize:     t.BundleSize,
+		AgentId:        t.AgentID,
+		Labels:         t.Labels,
+		Queue:          t.Queue,
+	}
+	// The date is not loaded if a listing leaves it out.
+	if !t.Date.IsZero() {
+		task.Date = timestamppb.New(t.Date)
+	}
+	return task
+}
+
+func toProtoPickedTask(t *PickedTask) *taskpb.PickedTask {
+	return &taskpb.PickedTask{
+		Task:           toProtoTask(t.Task),
+		LeaseId:        t.LeaseID.String(),
+		LeaseExpiresAt: timestamppb.New(t.LeaseExpiresAt),
+		TimeoutSeconds: int32(t.TimeoutSeconds),
+		SecretEnv:      t.SecretEnv,
+	}
+}
+
+func toProtoLogChunk(c TaskLogChunk) *taskpb.LogChunk {
+	return &taskpb.LogChunk{
+		Seq:     int64(c.Seq),
+		Attempt: int32(c.Attempt),
+		Stream:  c.Stream,
+		Data:    c.Data,
+		Time:    timestamppb.New(c.Time),
+	}
+}
+
+func toProtoBackoff(b BackoffPolicy) *taskpb.BackoffPolicy {
+	return &taskpb.BackoffPolicy{
+		InitialSeconds: int32(b.InitialSeconds),
+		Multiplier:     b.Multiplier,
+		MaxSeconds:     int32(b.MaxSeconds),
+	}
+}
+
+func toProtoOutput(lines []OutputLine) []*taskpb.OutputLine {
+	if lines == nil {
+		return nil
+	}
+	output := make([]*taskpb.OutputLine, len(lines))
+	for i, line := range lines {
+		output[i] = &taskpb.OutputLine{Stream: line.Stream, Data: line.Data, Time: timestamppb.New(line.Time)}
+	}
+	return output
+}
+
+func fromProtoOutput(output []*taskpb.OutputLine) []OutputLine {
+	if output == nil {
+		return nil
+	}
+	lines := make([]OutputLine, len(output))
+	for i, line := range output {
+		lines[i] = OutputLine{Stream: line.GetStream(), Data: line.GetData(), Time: line.GetTime().AsTime()}
+	}
+	return lines
+}
+
+// toTaskCreate converts the request to the payload of POST /tasks.
+func toTaskCreate(req *taskpb.CreateTaskRequest) (TaskCreate, error) {
+	dependsOn, err := parseUUIDs(req.GetDependsOn())
+	if err != nil {
+		return TaskCreate{}, fmt.Errorf("invalid depends_on: %w", err)
+	}
+	create := TaskCreate{
+		Command:        req.GetCommand(),
+		MaxAttempts:    fromInt32(req.MaxAttempts),
+		Priority:       int(req.GetPriority()),
+		DependsOn:      dependsOn,
+		TimeoutSeconds: fromInt32(req.TimeoutSeconds),
+		Env:            req.GetEnv(),
+		Workdir:        req.GetWorkdir(),
+		Secrets:        req.GetSecrets(),
+		Artifacts:      req.GetArtifacts(),
+		Labels:         req.GetLabels(),
+		Queue:          req.GetQueue(),
+	}
+	if req.Backoff != nil {
+		create.Backoff = &BackoffPolicy{
+			InitialSeconds: int(req.Backoff.GetInitialSeconds()),
+			Multiplier:     req.Backoff.GetMultiplier(),
+			MaxSeconds:     int(req.Backoff.GetMaxSeconds()),
+		}
+	}
+	if req.RunAt != nil {
+		runAt := req.RunAt.AsTime()
+		create.RunAt = &runAt
+	}
+	return create, nil
+}
+
+// toTaskQuery converts the request to the query of GET /tasks.
+func toTaskQuery(req *taskpb.ListTasksRequest) TaskQuery {
+	query := TaskQuery{
+		TaskFilter: TaskFilter{
+			Statuses: req.GetStatuses(),
+			ExitCode: fromInt32(req.ExitCode),
+			Command:  req.GetCommand(),
+		},
+		Sort:   req.GetSort(),
+		Limit:  int(req.GetLimit()),
+		Cursor: req.GetCursor(),
+		Fields: req.GetFields(),
+	}
+	if req.CreatedAfter != nil {
+		createdAfter := req.CreatedAfter.AsTime()
+		query.CreatedAfter = &createdAfter
+	}
+	if req.CreatedBefore != nil {
+		createdBefore := req.CreatedBefore.AsTime()
+		query.CreatedBefore = &createdBefore
+	}
+	return query
+}
+
+func toTimestamp(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
+
+func toInt32(i *int) *int32 {
+	if i == nil {
+		return nil
+	}
+	v := int32(*i)
+	return &v
+}
+
+func fromInt32(i *int32) *int {
+	if i == nil {
+		return nil
+	}
+	v := int(*i)
+	return &v
+}
+
+func uuidString(id *uuid.UUID) *string {
+	if id == nil {
+		return nil
+	}
+	s := id.String()
+	return &s
+}
+
+func uuidStrings(ids []uuid.UUID) []string {
+	if ids == nil {
+		return nil
+	}
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = id.String()
+	}
+	return s
+}
+
+func parseUUIDs(s []string) ([]uuid.UUID, error) {
+	if s == nil {
+		return nil, nil
+	}
+	ids := make([]uuid.UUID, len(s))
+	for i, v := range s {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
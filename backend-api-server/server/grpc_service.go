@@ -0,0 +1,224 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"backend-api-server/taskpb"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// taskService serves the gRPC API. It shares the storage layer with the REST
+// handlers, only the messages differ.
+type taskService struct {
+	taskpb.UnimplementedTaskServiceServer
+	s *Server
+}
+
+func (t *taskService) CreateTask(ctx context.Context, req *taskpb.CreateTaskRequest) (*taskpb.Task, error) {
+	taskCreate, err := toTaskCreate(req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	task, err := taskCreate.toTask(t.s.cfg.MaxTaskTimeout)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	task, err = t.s.submitTask(task, nil)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return toProtoTask(task), nil
+}
+
+func (t *taskService) ListTasks(ctx context.Context, req *taskpb.ListTasksRequest) (*taskpb.ListTasksResponse, error) {
+	page, err := t.s.listTasks(toTaskQuery(req))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	response := &taskpb.ListTasksResponse{
+		Tasks:      make([]*taskpb.Task, len(page.Tasks)),
+		NextCursor: page.NextCursor,
+	}
+	for i, task := range page.Tasks {
+		response.Tasks[i] = toProtoTask(task)
+	}
+	return response, nil
+}
+
+func (t *taskService) GetTask(ctx context.Context, req *taskpb.GetTaskRequest) (*taskpb.Task, error) {
+	taskID, err := uuid.Parse(req.GetId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid id format")
+	}
+	task, err := t.s.getTask(taskID)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return toProtoTask(task), nil
+}
+
+func (t *taskService) PickTask(ctx context.Context, req *taskpb.PickTaskRequest) (*taskpb.PickedTask, error) {
+	pick := pickRequest{AgentID: req.GetAgentId(), Labels: req.GetLabels(), Queues: req.GetQueues()}
+	if len(pick.Queues) == 0 {
+		pick.Queues = []string{defaultQueue}
+	}
+	if err := validateLabels(pick.Labels); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if pick.AgentID != "" {
+		if !agentIDPattern.MatchString(pick.AgentID) {
+			return nil, status.Error(codes.InvalidArgument, errInvalidAgentID.Error())
+		}
+		if err := checkAgent(t.s.db, pick.AgentID); err != nil {
+			return nil, grpcError(err)
+		}
+	}
+	wait := min(req.GetWait().AsDuration(), t.s.cfg.MaxPickWait)
+	if wait < 0 {
+		return nil, status.Error(codes.InvalidArgument, "invalid wait duration")
+	}
+
+	task, err := t.s.awaitTask(ctx, pick, time.Now().Add(wait))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if ctx.Err() != nil {
+		log.Warnf("Agent went away before getting task %s", task.ID)
+		t.s.releaseLease(task)
+		return nil, grpcError(ctx.Err())
+	}
+	return toProtoPickedTask(task), nil
+}
+
+func (t *taskService) FinishTask(ctx context.Context, req *taskpb.FinishTaskRequest) (*taskpb.Task, error) {
+	taskID, err := uuid.Parse(req.GetId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid id format")
+	}
+	leaseID, err := uuid.Parse(req.GetLeaseId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid lease_id format")
+	}
+	task, err := t.s.finishTask(taskID, TaskResult{
+		Status:   req.GetStatus(),
+		ExitCode: fromInt32(req.ExitCode),
+		LeaseID:  leaseID,
+		Output:   fromProtoOutput(req.GetOutput()),
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return toProtoTask(task), nil
+}
+
+func (t *taskService) StreamTaskLogs(req *taskpb.StreamTaskLogsRequest, stream taskpb.TaskService_StreamTaskLogsServer) error {
+	taskID, err := uuid.Parse(req.GetId())
+	if err != nil {
+		return status.Error(codes.InvalidArgument, "invalid id format")
+	}
+	if req.GetOffset() < 0 {
+		return status.Error(codes.InvalidArgument, "invalid offset")
+	}
+	if _, err := t.s.getTask(taskID); err != nil {
+		return grpcError(err)
+	}
+
+	send := func(chunks []TaskLogChunk) error {
+		for _, chunk := range chunks {
+			if err := stream.Send(toProtoLogChunk(chunk)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	offset := int(req.GetOffset())
+	if req.GetFollow() {
+		_, err := t.s.followLogs(stream.Context(), taskID, offset, send)
+		return grpcError(err)
+	}
+
+	for {
+		chunks, err := logChunksAfter(t.s.db, taskID, offset)
+		if err != nil {
+			return grpcError(err)
+		}
+		if err := send(chunks); err != nil {
+			return err
+		}
+		if len(chunks) < logChunksPageSize {
+			return nil
+		}
+		offset = chunks[len(chunks)-1].Seq
+	}
+}
+
+// WatchTask sends the task whenever its status or attempt has changed. It
+// reads the task again when notified of a change, or every LogFollowInterval.
+func (t *taskService) WatchTask(req *taskpb.WatchTaskRequest, stream taskpb.TaskService_WatchTaskServer) error {
+	taskID, err := uuid.Parse(req.GetId())
+	if err != nil {
+		return status.Error(codes.InvalidArgument, "invalid id format")
+	}
+	ticker := time.NewTicker(t.s.cfg.LogFollowInterval)
+	defer ticker.Stop()
+
+	var last *Task
+	for {
+		woken, stop := t.s.notifier.waitLogs(taskID)
+		task, err := t.s.getTask(taskID)
+		if err != nil {
+			stop()
+			return grpcError(err)
+		}
+		if last == nil || task.Status != last.Status || task.Attempt != last.Attempt {
+			if err := stream.Send(toProtoTask(task)); err != nil {
+				stop()
+				return err
+			}
+			last = &task
+		}
+		if (&TaskData{Status: task.Status}).completed() {
+			stop()
+			return nil
+		}
+
+		select {
+		case <-stream.Context().Done():
+			stop()
+			return status.FromContextError(stream.Context().Err()).Err()
+		case <-woken:
+		case <-ticker.C:
+		}
+		stop()
+	}
+}
+
+// grpcError maps the errors of the storage layer to gRPC status codes, like
+// the REST handlers map them to HTTP status codes.
+func grpcError(err error) error {
+	if _, ok := status.FromError(err); ok {
+		// Nil, or already a status, e.g. of a failed send on a stream.
+		return err
+	}
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	case errors.Is(err, errTaskNotFound), errors.Is(err, errNoQueuedTask):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, errUnknownParent), errors.Is(err, errInvalidStream), errors.Is(err, errInvalidTaskQuery),
+		errors.Is(err, errLeaseRequired):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, errTaskNotInProgress), errors.Is(err, errLeaseNotHeld):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, errUnknownAgent):
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	log.Error("gRPC call failed: " + err.Error())
+	return status.Error(codes.Internal, "Internal Server Error")
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"context"
+	"net"
+	"regexp"
+	"testing"
+	"time"
+
+	"backend-api-server/taskpb"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestTaskService(t *testing.T) {
+	assert := assert.New(t)
+
+	// Mock
+	mockDB, mock, err := sqlmock.New()
+	assert.NoError(err)
+	defer func() { _ = mockDB.Close() }()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:                 mockDB,
+		DriverName:           "postgres",
+		PreferSimpleProtocol: true,
+	})
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	assert.NoError(err)
+
+	server := Server{db: db, cfg: &Config{MaxPickWait: time.Minute}}
+	listener := bufconn.Listen(1024 * 1024)
+	grpcServer := grpc.NewServer()
+	taskpb.RegisterTaskServiceServer(grpcServer, &taskService{s: &server})
+	go func() { _ = grpcServer.Serve(listener) }()
+	defer grpcServer.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(err)
+	defer conn.Close()
+	client := taskpb.NewTaskServiceClient(conn)
+	ctx := context.Background()
+
+	// Create task
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "task_data"`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "webhook_events"`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_notify(`)).
+		WithArgs(taskQueuedChannel, "builds").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	task, err := client.CreateTask(ctx, &taskpb.CreateTaskRequest{
+		Command:     "make",
+		MaxAttempts: func() *int32 { v := int32(3); return &v }(),
+		Labels:      map[string]string{"os": "linux"},
+		Queue:       "builds",
+	})
+	assert.NoError(err)
+	assert.Equal("make", task.GetCommand())
+	assert.Equal(statusQueued, task.GetStatus())
+	assert.Equal(int32(3), task.GetMaxAttempts())
+	assert.Equal(map[string]string{"os": "linux"}, task.GetLabels())
+	assert.Nil(task.StartedAt)
+
+	// Invalid task
+	_, err = client.CreateTask(ctx, &taskpb.CreateTaskRequest{Command: "make", Priority: 5000})
+	assert.Equal(codes.InvalidArgument, status.Code(err))
+	_, err = client.CreateTask(ctx, &taskpb.CreateTaskRequest{Command: "make", DependsOn: []string{"nope"}})
+	assert.Equal(codes.InvalidArgument, status.Code(err))
+
+	// Unknown task
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "task_data" WHERE id = $1`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	_, err = client.GetTask(ctx, &taskpb.GetTaskRequest{Id: uuid.NewString()})
+	assert.Equal(codes.NotFound, status.Code(err))
+	_, err = client.GetTask(ctx, &taskpb.GetTaskRequest{Id: "nope"})
+	assert.Equal(codes.InvalidArgument, status.Code(err))
+
+	// List tasks
+	taskID := uuid.New()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "command","date","id" FROM "task_data" WHERE status IN ($1) ORDER BY date ASC,id ASC LIMIT $2`)).
+		WithArgs(statusQueued, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "command", "date"}).
+			AddRow(taskID, "make", time.Now()).
+			AddRow(uuid.New(), "make test", time.Now()))
+	tasks, err := client.ListTasks(ctx, &taskpb.ListTasksRequest{
+		Statuses: []string{statusQueued},
+		Limit:    1,
+		Fields:   []string{"id", "command"},
+	})
+	assert.NoError(err)
+	assert.Len(tasks.GetTasks(), 1)
+	assert.Equal(taskID.String(), tasks.GetTasks()[0].GetId())
+	assert.NotEmpty(tasks.GetNextCursor())
+	_, err = client.ListTasks(ctx, &taskpb.ListTasksRequest{Sort: "status"})
+	assert.Equal(codes.InvalidArgument, status.Code(err))
+
+	// Nothing to pick
+	agentQuery := regexp.QuoteMeta(`SELECT count(*) FROM "agent_data" WHERE id = $1`)
+	mock.ExpectQuery(agentQuery).
+		WithArgs("agent-1").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "task_data" WHERE status = $1`)).
+		WillReturnError(gorm.ErrRecordNotFound)
+	mock.ExpectRollback()
+	_, err = client.PickTask(ctx, &taskpb.PickTaskRequest{AgentId: "agent-1"})
+	assert.Equal(codes.NotFound, status.Code(err))
+
+	// Unknown and malformed agents
+	mock.ExpectQuery(agentQuery).
+		WithArgs("agent-2").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	_, err = client.PickTask(ctx, &taskpb.PickTaskRequest{AgentId: "agent-2"})
+	assert.Equal(codes.PermissionDenied, status.Code(err))
+	_, err = client.PickTask(ctx, &taskpb.PickTaskRequest{AgentId: "a/b"})
+	assert.Equal(codes.InvalidArgument, status.Code(err))
+
+	// Watch task - woken up by the pick of the task instead of polling
+	server.cfg.LogFollowInterval = time.Hour
+	server.notifier = newTaskNotifier()
+	taskQuery := regexp.QuoteMeta(`SELECT * FROM "task_data" WHERE id = $1`)
+	dependenciesQuery := regexp.QuoteMeta(`SELECT * FROM "task_dependencies" WHERE task_id IN ($1)`)
+	mock.ExpectQuery(taskQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "command", "status", "queue"}).
+			AddRow(taskID, "make", statusQueued, "builds"))
+	mock.ExpectQuery(dependenciesQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"task_id", "parent_id"}))
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	watch, err := client.WatchTask(watchCtx, &taskpb.WatchTaskRequest{Id: taskID.String()})
+	assert.NoError(err)
+	task, err = watch.Recv()
+	assert.NoError(err)
+	assert.Equal(statusQueued, task.GetStatus())
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "task_data" WHERE status = $1`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "command", "date", "status", "queue"}).
+			AddRow(taskID, "make", time.Now(), statusQueued, "builds"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "queue_data" WHERE name = $1`)).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "paused", "max_in_flight"}))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "task_data" SET`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "webhook_events"`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_notify($1, $2)`)).
+		WithArgs(taskLogsChannel, taskID.String()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	mock.ExpectQuery(taskQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "command", "status", "queue", "attempt"}).
+			AddRow(taskID, "make", statusInProgress, "builds", 1))
+	mock.ExpectQuery(dependenciesQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"task_id", "parent_id"}))
+
+	_, err = server.pickTask(pickRequest{Queues: []string{"builds"}})
+	assert.NoError(err)
+	// Delivered by the listener once the pick has committed
+	server.notifier.broadcast(taskLogsChannel, taskID.String())
+	task, err = watch.Recv()
+	assert.NoError(err)
+	assert.Equal(statusInProgress, task.GetStatus())
+	cancel()
+
+	// Result without a valid lease
+	_, err = client.FinishTask(ctx, &taskpb.FinishTaskRequest{Id: uuid.NewString(), Status: statusFinished})
+	assert.Equal(codes.InvalidArgument, status.Code(err))
+
+	assert.NoError(mock.ExpectationsWereMet())
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// handleAgentHeartbeat keeps a registered agent online. Unknown agents get a
+// 404 and have to register again.
+func (s *Server) handleAgentHeartbeat(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, ok := vars["id"]
+	if !ok {
+		http.Error(w, "id parameter is missing", http.StatusBadRequest)
+		return
+	}
+	log.Debugf("Heartbeat of agent %s", id)
+
+	result := s.db.Model(&AgentData{}).Where("id = ?", id).Update("last_seen_at", time.Now())
+	if result.Error != nil {
+		log.Error("failed to update agent: " + result.Error.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		http.Error(w, "agent not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func (s *Server) handleListAgents(w http.ResponseWriter, r *http.Request) {
+	log.Info("Listing agents")
+	agentsData := []AgentData{}
+	if err := s.db.Order("id ASC").Find(&agentsData).Error; err != nil {
+		log.Error("failed to retrieve agents: " + err.Error())
+		http.Error(w, "failed to retrieve agents", http.StatusInternalServerError)
+		return
+	}
+
+	agents, err := s.toAgents(agentsData)
+	if err != nil {
+		log.Error("failed to retrieve agent tasks: " + err.Error())
+		http.Error(w, "failed to retrieve agents", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"agents": agents,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(response); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm/clause"
+)
+
+type AgentRegistration struct {
+	Hostname     string            `json:"hostname"`
+	Version      string            `json:"version"`
+	Capabilities []string          `json:"capabilities"`
+	Labels       map[string]string `json:"labels"`
+}
+
+// handleRegisterAgent registers an executor agent, or updates the details of
+// an agent that restarted under the same id.
+func (s *Server) handleRegisterAgent(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, ok := vars["id"]
+	if !ok {
+		http.Error(w, "id parameter is missing", http.StatusBadRequest)
+		return
+	}
+	log.Infof("Registering agent %s", id)
+
+	if !agentIDPattern.MatchString(id) {
+		http.Error(w, "invalid agent id", http.StatusBadRequest)
+		return
+	}
+
+	var registration AgentRegistration
+	if err := json.NewDecoder(r.Body).Decode(&registration); err != nil {
+		log.Error("invalid request payload: " + err.Error())
+		http.Error(w, "invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	agentData := AgentData{
+		ID:           id,
+		Hostname:     registration.Hostname,
+		Version:      registration.Version,
+		Capabilities: registration.Capabilities,
+		Labels:       registration.Labels,
+		RegisteredAt: now,
+		LastSeenAt:   now,
+	}
+	err := s.db.
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"hostname", "version", "capabilities", "labels", "registered_at", "last_seen_at"}),
+		}).
+		Create(&agentData).Error
+	if err != nil {
+		log.Error("failed to save agent: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	agents, err := s.toAgents([]AgentData{agentData})
+	if err != nil {
+		log.Error("failed to retrieve agent tasks: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(agents[0]); err != nil {
+		log.Error("failed to encode response: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+)
+
+var socketUpgrader = websocket.Upgrader{}
+
+// handleAgentSocket upgrades the request to the socket of an agent in push
+// mode. It takes the same label and queue parameters as picking a task, the
+// agent has to be registered.
+func (s *Server) handleAgentSocket(w http.ResponseWriter, r *http.Request) {
+	agentID := mux.Vars(r)["id"]
+	if !agentIDPattern.MatchString(agentID) {
+		http.Error(w, "invalid agent id", http.StatusBadRequest)
+		return
+	}
+	req, err := parsePickRequest(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.AgentID = agentID
+	if err := checkAgent(s.db, agentID); errors.Is(err, errUnknownAgent) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	} else if err != nil {
+		log.Error("failed to retrieve agent: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	conn, err := socketUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already replied with an error.
+		log.Warnf("failed to open socket of agent %s: %v", agentID, err)
+		return
+	}
+	log.Infof("Agent %s connected its socket", agentID)
+
+	session := agentSession{
+		s:          s,
+		conn:       conn,
+		req:        req,
+		send:       make(chan outgoingMessage),
+		writerDone: make(chan struct{}),
+	}
+	session.serve()
+	log.Infof("Agent %s disconnected its socket", agentID)
+}
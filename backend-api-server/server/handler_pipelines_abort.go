@@ -0,0 +1,142 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// handleAbortPipeline cancels all steps of a pipeline that have not completed
+// yet, like aborting them one by one. The pipeline ends up cancelled once the
+// agents have stopped its running step.
+func (s *Server) handleAbortPipeline(w http.ResponseWriter, r *http.Request) {
+	log.Info("Aborting a pipeline")
+	vars := mux.Vars(r)
+	idStr, ok := vars["id"]
+	if !ok {
+		http.Error(w, "id parameter is missing", http.StatusBadRequest)
+		return
+	}
+	pipelineID, err := uuid.Parse(idStr)
+	if err != nil {
+		http.Error(w, "invalid id format", http.StatusBadRequest)
+		return
+	}
+
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		log.Error("failed to start transaction: " + tx.Error.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	var pipelineData PipelineData
+	err = tx.
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(&pipelineData, "id = ?", pipelineID).Error
+	if err != nil {
+		tx.Rollback()
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "pipeline not found", http.StatusNotFound)
+		} else {
+			log.Error("failed to retrieve pipeline: " + err.Error())
+			http.Error(w, "failed to retrieve pipeline", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	var stepsData []TaskData
+	err = tx.
+		Where("pipeline_id = ?", pipelineID).
+		Order("pipeline_step ASC").
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Find(&stepsData).Error
+	if err != nil {
+		tx.Rollback()
+		log.Error("failed to retrieve pipeline steps: " + err.Error())
+		http.Error(w, "failed to retrieve pipeline", http.StatusInternalServerError)
+		return
+	}
+	if pipeline := pipelineData.toPipeline(stepsData); pipeline.completed() {
+		tx.Rollback()
+		http.Error(w, "pipeline has already completed", http.StatusConflict)
+		return
+	}
+
+	if pipelineData.CancelledAt == nil {
+		now := time.Now()
+		pipelineData.CancelledAt = &now
+		if err := tx.Save(&pipelineData).Error; err != nil {
+			tx.Rollback()
+			log.Error("failed to update pipeline: " + err.Error())
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// All steps are cancelled before any dependents are skipped, so that the
+	// following steps end up cancelled rather than skipped.
+	var changed []*TaskData
+	for i := range stepsData {
+		stepData := &stepsData[i]
+		if stepData.Status != statusQueued && stepData.Status != statusInProgress {
+			continue
+		}
+		stepData.cancel()
+		if err := tx.Save(stepData).Error; err != nil {
+			tx.Rollback()
+			log.Error("failed to update task: " + err.Error())
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		changed = append(changed, stepData)
+	}
+	for _, stepData := range changed {
+		if err := recordTaskCompletion(tx, stepData); err != nil {
+			tx.Rollback()
+			log.Error("failed to record task event: " + err.Error())
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if err := skipDependents(tx, stepData); err != nil {
+			tx.Rollback()
+			log.Error("failed to skip dependent tasks: " + err.Error())
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if err := notifyTaskDone(tx, stepData); err != nil {
+			tx.Rollback()
+			log.Error("failed to notify queued task: " + err.Error())
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		log.Error("failed to commit transaction: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	pipeline := pipelineData.toPipeline(stepsData)
+
+	// A running step is only cancelling until its agent has stopped it.
+	statusCode := http.StatusOK
+	if !pipeline.completed() {
+		statusCode = http.StatusAccepted
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(pipeline); err != nil {
+		log.Error("failed to encode response: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
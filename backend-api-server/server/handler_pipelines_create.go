@@ -0,0 +1,155 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+type Pipeline struct {
+	ID              uuid.UUID      `json:"id"`
+	Name            string         `json:"name"`
+	OnFailure       string         `json:"on_failure"`
+	Status          string         `json:"status"`
+	StartedAt       *time.Time     `json:"started_at"`
+	FinishedAt      *time.Time     `json:"finished_at"`
+	DurationSeconds *float64       `json:"duration_seconds"`
+	Steps           []PipelineStep `json:"steps"`
+}
+
+type PipelineStep struct {
+	Name            string     `json:"name"`
+	TaskID          uuid.UUID  `json:"task_id"`
+	Command         string     `json:"command"`
+	Status          string     `json:"status"`
+	ExitCode        *int       `json:"exit_code"`
+	StartedAt       *time.Time `json:"started_at"`
+	FinishedAt      *time.Time `json:"finished_at"`
+	DurationSeconds *float64   `json:"duration_seconds"`
+}
+
+// PipelineStepCreate is a step of a new pipeline. Besides its name it takes
+// the same fields as a new task, except depends_on.
+type PipelineStepCreate struct {
+	Name string `json:"name"`
+	TaskCreate
+}
+
+// PipelineCreate is a new pipeline. With the stop policy a failed step skips
+// all the following ones, with the continue policy every step runs once the
+// previous one has completed.
+type PipelineCreate struct {
+	Name      string               `json:"name"`
+	OnFailure string               `json:"on_failure"`
+	Steps     []PipelineStepCreate `json:"steps"`
+}
+
+const maxPipelineSteps = 100
+
+func (c *PipelineCreate) validate(maxTimeout time.Duration) error {
+	if len(c.Steps) == 0 || len(c.Steps) > maxPipelineSteps {
+		return errors.New("pipeline must have between 1 and 100 steps")
+	}
+	switch c.OnFailure {
+	case "":
+		c.OnFailure = onFailureStop
+	case onFailureStop, onFailureContinue:
+	default:
+		return errors.New("on_failure must be stop or continue")
+	}
+	for i, step := range c.Steps {
+		if len(step.DependsOn) > 0 {
+			return fmt.Errorf("step %d: steps cannot have depends_on", i)
+		}
+		if _, err := step.toTask(maxTimeout); err != nil {
+			return fmt.Errorf("step %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (s *Server) handleCreatePipeline(w http.ResponseWriter, r *http.Request) {
+	log.Info("Creating new pipeline")
+	var pipelineCreate PipelineCreate
+	if err := json.NewDecoder(r.Body).Decode(&pipelineCreate); err != nil {
+		log.Error("invalid request payload: " + err.Error())
+		http.Error(w, "invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if err := pipelineCreate.validate(s.cfg.MaxTaskTimeout); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	pipelineData := PipelineData{
+		ID:        uuid.New(),
+		Name:      pipelineCreate.Name,
+		OnFailure: pipelineCreate.OnFailure,
+		StepNames: make([]string, len(pipelineCreate.Steps)),
+	}
+
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		log.Error("failed to start transaction: " + tx.Error.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	stepsData := make([]TaskData, len(pipelineCreate.Steps))
+	for i, step := range pipelineCreate.Steps {
+		pipelineData.StepNames[i] = step.Name
+		task, _ := step.toTask(s.cfg.MaxTaskTimeout)
+		taskData := task.toTaskData()
+		taskData.rank(s.cfg.PriorityAgingInterval)
+		taskData.PipelineID = &pipelineData.ID
+		taskData.PipelineStep = intPointer(i)
+		if i > 0 {
+			taskData.DependsOn = []uuid.UUID{stepsData[i-1].ID}
+		}
+		stepsData[i] = taskData
+	}
+
+	if err := tx.Create(&pipelineData).Error; err != nil {
+		tx.Rollback()
+		log.Error("failed to save pipeline: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	allowFailure := pipelineData.OnFailure == onFailureContinue
+	for i := range stepsData {
+		if err := createTask(tx, &stepsData[i], allowFailure); err != nil {
+			tx.Rollback()
+			log.Error("failed to save pipeline step: " + err.Error())
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+	// Only the first step is pickable right away.
+	if err := notifyIfPickable(tx, &stepsData[0]); err != nil {
+		tx.Rollback()
+		log.Error("failed to notify queued task: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		log.Error("failed to commit transaction: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	pipeline := pipelineData.toPipeline(stepsData)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(pipeline); err != nil {
+		log.Error("failed to encode response: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+func (s *Server) handleGetPipeline(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	idStr, ok := vars["id"]
+	if !ok {
+		http.Error(w, "id parameter is missing", http.StatusBadRequest)
+		return
+	}
+	log.Infof("Getting a pipeline with id %s", idStr)
+
+	pipelineID, err := uuid.Parse(idStr)
+	if err != nil {
+		http.Error(w, "invalid id format", http.StatusBadRequest)
+		return
+	}
+
+	var pipelineData PipelineData
+	if err := s.db.First(&pipelineData, "id = ?", pipelineID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "pipeline not found", http.StatusNotFound)
+		} else {
+			log.Error("failed to retrieve pipeline: " + err.Error())
+			http.Error(w, "failed to retrieve pipeline", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	steps, err := loadPipelineSteps(s.db, []uuid.UUID{pipelineID})
+	if err != nil {
+		log.Error("failed to retrieve pipeline steps: " + err.Error())
+		http.Error(w, "failed to retrieve pipeline", http.StatusInternalServerError)
+		return
+	}
+	pipeline := pipelineData.toPipeline(steps[pipelineID])
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(pipeline); err != nil {
+		log.Error("failed to encode response: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
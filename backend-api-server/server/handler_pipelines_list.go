@@ -0,0 +1,47 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+func (s *Server) handleListPipelines(w http.ResponseWriter, r *http.Request) {
+	log.Info("Listing pipelines")
+	var pipelinesData []PipelineData
+	if err := s.db.Order("date ASC").Find(&pipelinesData).Error; err != nil {
+		log.Error("failed to retrieve pipelines: " + err.Error())
+		http.Error(w, "failed to retrieve pipelines", http.StatusInternalServerError)
+		return
+	}
+
+	pipelineIDs := make([]uuid.UUID, len(pipelinesData))
+	for i, pd := range pipelinesData {
+		pipelineIDs[i] = pd.ID
+	}
+	steps, err := loadPipelineSteps(s.db, pipelineIDs)
+	if err != nil {
+		log.Error("failed to retrieve pipeline steps: " + err.Error())
+		http.Error(w, "failed to retrieve pipelines", http.StatusInternalServerError)
+		return
+	}
+
+	pipelines := make([]Pipeline, len(pipelinesData))
+	for i, pd := range pipelinesData {
+		pipelines[i] = pd.toPipeline(steps[pd.ID])
+	}
+
+	response := map[string]interface{}{
+		"pipelines": pipelines,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(response); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestHandlerPipelineCreate(t *testing.T) {
+	assert := assert.New(t)
+
+	// Mock
+	mockDB, mock, err := sqlmock.New()
+	assert.NoError(err)
+	defer func() { _ = mockDB.Close() }()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:                 mockDB,
+		DriverName:           "postgres",
+		PreferSimpleProtocol: true,
+	})
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	assert.NoError(err)
+
+	server := Server{db: db, cfg: &Config{MaxTaskTimeout: time.Hour}}
+	create := func(body string) (int, Pipeline) {
+		req := httptest.NewRequest(http.MethodPost, "/pipelines", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		server.handleCreatePipeline(w, req)
+		var pipeline Pipeline
+		if w.Code == http.StatusCreated {
+			assert.NoError(json.NewDecoder(w.Body).Decode(&pipeline))
+		}
+		return w.Code, pipeline
+	}
+
+	// Steps are chained, only the first one wakes up waiting agents
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "pipeline_data"`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "task_data"`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "webhook_events"`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "id","status","exit_code" FROM "task_data" WHERE id IN ($1) FOR SHARE`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status", "exit_code"}).AddRow(uuid.New(), statusQueued, nil))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "task_data"`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "webhook_events"`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "task_dependencies" ("task_id","parent_id","allow_failure")`)).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), true).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_notify($1, $2)`)).
+		WithArgs(taskQueuedChannel, defaultQueue).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	code, pipeline := create(`{"name":"release","on_failure":"continue","steps":[` +
+		`{"name":"build","command":"make"},{"name":"test","command":"make test"}]}`)
+	assert.Equal(http.StatusCreated, code)
+	assert.Equal("release", pipeline.Name)
+	assert.Equal(onFailureContinue, pipeline.OnFailure)
+	assert.Equal(pipelineStatusQueued, pipeline.Status)
+	assert.Len(pipeline.Steps, 2)
+	assert.Equal("test", pipeline.Steps[1].Name)
+	assert.Equal("make test", pipeline.Steps[1].Command)
+
+	// Invalid pipelines are rejected before touching the database
+	code, _ = create(`{"steps":[]}`)
+	assert.Equal(http.StatusBadRequest, code)
+	code, _ = create(`{"on_failure":"retry","steps":[{"command":"make"}]}`)
+	assert.Equal(http.StatusBadRequest, code)
+	code, _ = create(`{"steps":`)
+	assert.Equal(http.StatusBadRequest, code)
+
+	// Failed insert
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "pipeline_data"`)).
+		WillReturnError(gorm.ErrInvalidDB)
+	mock.ExpectRollback()
+
+	code, _ = create(`{"steps":[{"command":"make"}]}`)
+	assert.Equal(http.StatusInternalServerError, code)
+
+	assert.NoError(mock.ExpectationsWereMet())
+}
+
+func TestHandlerPipelineAbort(t *testing.T) {
+	assert := assert.New(t)
+
+	// Mock
+	mockDB, mock, err := sqlmock.New()
+	assert.NoError(err)
+	defer func() { _ = mockDB.Close() }()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:                 mockDB,
+		DriverName:           "postgres",
+		PreferSimpleProtocol: true,
+	})
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	assert.NoError(err)
+
+	server := Server{db: db}
+	pipelineQuery := regexp.QuoteMeta(`SELECT * FROM "pipeline_data" WHERE id = $1 ORDER BY "pipeline_data"."id" LIMIT $2 FOR UPDATE`)
+	stepsQuery := regexp.QuoteMeta(`SELECT * FROM "task_data" WHERE pipeline_id = $1 ORDER BY pipeline_step ASC FOR UPDATE`)
+	pipelineColumns := []string{"id", "name", "on_failure", "step_names", "date", "cancelled_at"}
+	stepColumns := []string{"id", "command", "status", "exit_code", "started_at", "pipeline_id", "pipeline_step", "queue"}
+	cappedQuery := regexp.QuoteMeta(`SELECT count(*) FROM "queue_data" WHERE name = $1 AND max_in_flight IS NOT NULL`)
+	dependentQueuesQuery := regexp.QuoteMeta(`SELECT DISTINCT "queue" FROM "task_data" WHERE status = $1 AND id IN (SELECT task_id FROM task_dependencies WHERE parent_id = $2)`)
+	abort := func(id uuid.UUID) (int, Pipeline) {
+		req := httptest.NewRequest(http.MethodPost, "/pipelines/"+id.String()+"/abort", nil)
+		req = mux.SetURLVars(req, map[string]string{"id": id.String()})
+		w := httptest.NewRecorder()
+		server.handleAbortPipeline(w, req)
+		var pipeline Pipeline
+		if w.Code == http.StatusOK || w.Code == http.StatusAccepted {
+			assert.NoError(json.NewDecoder(w.Body).Decode(&pipeline))
+		}
+		return w.Code, pipeline
+	}
+
+	// Running pipeline - the running step is cancelling, the queued one is
+	// cancelled right away instead of being skipped
+	pipelineID := uuid.New()
+	runningID, queuedID := uuid.New(), uuid.New()
+	mock.ExpectBegin()
+	mock.ExpectQuery(pipelineQuery).
+		WithArgs(pipelineID, 1).
+		WillReturnRows(sqlmock.NewRows(pipelineColumns).
+			AddRow(pipelineID, "release", onFailureStop, `["build","test","deploy"]`, time.Now(), nil))
+	mock.ExpectQuery(stepsQuery).
+		WithArgs(pipelineID).
+		WillReturnRows(sqlmock.NewRows(stepColumns).
+			AddRow(uuid.New(), "make", statusFinished, 0, time.Now(), pipelineID, 0, defaultQueue).
+			AddRow(runningID, "make test", statusInProgress, nil, time.Now(), pipelineID, 1, defaultQueue).
+			AddRow(queuedID, "make deploy", statusQueued, nil, nil, pipelineID, 2, defaultQueue))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "pipeline_data" SET`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "task_data" SET`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "task_data" SET`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_notify($1, $2)`)).
+		WithArgs(taskLogsChannel, runningID.String()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(cappedQuery).
+		WithArgs(defaultQueue).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "webhook_events"`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_notify($1, $2)`)).
+		WithArgs(taskLogsChannel, queuedID.String()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "task_data" WHERE status = $1 AND (id IN (SELECT task_id FROM task_dependencies`)).
+		WithArgs(statusQueued, queuedID).
+		WillReturnRows(sqlmock.NewRows(stepColumns))
+	mock.ExpectQuery(cappedQuery).
+		WithArgs(defaultQueue).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(dependentQueuesQuery).
+		WithArgs(statusQueued, queuedID).
+		WillReturnRows(sqlmock.NewRows([]string{"queue"}))
+	mock.ExpectCommit()
+
+	code, pipeline := abort(pipelineID)
+	assert.Equal(http.StatusAccepted, code)
+	assert.Equal(pipelineStatusRunning, pipeline.Status)
+	assert.Equal(statusCancelling, pipeline.Steps[1].Status)
+	assert.Equal(statusCancelled, pipeline.Steps[2].Status)
+
+	// Completed pipeline - conflict
+	mock.ExpectBegin()
+	mock.ExpectQuery(pipelineQuery).
+		WillReturnRows(sqlmock.NewRows(pipelineColumns).
+			AddRow(pipelineID, "release", onFailureStop, `["build"]`, time.Now(), nil))
+	mock.ExpectQuery(stepsQuery).
+		WillReturnRows(sqlmock.NewRows(stepColumns).
+			AddRow(uuid.New(), "make", statusFinished, 0, time.Now(), pipelineID, 0, defaultQueue))
+	mock.ExpectRollback()
+
+	code, _ = abort(pipelineID)
+	assert.Equal(http.StatusConflict, code)
+
+	// Unknown pipeline
+	mock.ExpectBegin()
+	mock.ExpectQuery(pipelineQuery).
+		WillReturnError(gorm.ErrRecordNotFound)
+	mock.ExpectRollback()
+
+	code, _ = abort(uuid.New())
+	assert.Equal(http.StatusNotFound, code)
+
+	assert.NoError(mock.ExpectationsWereMet())
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func (s *Server) handleListQueues(w http.ResponseWriter, r *http.Request) {
+	log.Info("Listing queues")
+	queues, err := loadQueues(s.db)
+	if err != nil {
+		log.Error("failed to retrieve queues: " + err.Error())
+		http.Error(w, "failed to retrieve queues", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"queues": queues,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(response); err != nil {
+		log.Error("failed to encode response: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
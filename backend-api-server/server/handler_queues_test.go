@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestHandlerQueues(t *testing.T) {
+	assert := assert.New(t)
+
+	// Mock
+	mockDB, mock, err := sqlmock.New()
+	assert.NoError(err)
+	defer func() { _ = mockDB.Close() }()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:                 mockDB,
+		DriverName:           "postgres",
+		PreferSimpleProtocol: true,
+	})
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	assert.NoError(err)
+
+	server := Server{db: db}
+	upsertQuery := regexp.QuoteMeta(`INSERT INTO "queue_data" ("name","paused","max_in_flight","updated_at") VALUES ($1,$2,$3,$4) ON CONFLICT ("name") DO UPDATE SET `)
+	selectQuery := regexp.QuoteMeta(`SELECT * FROM "queue_data" WHERE name = $1 AND "queue_data"."name" = $2 ORDER BY "queue_data"."name" LIMIT $3`)
+	columns := []string{"name", "paused", "max_in_flight", "updated_at"}
+	send := func(handler http.HandlerFunc, method, name, body string) (int, Queue) {
+		req := httptest.NewRequest(method, "/queues/"+name, strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"name": name})
+		w := httptest.NewRecorder()
+		handler(w, req)
+		var queue Queue
+		if w.Code == http.StatusOK {
+			assert.NoError(json.NewDecoder(w.Body).Decode(&queue))
+		}
+		return w.Code, queue
+	}
+	expectSave := func(name string, columnSQL string, paused bool, maxInFlight interface{}) {
+		mock.ExpectBegin()
+		mock.ExpectExec(upsertQuery+regexp.QuoteMeta(columnSQL)).
+			WithArgs(name, paused, maxInFlight, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectQuery(selectQuery).
+			WithArgs(name, name, 1).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(name, paused, maxInFlight, time.Now()))
+		if !paused {
+			mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_notify(`)).
+				WithArgs(taskQueuedChannel, name).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+		}
+	}
+
+	// Pause and resume only change the paused flag
+	expectSave("builds", `"paused"="excluded"."paused","updated_at"="excluded"."updated_at"`, true, nil)
+	code, queue := send(server.handlePauseQueue, http.MethodPost, "builds", "")
+	assert.Equal(http.StatusOK, code)
+	assert.Equal("builds", queue.Name)
+	assert.True(queue.Paused)
+
+	expectSave("builds", `"paused"="excluded"."paused","updated_at"="excluded"."updated_at"`, false, nil)
+	code, queue = send(server.handleResumeQueue, http.MethodPost, "builds", "")
+	assert.Equal(http.StatusOK, code)
+	assert.False(queue.Paused)
+
+	// Cap the tasks in flight, 0 removes the cap
+	expectSave("builds", `"max_in_flight"="excluded"."max_in_flight","updated_at"="excluded"."updated_at"`, false, 2)
+	code, queue = send(server.handleUpdateQueue, http.MethodPatch, "builds", `{"max_in_flight": 2}`)
+	assert.Equal(http.StatusOK, code)
+	assert.Equal(2, *queue.MaxInFlight)
+
+	expectSave("builds", `"max_in_flight"="excluded"."max_in_flight","updated_at"="excluded"."updated_at"`, false, nil)
+	code, queue = send(server.handleUpdateQueue, http.MethodPatch, "builds", `{"max_in_flight": 0}`)
+	assert.Equal(http.StatusOK, code)
+	assert.Nil(queue.MaxInFlight)
+
+	for _, body := range []string{`{}`, `{"max_in_flight": -1}`, `not json`} {
+		code, _ = send(server.handleUpdateQueue, http.MethodPatch, "builds", body)
+		assert.Equal(http.StatusBadRequest, code, body)
+	}
+	code, _ = send(server.handlePauseQueue, http.MethodPost, "no/such", "")
+	assert.Equal(http.StatusBadRequest, code)
+
+	// List queues with settings and queues that only have tasks
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "queue_data" ORDER BY name ASC`)).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow("builds", true, 2, time.Now()))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT queue, status, COUNT(*) AS count FROM "task_data" WHERE status IN ($1,$2,$3) GROUP BY queue, status`)).
+		WithArgs(statusQueued, statusInProgress, statusCancelling).
+		WillReturnRows(sqlmock.NewRows([]string{"queue", "status", "count"}).
+			AddRow("builds", statusQueued, 3).
+			AddRow("builds", statusInProgress, 1).
+			AddRow("builds", statusCancelling, 1).
+			AddRow(defaultQueue, statusQueued, 4))
+
+	req := httptest.NewRequest(http.MethodGet, "/queues", nil)
+	w := httptest.NewRecorder()
+	server.handleListQueues(w, req)
+	assert.Equal(http.StatusOK, w.Code)
+	var response struct {
+		Queues []Queue `json:"queues"`
+	}
+	assert.NoError(json.NewDecoder(w.Body).Decode(&response))
+	two := 2
+	assert.Equal([]Queue{
+		{Name: "builds", Paused: true, MaxInFlight: &two, Queued: 3, InFlight: 2},
+		{Name: defaultQueue, Queued: 4},
+	}, response.Queues)
+
+	assert.NoError(mock.ExpectationsWereMet())
+}
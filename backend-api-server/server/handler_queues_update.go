@@ -0,0 +1,108 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+type QueueUpdate struct {
+	// MaxInFlight caps the number of running tasks of the queue, 0 removes
+	// the cap.
+	MaxInFlight *int `json:"max_in_flight"`
+}
+
+func (s *Server) handleUpdateQueue(w http.ResponseWriter, r *http.Request) {
+	name, ok := s.queueName(w, r)
+	if !ok {
+		return
+	}
+	log.Infof("Updating queue %s", name)
+
+	var queueUpdate QueueUpdate
+	if err := json.NewDecoder(r.Body).Decode(&queueUpdate); err != nil {
+		log.Error("invalid request payload: " + err.Error())
+		http.Error(w, "invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if queueUpdate.MaxInFlight == nil || *queueUpdate.MaxInFlight < 0 {
+		http.Error(w, "max_in_flight must be given and not negative", http.StatusBadRequest)
+		return
+	}
+
+	queueData := QueueData{Name: name}
+	if *queueUpdate.MaxInFlight > 0 {
+		queueData.MaxInFlight = queueUpdate.MaxInFlight
+	}
+	s.saveQueue(w, &queueData, "max_in_flight")
+}
+
+func (s *Server) handlePauseQueue(w http.ResponseWriter, r *http.Request) {
+	name, ok := s.queueName(w, r)
+	if !ok {
+		return
+	}
+	log.Infof("Pausing queue %s", name)
+	s.saveQueue(w, &QueueData{Name: name, Paused: true}, "paused")
+}
+
+func (s *Server) handleResumeQueue(w http.ResponseWriter, r *http.Request) {
+	name, ok := s.queueName(w, r)
+	if !ok {
+		return
+	}
+	log.Infof("Resuming queue %s", name)
+	s.saveQueue(w, &QueueData{Name: name, Paused: false}, "paused")
+}
+
+func (s *Server) queueName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	vars := mux.Vars(r)
+	name, ok := vars["name"]
+	if !ok {
+		http.Error(w, "name parameter is missing", http.StatusBadRequest)
+		return "", false
+	}
+	if !queueNamePattern.MatchString(name) {
+		http.Error(w, "invalid queue name", http.StatusBadRequest)
+		return "", false
+	}
+	return name, true
+}
+
+// saveQueue updates the given columns of the queue settings, wakes up the
+// agents waiting for tasks of the queue unless it is paused and responds with
+// the resulting settings.
+func (s *Server) saveQueue(w http.ResponseWriter, queueData *QueueData, columns ...string) {
+	if err := upsertQueue(s.db, queueData, columns...); err != nil {
+		log.Error("failed to save queue: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err := s.db.First(queueData, "name = ?", queueData.Name).Error; err != nil {
+		log.Error("failed to retrieve queue: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	// Resuming the queue or raising its cap may make tasks pickable.
+	if !queueData.Paused {
+		if err := notifyTaskQueued(s.db, queueData.Name); err != nil {
+			log.Error("failed to notify queued task: " + err.Error())
+		}
+	}
+	queue := Queue{
+		Name:        queueData.Name,
+		Paused:      queueData.Paused,
+		MaxInFlight: queueData.MaxInFlight,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(queue); err != nil {
+		log.Error("failed to encode response: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
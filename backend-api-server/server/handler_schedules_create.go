@@ -0,0 +1,106 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+type Schedule struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	Cron       string     `json:"cron"`
+	Timezone   string     `json:"timezone"`
+	Enabled    bool       `json:"enabled"`
+	Task       TaskCreate `json:"task"`
+	NextRunAt  *time.Time `json:"next_run_at"`
+	LastRunAt  *time.Time `json:"last_run_at"`
+	LastTaskID *uuid.UUID `json:"last_task_id"`
+}
+
+type ScheduleCreate struct {
+	Name     string     `json:"name"`
+	Cron     string     `json:"cron"`
+	Timezone string     `json:"timezone"`
+	Enabled  *bool      `json:"enabled"`
+	Task     TaskCreate `json:"task"`
+}
+
+const defaultTimezone = "UTC"
+
+// validateTemplate checks that tasks can be spawned from the template.
+func validateTemplate(template TaskCreate, maxTimeout time.Duration) error {
+	if template.RunAt != nil {
+		return errors.New("task of a schedule cannot have run_at")
+	}
+	if len(template.DependsOn) > 0 {
+		return errors.New("task of a schedule cannot have depends_on")
+	}
+	_, err := template.toTask(maxTimeout)
+	return err
+}
+
+// toSchedule validates the payload and creates a new schedule from it.
+func (c *ScheduleCreate) toSchedule(now time.Time, maxTimeout time.Duration) (Schedule, error) {
+	scheduleData := ScheduleData{
+		ID:       uuid.New(),
+		Name:     c.Name,
+		Cron:     c.Cron,
+		Timezone: c.Timezone,
+		Enabled:  true,
+		Task:     c.Task,
+	}
+	if scheduleData.Timezone == "" {
+		scheduleData.Timezone = defaultTimezone
+	}
+	if c.Enabled != nil {
+		scheduleData.Enabled = *c.Enabled
+	}
+
+	if _, err := nextRun(scheduleData.Cron, scheduleData.Timezone, now); err != nil {
+		return Schedule{}, err
+	}
+	if err := validateTemplate(scheduleData.Task, maxTimeout); err != nil {
+		return Schedule{}, err
+	}
+	if err := scheduleData.reschedule(now); err != nil {
+		return Schedule{}, err
+	}
+
+	return scheduleData.toSchedule(), nil
+}
+
+func (s *Server) handleCreateSchedule(w http.ResponseWriter, r *http.Request) {
+	log.Info("Creating new schedule")
+	var scheduleCreate ScheduleCreate
+	if err := json.NewDecoder(r.Body).Decode(&scheduleCreate); err != nil {
+		log.Error("invalid request payload: " + err.Error())
+		http.Error(w, "invalid request payload", http.StatusBadRequest)
+		return
+	}
+	schedule, err := scheduleCreate.toSchedule(time.Now(), s.cfg.MaxTaskTimeout)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	scheduleData := schedule.toScheduleData()
+	if err := s.db.Create(&scheduleData).Error; err != nil {
+		log.Error("failed to save schedule: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(schedule); err != nil {
+		log.Error("failed to encode response: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
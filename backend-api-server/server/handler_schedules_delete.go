@@ -0,0 +1,40 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// handleDeleteSchedule deletes a schedule. Tasks it has already spawned are
+// kept and still refer to it.
+func (s *Server) handleDeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	idStr, ok := vars["id"]
+	if !ok {
+		http.Error(w, "id parameter is missing", http.StatusBadRequest)
+		return
+	}
+	log.Infof("Deleting a schedule with id %s", idStr)
+
+	scheduleID, err := uuid.Parse(idStr)
+	if err != nil {
+		http.Error(w, "invalid id format", http.StatusBadRequest)
+		return
+	}
+
+	result := s.db.Delete(&ScheduleData{}, "id = ?", scheduleID)
+	if result.Error != nil {
+		log.Error("failed to delete schedule: " + result.Error.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		http.Error(w, "schedule not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
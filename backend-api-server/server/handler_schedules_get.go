@@ -0,0 +1,48 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+func (s *Server) handleGetSchedule(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	idStr, ok := vars["id"]
+	if !ok {
+		http.Error(w, "id parameter is missing", http.StatusBadRequest)
+		return
+	}
+	log.Infof("Getting a schedule with id %s", idStr)
+
+	scheduleID, err := uuid.Parse(idStr)
+	if err != nil {
+		http.Error(w, "invalid id format", http.StatusBadRequest)
+		return
+	}
+
+	var scheduleData ScheduleData
+	if err := s.db.First(&scheduleData, "id = ?", scheduleID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "schedule not found", http.StatusNotFound)
+		} else {
+			log.Error("failed to retrieve schedule: " + err.Error())
+			http.Error(w, "failed to retrieve schedule", http.StatusInternalServerError)
+		}
+		return
+	}
+	schedule := scheduleData.toSchedule()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(schedule); err != nil {
+		log.Error("failed to encode response: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
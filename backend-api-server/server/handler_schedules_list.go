@@ -0,0 +1,36 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func (s *Server) handleListSchedules(w http.ResponseWriter, r *http.Request) {
+	log.Info("Listing schedules")
+	var schedulesData []ScheduleData
+	if err := s.db.Order("date ASC").Find(&schedulesData).Error; err != nil {
+		log.Error("failed to retrieve schedules: " + err.Error())
+		http.Error(w, "failed to retrieve schedules", http.StatusInternalServerError)
+		return
+	}
+
+	schedules := make([]Schedule, len(schedulesData))
+	for i, sd := range schedulesData {
+		schedules[i] = sd.toSchedule()
+	}
+
+	response := map[string]interface{}{
+		"schedules": schedules,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(response); err != nil {
+		log.Error("failed to encode response: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
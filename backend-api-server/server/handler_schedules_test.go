@@ -0,0 +1,186 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestHandlerSchedules(t *testing.T) {
+	assert := assert.New(t)
+
+	// Mock
+	mockDB, mock, err := sqlmock.New()
+	assert.NoError(err)
+	defer func() { _ = mockDB.Close() }()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:                 mockDB,
+		DriverName:           "postgres",
+		PreferSimpleProtocol: true,
+	})
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	assert.NoError(err)
+
+	server := Server{db: db, cfg: &Config{MaxTaskTimeout: time.Hour}}
+	selectQuery := regexp.QuoteMeta(`SELECT * FROM "schedule_data" WHERE id = $1 ORDER BY "schedule_data"."id" LIMIT $2`)
+	lockQuery := regexp.QuoteMeta(`SELECT * FROM "schedule_data" WHERE id = $1 ORDER BY "schedule_data"."id" LIMIT $2 FOR UPDATE`)
+	updateQuery := regexp.QuoteMeta(`UPDATE "schedule_data" SET "name"=$1,"cron"=$2,"timezone"=$3,"enabled"=$4,"task"=$5,"date"=$6,"next_run_at"=$7,"last_run_at"=$8,"last_task_id"=$9 WHERE "id" = $10`)
+	columns := []string{"id", "name", "cron", "timezone", "enabled", "task", "date", "next_run_at", "last_run_at", "last_task_id"}
+	send := func(handler http.HandlerFunc, method string, id uuid.UUID, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/schedules/"+id.String(), strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"id": id.String()})
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+	row := func(id uuid.UUID, enabled bool, nextRunAt *time.Time) *sqlmock.Rows {
+		return sqlmock.NewRows(columns).
+			AddRow(id, "nightly", "0 3 * * *", "Europe/Budapest", enabled, `{"command":"make backup"}`, time.Now(), nextRunAt, nil, nil)
+	}
+
+	// Create - enabled by default and scheduled for its next fire
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "schedule_data"`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w := send(server.handleCreateSchedule, http.MethodPost, uuid.Nil,
+		`{"name":"nightly","cron":"0 3 * * *","timezone":"Europe/Budapest","task":{"command":"make backup"}}`)
+	assert.Equal(http.StatusCreated, w.Code)
+	var schedule Schedule
+	assert.NoError(json.NewDecoder(w.Body).Decode(&schedule))
+	assert.True(schedule.Enabled)
+	assert.NotNil(schedule.NextRunAt)
+	assert.Equal("make backup", schedule.Task.Command)
+
+	// Create - invalid cron expression, time zone and template
+	for _, body := range []string{
+		`{"cron":"every night","task":{"command":"make backup"}}`,
+		`{"cron":"0 3 * * *","timezone":"Mars/Olympus","task":{"command":"make backup"}}`,
+		`{"cron":"0 3 * * *","task":{"command":"make backup","priority":10000}}`,
+		`{"cron":`,
+	} {
+		w = send(server.handleCreateSchedule, http.MethodPost, uuid.Nil, body)
+		assert.Equal(http.StatusBadRequest, w.Code, body)
+	}
+
+	// Get
+	scheduleID := uuid.New()
+	nextRunAt := time.Now().Add(time.Hour)
+	mock.ExpectQuery(selectQuery).
+		WithArgs(scheduleID, 1).
+		WillReturnRows(row(scheduleID, true, &nextRunAt))
+
+	w = send(server.handleGetSchedule, http.MethodGet, scheduleID, "")
+	assert.Equal(http.StatusOK, w.Code)
+	assert.NoError(json.NewDecoder(w.Body).Decode(&schedule))
+	assert.Equal(scheduleID, schedule.ID)
+	assert.Equal("Europe/Budapest", schedule.Timezone)
+
+	mock.ExpectQuery(selectQuery).
+		WillReturnError(gorm.ErrRecordNotFound)
+
+	w = send(server.handleGetSchedule, http.MethodGet, uuid.New(), "")
+	assert.Equal(http.StatusNotFound, w.Code)
+
+	// List
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "schedule_data" ORDER BY date ASC`)).
+		WillReturnRows(row(scheduleID, true, &nextRunAt))
+
+	w = send(server.handleListSchedules, http.MethodGet, uuid.Nil, "")
+	assert.Equal(http.StatusOK, w.Code)
+	var list struct {
+		Schedules []Schedule `json:"schedules"`
+	}
+	assert.NoError(json.NewDecoder(w.Body).Decode(&list))
+	assert.Len(list.Schedules, 1)
+
+	// Disable - the schedule has no next fire anymore
+	mock.ExpectBegin()
+	mock.ExpectQuery(lockQuery).
+		WithArgs(scheduleID, 1).
+		WillReturnRows(row(scheduleID, true, &nextRunAt))
+	mock.ExpectExec(updateQuery).
+		WithArgs(
+			"nightly", "0 3 * * *", "Europe/Budapest", false, sqlmock.AnyArg(), sqlmock.AnyArg(),
+			nil, nil, nil, scheduleID,
+		).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w = send(server.handleUpdateSchedule, http.MethodPatch, scheduleID, `{"enabled":false}`)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.NoError(json.NewDecoder(w.Body).Decode(&schedule))
+	assert.False(schedule.Enabled)
+	assert.Nil(schedule.NextRunAt)
+
+	// Enable with a new cron expression - scheduled again
+	mock.ExpectBegin()
+	mock.ExpectQuery(lockQuery).
+		WithArgs(scheduleID, 1).
+		WillReturnRows(row(scheduleID, false, nil))
+	mock.ExpectExec(updateQuery).
+		WithArgs(
+			"nightly", "*/5 * * * *", "Europe/Budapest", true, sqlmock.AnyArg(), sqlmock.AnyArg(),
+			sqlmock.AnyArg(), nil, nil, scheduleID,
+		).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w = send(server.handleUpdateSchedule, http.MethodPatch, scheduleID, `{"enabled":true,"cron":"*/5 * * * *"}`)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.NoError(json.NewDecoder(w.Body).Decode(&schedule))
+	assert.True(schedule.Enabled)
+	assert.NotNil(schedule.NextRunAt)
+	assert.True(schedule.NextRunAt.Before(time.Now().Add(5 * time.Minute)))
+
+	// Update - invalid cron expression is rejected
+	mock.ExpectBegin()
+	mock.ExpectQuery(lockQuery).
+		WillReturnRows(row(scheduleID, true, &nextRunAt))
+	mock.ExpectRollback()
+
+	w = send(server.handleUpdateSchedule, http.MethodPatch, scheduleID, `{"cron":"every night"}`)
+	assert.Equal(http.StatusBadRequest, w.Code)
+
+	// Update - unknown schedule
+	mock.ExpectBegin()
+	mock.ExpectQuery(lockQuery).
+		WillReturnError(gorm.ErrRecordNotFound)
+	mock.ExpectRollback()
+
+	w = send(server.handleUpdateSchedule, http.MethodPatch, uuid.New(), `{"enabled":false}`)
+	assert.Equal(http.StatusNotFound, w.Code)
+
+	// Delete
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "schedule_data" WHERE id = $1`)).
+		WithArgs(scheduleID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w = send(server.handleDeleteSchedule, http.MethodDelete, scheduleID, "")
+	assert.Equal(http.StatusNoContent, w.Code)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "schedule_data" WHERE id = $1`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	w = send(server.handleDeleteSchedule, http.MethodDelete, uuid.New(), "")
+	assert.Equal(http.StatusNotFound, w.Code)
+
+	assert.NoError(mock.ExpectationsWereMet())
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ScheduleUpdate changes the given fields of a schedule, e.g. disables it
+// with {"enabled": false}.
+type ScheduleUpdate struct {
+	Name     *string     `json:"name"`
+	Cron     *string     `json:"cron"`
+	Timezone *string     `json:"timezone"`
+	Enabled  *bool       `json:"enabled"`
+	Task     *TaskCreate `json:"task"`
+}
+
+func (s *Server) handleUpdateSchedule(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	idStr, ok := vars["id"]
+	if !ok {
+		http.Error(w, "id parameter is missing", http.StatusBadRequest)
+		return
+	}
+	log.Infof("Updating a schedule with id %s", idStr)
+
+	scheduleID, err := uuid.Parse(idStr)
+	if err != nil {
+		http.Error(w, "invalid id format", http.StatusBadRequest)
+		return
+	}
+
+	var scheduleUpdate ScheduleUpdate
+	if err := json.NewDecoder(r.Body).Decode(&scheduleUpdate); err != nil {
+		log.Error("invalid request payload: " + err.Error())
+		http.Error(w, "invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		log.Error("failed to start transaction: " + tx.Error.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	var scheduleData ScheduleData
+	err = tx.
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(&scheduleData, "id = ?", scheduleID).Error
+	if err != nil {
+		tx.Rollback()
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "schedule not found", http.StatusNotFound)
+		} else {
+			log.Error("failed to retrieve schedule: " + err.Error())
+			http.Error(w, "failed to retrieve schedule", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if scheduleUpdate.Name != nil {
+		scheduleData.Name = *scheduleUpdate.Name
+	}
+	if scheduleUpdate.Cron != nil {
+		scheduleData.Cron = *scheduleUpdate.Cron
+	}
+	if scheduleUpdate.Timezone != nil {
+		scheduleData.Timezone = *scheduleUpdate.Timezone
+	}
+	if scheduleUpdate.Enabled != nil {
+		scheduleData.Enabled = *scheduleUpdate.Enabled
+	}
+	if scheduleUpdate.Task != nil {
+		if err := validateTemplate(*scheduleUpdate.Task, s.cfg.MaxTaskTimeout); err != nil {
+			tx.Rollback()
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		scheduleData.Task = *scheduleUpdate.Task
+	}
+	if _, err := nextRun(scheduleData.Cron, scheduleData.Timezone, time.Now()); err != nil {
+		tx.Rollback()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := scheduleData.reschedule(time.Now()); err != nil {
+		tx.Rollback()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := tx.Save(&scheduleData).Error; err != nil {
+		tx.Rollback()
+		log.Error("failed to update schedule: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		log.Error("failed to commit transaction: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	schedule := scheduleData.toSchedule()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(schedule); err != nil {
+		log.Error("failed to encode response: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
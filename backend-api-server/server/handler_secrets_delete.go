@@ -0,0 +1,33 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// handleDeleteSecret deletes a secret. Queued tasks referring to it fail when
+// they get picked.
+func (s *Server) handleDeleteSecret(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name, ok := vars["name"]
+	if !ok {
+		http.Error(w, "name parameter is missing", http.StatusBadRequest)
+		return
+	}
+	log.Infof("Deleting secret %s", name)
+
+	result := s.db.Delete(&SecretData{}, "name = ?", name)
+	if result.Error != nil {
+		log.Error("failed to delete secret: " + result.Error.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		http.Error(w, "secret not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// handleGetSecret returns the metadata of a secret, its value is never
+// returned.
+func (s *Server) handleGetSecret(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name, ok := vars["name"]
+	if !ok {
+		http.Error(w, "name parameter is missing", http.StatusBadRequest)
+		return
+	}
+	log.Infof("Getting secret %s", name)
+
+	var secretData SecretData
+	err := s.db.
+		Select("name", "created_at", "updated_at").
+		First(&secretData, "name = ?", name).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "secret not found", http.StatusNotFound)
+		} else {
+			log.Error("failed to retrieve secret: " + err.Error())
+			http.Error(w, "failed to retrieve secret", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(secretData); err != nil {
+		log.Error("failed to encode response: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
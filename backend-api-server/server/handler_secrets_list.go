@@ -0,0 +1,34 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func (s *Server) handleListSecrets(w http.ResponseWriter, r *http.Request) {
+	log.Info("Listing secrets")
+	secretsData := []SecretData{}
+	err := s.db.
+		Select("name", "created_at", "updated_at").
+		Order("name ASC").
+		Find(&secretsData).Error
+	if err != nil {
+		log.Error("failed to retrieve secrets: " + err.Error())
+		http.Error(w, "failed to retrieve secrets", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"secrets": secretsData,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(response); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm/clause"
+)
+
+type SecretPut struct {
+	Value string `json:"value"`
+}
+
+// handlePutSecret creates a secret or replaces the value of an existing one.
+func (s *Server) handlePutSecret(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name, ok := vars["name"]
+	if !ok {
+		http.Error(w, "name parameter is missing", http.StatusBadRequest)
+		return
+	}
+	log.Infof("Storing secret %s", name)
+
+	if !secretNamePattern.MatchString(name) {
+		http.Error(w, "invalid secret name", http.StatusBadRequest)
+		return
+	}
+
+	var secretPut SecretPut
+	if err := json.NewDecoder(r.Body).Decode(&secretPut); err != nil {
+		log.Error("invalid request payload: " + err.Error())
+		http.Error(w, "invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	secretData := SecretData{Name: name}
+	if err := secretData.seal(s.secrets, secretPut.Value); err != nil {
+		log.Error("failed to encrypt secret: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	err := s.db.
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "name"}},
+			DoUpdates: clause.AssignmentColumns([]string{"nonce", "ciphertext", "updated_at"}),
+		}).
+		Create(&secretData).Error
+	if err != nil {
+		log.Error("failed to save secret: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	err = s.db.
+		Select("name", "created_at", "updated_at").
+		First(&secretData, "name = ?", name).Error
+	if err != nil {
+		log.Error("failed to retrieve secret: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(secretData); err != nil {
+		log.Error("failed to encode response: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
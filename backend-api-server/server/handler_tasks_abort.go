@@ -0,0 +1,104 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// handleAbortTask cancels a task. Queued tasks are cancelled right away,
+// in progress tasks are flagged as cancelling and the executor agent running
+// them kills the command and reports the partial output via finish.
+func (s *Server) handleAbortTask(w http.ResponseWriter, r *http.Request) {
+	log.Info("Aborting a task")
+	vars := mux.Vars(r)
+	idStr, ok := vars["id"]
+	if !ok {
+		http.Error(w, "id parameter is missing", http.StatusBadRequest)
+		return
+	}
+	taskID, err := uuid.Parse(idStr)
+	if err != nil {
+		http.Error(w, "invalid id format", http.StatusBadRequest)
+		return
+	}
+
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		log.Error("failed to start transaction: " + tx.Error.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	var taskData TaskData
+	err = tx.
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(&taskData, "id = ?", taskID).Error
+	if err != nil {
+		tx.Rollback()
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "task not found", http.StatusNotFound)
+		} else {
+			log.Error("failed to retrieve task: " + err.Error())
+			http.Error(w, "failed to retrieve task", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if !taskData.cancel() {
+		tx.Rollback()
+		http.Error(w, "task has already completed", http.StatusConflict)
+		return
+	}
+	statusCode := http.StatusOK
+	if taskData.Status == statusCancelling {
+		statusCode = http.StatusAccepted
+	}
+
+	if err := tx.Save(&taskData).Error; err != nil {
+		tx.Rollback()
+		log.Error("failed to update task: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err := recordTaskCompletion(tx, &taskData); err != nil {
+		tx.Rollback()
+		log.Error("failed to record task event: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := skipDependents(tx, &taskData); err != nil {
+		tx.Rollback()
+		log.Error("failed to skip dependent tasks: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err := notifyTaskDone(tx, &taskData); err != nil {
+		tx.Rollback()
+		log.Error("failed to notify queued task: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		log.Error("failed to commit transaction: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	task := taskData.toTask()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(task); err != nil {
+		log.Error("failed to encode response: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestHandlerTaskAbort(t *testing.T) {
+	assert := assert.New(t)
+
+	// Mock
+	mockDB, mock, err := sqlmock.New()
+	assert.NoError(err)
+	defer func() { _ = mockDB.Close() }()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:                 mockDB,
+		DriverName:           "postgres",
+		PreferSimpleProtocol: true,
+	})
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	assert.NoError(err)
+
+	server := Server{db: db}
+	selectQuery := regexp.QuoteMeta(`SELECT * FROM "task_data" WHERE id = $1`)
+	updateQuery := regexp.QuoteMeta(`UPDATE "task_data" SET`)
+	dependentsQuery := regexp.QuoteMeta(`SELECT * FROM "task_data" WHERE status = $1 AND (id IN (SELECT task_id FROM task_dependencies WHERE parent_id IN ($2) AND NOT allow_failure)) FOR UPDATE`)
+	notifyQuery := regexp.QuoteMeta(`SELECT pg_notify($1, $2)`)
+	cappedQuery := regexp.QuoteMeta(`SELECT count(*) FROM "queue_data" WHERE name = $1 AND max_in_flight IS NOT NULL`)
+	dependentQueuesQuery := regexp.QuoteMeta(`SELECT DISTINCT "queue" FROM "task_data" WHERE status = $1 AND id IN (SELECT task_id FROM task_dependencies WHERE parent_id = $2)`)
+	columns := []string{"id", "command", "date", "status"}
+
+	abort := func(id uuid.UUID) *http.Response {
+		req := httptest.NewRequest(http.MethodPost, "/tasks/"+id.String()+"/abort", nil)
+		req = mux.SetURLVars(req, map[string]string{"id": id.String()})
+		w := httptest.NewRecorder()
+		server.handleAbortTask(w, req)
+		return w.Result()
+	}
+
+	// Abort queued task - cancelled immediately, dependent task is skipped
+	// and the agents are notified of the one allowed to fail
+	queuedID := uuid.New()
+	dependentID := uuid.New()
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectQuery).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(queuedID, "sleep 10", time.Now(), statusQueued))
+	mock.ExpectExec(updateQuery).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "webhook_events"`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(notifyQuery).
+		WithArgs(taskLogsChannel, queuedID.String()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(dependentsQuery).
+		WithArgs(statusQueued, queuedID).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(dependentID, "echo after", time.Now(), statusQueued))
+	mock.ExpectExec(updateQuery).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "webhook_events"`)).
+		WithArgs(sqlmock.AnyArg(), eventTaskSkipped, dependentID, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(notifyQuery).
+		WithArgs(taskLogsChannel, dependentID.String()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(dependentsQuery).
+		WithArgs(statusQueued, dependentID).
+		WillReturnRows(sqlmock.NewRows(columns))
+	mock.ExpectQuery(cappedQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(dependentQueuesQuery).
+		WithArgs(statusQueued, queuedID).
+		WillReturnRows(sqlmock.NewRows([]string{"queue"}).AddRow(defaultQueue))
+	mock.ExpectExec(notifyQuery).
+		WithArgs(taskQueuedChannel, defaultQueue).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	resp := abort(queuedID)
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Contains(string(body), `"status":"cancelled"`)
+
+	// Abort in progress task - agent is asked to stop it
+	runningID := uuid.New()
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectQuery).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(runningID, "sleep 10", time.Now(), statusInProgress))
+	mock.ExpectExec(updateQuery).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(notifyQuery).
+		WithArgs(taskLogsChannel, runningID.String()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(cappedQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectCommit()
+
+	resp = abort(runningID)
+	assert.Equal(http.StatusAccepted, resp.StatusCode)
+	body, _ = io.ReadAll(resp.Body)
+	assert.Contains(string(body), `"status":"cancelling"`)
+
+	// Abort finished task - conflict
+	finishedID := uuid.New()
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectQuery).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(finishedID, "true", time.Now(), statusFinished))
+	mock.ExpectRollback()
+
+	resp = abort(finishedID)
+	assert.Equal(http.StatusConflict, resp.StatusCode)
+
+	// Abort unknown task
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectQuery).
+		WillReturnError(gorm.ErrRecordNotFound)
+	mock.ExpectRollback()
+
+	resp = abort(uuid.New())
+	assert.Equal(http.StatusNotFound, resp.StatusCode)
+
+	assert.NoError(mock.ExpectationsWereMet())
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+func (s *Server) handleGetArtifact(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	idStr, ok := vars["id"]
+	if !ok {
+		http.Error(w, "id parameter is missing", http.StatusBadRequest)
+		return
+	}
+	name := vars["name"]
+	log.Infof("Downloading artifact %s of task with id %s", name, idStr)
+
+	taskID, err := uuid.Parse(idStr)
+	if err != nil {
+		http.Error(w, "invalid id format", http.StatusBadRequest)
+		return
+	}
+	if err := validateArtifactName(name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var artifact ArtifactData
+	if err := s.db.First(&artifact, "task_id = ? AND name = ?", taskID, name).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "artifact not found", http.StatusNotFound)
+		} else {
+			log.Error("failed to retrieve artifact: " + err.Error())
+			http.Error(w, "failed to retrieve artifact", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	contents, err := s.artifacts.Get(r.Context(), artifact.key())
+	if err != nil {
+		if errors.Is(err, errArtifactNotFound) {
+			http.Error(w, "artifact not found", http.StatusNotFound)
+		} else {
+			log.Error("failed to open artifact: " + err.Error())
+			http.Error(w, "failed to open artifact", http.StatusInternalServerError)
+		}
+		return
+	}
+	defer contents.Close()
+
+	// Large artifacts take longer to send than the write timeout of the
+	// server allows.
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(artifact.Size, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(name)}))
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, contents); err != nil {
+		log.Error("failed to send artifact: " + err.Error())
+	}
+}
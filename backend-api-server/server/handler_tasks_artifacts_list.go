@@ -0,0 +1,58 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+func (s *Server) handleListArtifacts(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	idStr, ok := vars["id"]
+	if !ok {
+		http.Error(w, "id parameter is missing", http.StatusBadRequest)
+		return
+	}
+	log.Infof("Listing artifacts of task with id %s", idStr)
+
+	taskID, err := uuid.Parse(idStr)
+	if err != nil {
+		http.Error(w, "invalid id format", http.StatusBadRequest)
+		return
+	}
+
+	var taskData TaskData
+	if err := s.db.Select("id").First(&taskData, "id = ?", taskID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "task not found", http.StatusNotFound)
+		} else {
+			log.Error("failed to retrieve task: " + err.Error())
+			http.Error(w, "failed to retrieve task", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	artifacts := []ArtifactData{}
+	if err := s.db.Where("task_id = ?", taskID).Order("name ASC").Find(&artifacts).Error; err != nil {
+		log.Error("failed to retrieve artifacts: " + err.Error())
+		http.Error(w, "failed to retrieve artifacts", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"artifacts": artifacts,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(response); err != nil {
+		log.Error("failed to encode response: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var errArtifactTooLarge = errors.New("artifact is too large")
+
+// handleUploadArtifacts stores the artifacts of a task, sent by the executor
+// agent holding its lease as a multipart form. The lease_id field has to come
+// first, it is followed by a file part per artifact named by its path
+// relative to the working directory of the task.
+func (s *Server) handleUploadArtifacts(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	idStr, ok := vars["id"]
+	if !ok {
+		http.Error(w, "id parameter is missing", http.StatusBadRequest)
+		return
+	}
+	log.Infof("Uploading artifacts of task %s", idStr)
+	taskID, err := uuid.Parse(idStr)
+	if err != nil {
+		http.Error(w, "invalid id format", http.StatusBadRequest)
+		return
+	}
+
+	// Large artifacts take longer to receive than the read timeout of the
+	// server allows.
+	_ = http.NewResponseController(w).SetReadDeadline(time.Time{})
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "request must be multipart/form-data", http.StatusBadRequest)
+		return
+	}
+	leaseID, err := readLeaseField(reader)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var taskData TaskData
+	if err := s.db.First(&taskData, "id = ?", taskID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "task not found", http.StatusNotFound)
+		} else {
+			log.Error("failed to retrieve task: " + err.Error())
+			http.Error(w, "failed to retrieve task", http.StatusInternalServerError)
+		}
+		return
+	}
+	if !taskData.leasedTo(leaseID) {
+		http.Error(w, "task lease is no longer held", http.StatusConflict)
+		return
+	}
+
+	// The contents are stored under the id of this upload. They are removed
+	// again unless the artifacts are recorded, and replace the contents of
+	// the recorded artifacts of the same name only once recorded.
+	uploadID := uuid.New()
+	artifacts := []ArtifactData{}
+	recorded := false
+	defer func() {
+		if !recorded {
+			s.deleteArtifactContents(artifacts)
+		}
+	}()
+	names := []string{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, "failed to read multipart body", http.StatusBadRequest)
+			return
+		}
+		name := partFileName(part)
+		if err := validateArtifactName(name); err != nil {
+			part.Close()
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if slices.Contains(names, name) {
+			part.Close()
+			http.Error(w, fmt.Sprintf("duplicate artifact name: %q", name), http.StatusBadRequest)
+			return
+		}
+		names = append(names, name)
+
+		size, err := s.storeArtifact(r, artifactKey(taskID, uploadID, name), part)
+		part.Close()
+		if errors.Is(err, errArtifactTooLarge) {
+			http.Error(w, err.Error()+": "+name, http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			log.Error("failed to store artifact: " + err.Error())
+			http.Error(w, "failed to store artifact", http.StatusInternalServerError)
+			return
+		}
+		artifacts = append(artifacts, ArtifactData{
+			TaskID:   taskID,
+			Name:     name,
+			Size:     size,
+			Attempt:  taskData.Attempt,
+			UploadID: uploadID,
+		})
+	}
+
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		log.Error("failed to start transaction: " + tx.Error.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	err = tx.
+		Clauses(clause.Locking{Strength: "SHARE"}).
+		First(&taskData, "id = ?", taskID).Error
+	if err != nil {
+		tx.Rollback()
+		log.Error("failed to retrieve task: " + err.Error())
+		http.Error(w, "failed to retrieve task", http.StatusInternalServerError)
+		return
+	}
+	if !taskData.leasedTo(leaseID) {
+		tx.Rollback()
+		http.Error(w, "task lease is no longer held", http.StatusConflict)
+		return
+	}
+	var replaced []ArtifactData
+	if len(artifacts) > 0 {
+		err = tx.
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("task_id = ? AND name IN ?", taskID, names).
+			Find(&replaced).Error
+		if err != nil {
+			tx.Rollback()
+			log.Error("failed to retrieve artifacts: " + err.Error())
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		err = tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "task_id"}, {Name: "name"}},
+			DoUpdates: clause.AssignmentColumns([]string{"size", "attempt", "upload_id", "created_at"}),
+		}).Create(&artifacts).Error
+		if err != nil {
+			tx.Rollback()
+			log.Error("failed to save artifacts: " + err.Error())
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit().Error; err != nil {
+		log.Error("failed to commit transaction: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	recorded = true
+	s.deleteArtifactContents(replaced)
+
+	response := map[string]interface{}{
+		"artifacts": artifacts,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(response); err != nil {
+		log.Error("failed to encode response: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// readLeaseField reads the lease_id field leading the multipart form.
+func readLeaseField(reader *multipart.Reader) (uuid.UUID, error) {
+	part, err := reader.NextPart()
+	if err != nil || part.FormName() != "lease_id" {
+		return uuid.UUID{}, errors.New("lease_id field must come first")
+	}
+	defer part.Close()
+	value, err := io.ReadAll(io.LimitReader(part, 64))
+	if err != nil {
+		return uuid.UUID{}, errors.New("failed to read lease_id field")
+	}
+	leaseID, err := uuid.Parse(string(value))
+	if err != nil {
+		return uuid.UUID{}, errors.New("invalid lease_id format")
+	}
+	return leaseID, nil
+}
+
+// storeArtifact spools the contents to a temporary file to learn their size,
+// which object storages need up front, and puts them into the store.
+func (s *Server) storeArtifact(r *http.Request, key string, contents io.Reader) (int64, error) {
+	file, err := os.CreateTemp("", "artifact-*")
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		file.Close()
+		os.Remove(file.Name())
+	}()
+
+	size, err := io.Copy(file, io.LimitReader(contents, s.cfg.MaxArtifactSize+1))
+	if err != nil {
+		return 0, err
+	}
+	if size > s.cfg.MaxArtifactSize {
+		return 0, errArtifactTooLarge
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return size, s.artifacts.Put(r.Context(), key, file, size)
+}
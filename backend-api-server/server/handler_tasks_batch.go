@@ -0,0 +1,147 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// taskInsertBatchSize limits the rows of a single insert, so that the
+// parameters of a statement stay below the limit of Postgres.
+const taskInsertBatchSize = 500
+
+// TaskBatch holds the tasks of POST /tasks:batch.
+type TaskBatch struct {
+	Tasks []TaskCreate `json:"tasks"`
+}
+
+// TaskBatchResult is the outcome of one task of a batch, either the created
+// task or the reason it was rejected.
+type TaskBatchResult struct {
+	Task  *Task  `json:"task,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// handleCreateTaskBatch creates up to MaxBatchSize tasks in one transaction.
+// Invalid tasks are rejected one by one, their results carry the error while
+// the other tasks are created.
+func (s *Server) handleCreateTaskBatch(w http.ResponseWriter, r *http.Request) {
+	var batch TaskBatch
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		log.Error("invalid request payload: " + err.Error())
+		http.Error(w, "invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if len(batch.Tasks) == 0 || len(batch.Tasks) > s.cfg.MaxBatchSize {
+		http.Error(w, fmt.Sprintf("batch must have between 1 and %d tasks", s.cfg.MaxBatchSize), http.StatusBadRequest)
+		return
+	}
+	log.Infof("Creating batch of %d tasks", len(batch.Tasks))
+
+	results, err := s.submitTasks(batch.Tasks)
+	if err != nil {
+		log.Error("failed to save tasks: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"results": results,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(response); err != nil {
+		log.Error("failed to encode response: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// submitTasks saves the valid tasks of a batch in one transaction and wakes
+// up the agents waiting for them. Tasks without dependencies are inserted
+// together, the others one by one as their parents have to be checked.
+func (s *Server) submitTasks(creates []TaskCreate) ([]TaskBatchResult, error) {
+	results := make([]TaskBatchResult, len(creates))
+	tasksData := make([]TaskData, len(creates))
+	var independent []TaskData
+	var independentIndexes []int
+	for i := range creates {
+		task, err := creates[i].toTask(s.cfg.MaxTaskTimeout)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		tasksData[i] = task.toTaskData()
+		tasksData[i].rank(s.cfg.PriorityAgingInterval)
+		if len(task.DependsOn) == 0 {
+			independent = append(independent, tasksData[i])
+			independentIndexes = append(independentIndexes, i)
+		}
+	}
+
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	if len(independent) > 0 {
+		if err := tx.CreateInBatches(&independent, taskInsertBatchSize).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		events := make([]WebhookEvent, len(independent))
+		for j, i := range independentIndexes {
+			tasksData[i] = independent[j]
+			events[j] = newWebhookEvent(eventTaskCreated, &independent[j])
+		}
+		if err := tx.CreateInBatches(&events, taskInsertBatchSize).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	var queues []string
+	for i := range tasksData {
+		taskData := &tasksData[i]
+		if results[i].Error != "" {
+			continue
+		}
+		if len(taskData.DependsOn) > 0 {
+			err := createTask(tx, taskData, false)
+			if errors.Is(err, errUnknownParent) {
+				results[i].Error = err.Error()
+				continue
+			}
+			if err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+		}
+		task := taskData.toTask()
+		results[i].Task = &task
+		pickable, err := pickableNow(tx, taskData)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		if pickable && !slices.Contains(queues, taskData.Queue) {
+			queues = append(queues, taskData.Queue)
+		}
+	}
+	for _, queue := range queues {
+		if err := notifyTaskQueued(tx, queue); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return results, nil
+}
@@ -0,0 +1,222 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestHandlerCreateTaskBatch(t *testing.T) {
+	assert := assert.New(t)
+
+	// Mock
+	mockDB, mock, err := sqlmock.New()
+	assert.NoError(err)
+	defer func() { _ = mockDB.Close() }()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:                 mockDB,
+		DriverName:           "postgres",
+		PreferSimpleProtocol: true,
+	})
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	assert.NoError(err)
+
+	server := Server{db: db, cfg: &Config{MaxBatchSize: 3}}
+	createBatch := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/tasks:batch", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		server.handleCreateTaskBatch(w, req)
+		return w
+	}
+
+	// Too many and no tasks
+	w := createBatch(`{"tasks":[{"command":"a"},{"command":"b"},{"command":"c"},{"command":"d"}]}`)
+	assert.Equal(http.StatusBadRequest, w.Code)
+	w = createBatch(`{"tasks":[]}`)
+	assert.Equal(http.StatusBadRequest, w.Code)
+
+	// Invalid tasks are rejected one by one, the others are created
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "task_data"`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "webhook_events"`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT "id","status","exit_code" FROM "task_data" WHERE id IN ($1) FOR SHARE`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status", "exit_code"}))
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_notify(`)).
+		WithArgs(taskQueuedChannel, defaultQueue).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	w = createBatch(`{"tasks":[` +
+		`{"command":"echo 1"},` +
+		`{"command":"echo 2","priority":5000},` +
+		`{"command":"echo 3","depends_on":["` + uuid.NewString() + `"]}]}`)
+	assert.Equal(http.StatusOK, w.Code)
+	var response struct {
+		Results []TaskBatchResult `json:"results"`
+	}
+	assert.NoError(json.NewDecoder(w.Body).Decode(&response))
+	assert.Len(response.Results, 3)
+	assert.Equal("echo 1", response.Results[0].Task.Command)
+	assert.Equal(statusQueued, response.Results[0].Task.Status)
+	assert.Empty(response.Results[0].Error)
+	assert.Nil(response.Results[1].Task)
+	assert.Contains(response.Results[1].Error, "priority")
+	assert.Nil(response.Results[2].Task)
+	assert.Equal(errUnknownParent.Error(), response.Results[2].Error)
+
+	assert.NoError(mock.ExpectationsWereMet())
+}
+
+func TestHandlerBulkTasks(t *testing.T) {
+	assert := assert.New(t)
+
+	// Mock
+	mockDB, mock, err := sqlmock.New()
+	assert.NoError(err)
+	defer func() { _ = mockDB.Close() }()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:                 mockDB,
+		DriverName:           "postgres",
+		PreferSimpleProtocol: true,
+	})
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	assert.NoError(err)
+
+	store, err := newLocalArtifactStore(t.TempDir())
+	assert.NoError(err)
+	server := Server{db: db, cfg: &Config{}, artifacts: store}
+	bulk := func(name string, operation func(TaskFilter) (int, error), query string) (int, int) {
+		req := httptest.NewRequest(http.MethodPost, "/tasks:"+name+"?"+query, nil)
+		w := httptest.NewRecorder()
+		server.handleBulkTasks(name, operation)(w, req)
+		var response struct {
+			Count int `json:"count"`
+		}
+		if w.Code == http.StatusOK {
+			assert.NoError(json.NewDecoder(w.Body).Decode(&response))
+		}
+		return w.Code, response.Count
+	}
+	columns := []string{"id", "command", "status", "queue", "bundle_size"}
+
+	// A filter is required
+	code, _ := bulk("cancel", server.cancelTasks, "")
+	assert.Equal(http.StatusBadRequest, code)
+	code, _ = bulk("cancel", server.cancelTasks, "status=done")
+	assert.Equal(http.StatusBadRequest, code)
+
+	// Cancel - batches continue after the last changed task, only the
+	// queued task is cancelled right away and skips its dependents
+	queuedID, runningID := uuid.New(), uuid.New()
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(
+		`SELECT * FROM "task_data" WHERE command LIKE $1 AND status IN ($2,$3) AND id > $4 ORDER BY id LIMIT $5 FOR UPDATE`)).
+		WithArgs("%deploy%", statusQueued, statusInProgress, uuid.Nil, bulkBatchSize).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(queuedID, "deploy", statusQueued, defaultQueue, nil).
+			AddRow(runningID, "deploy", statusInProgress, defaultQueue, nil))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "task_data" SET`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "webhook_events"`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_notify($1, $2)`)).
+		WithArgs(taskLogsChannel, queuedID.String()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "task_data" WHERE status = $1 AND (id IN (SELECT task_id FROM task_dependencies`)).
+		WillReturnRows(sqlmock.NewRows(columns))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "task_data" SET`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_notify($1, $2)`)).
+		WithArgs(taskLogsChannel, runningID.String()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "task_data" WHERE command LIKE $1`)).
+		WithArgs("%deploy%", statusQueued, statusInProgress, runningID, bulkBatchSize).
+		WillReturnRows(sqlmock.NewRows(columns))
+	mock.ExpectRollback()
+
+	code, count := bulk("cancel", server.cancelTasks, "command=deploy")
+	assert.Equal(http.StatusOK, code)
+	assert.Equal(2, count)
+
+	// Requeue - tasks blocked by their parents are left alone, the agents
+	// are notified
+	failedID := uuid.New()
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "task_data" WHERE status IN ($1) AND status IN ($2,$3,$4,$5,$6) AND (NOT EXISTS (`)).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(failedID, "deploy", statusFailed, "builds", nil))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "task_data" SET`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "webhook_events"`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_notify($1, $2)`)).
+		WithArgs(taskLogsChannel, failedID.String()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_notify(`)).
+		WithArgs(taskQueuedChannel, "builds").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "task_data" WHERE status IN ($1)`)).
+		WillReturnRows(sqlmock.NewRows(columns))
+	mock.ExpectRollback()
+
+	code, count = bulk("requeue", server.requeueTasks, "status=failed")
+	assert.Equal(http.StatusOK, code)
+	assert.Equal(1, count)
+
+	// Delete - the contents in the artifact store are removed as well
+	ctx := context.Background()
+	assert.NoError(store.Put(ctx, bundleKey(failedID), strings.NewReader("tar"), 3))
+	uploadID := uuid.New()
+	assert.NoError(store.Put(ctx, artifactKey(failedID, uploadID, "report.txt"), strings.NewReader("ok"), 2))
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "task_data" WHERE status IN ($1)`)).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(failedID, "deploy", statusFailed, "builds", 3))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "webhook_events"`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "artifact_data" WHERE task_id IN ($1)`)).
+		WithArgs(failedID).
+		WillReturnRows(sqlmock.NewRows([]string{"task_id", "name", "upload_id"}).AddRow(failedID, "report.txt", uploadID))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "task_dependencies" WHERE task_id IN ($1) OR parent_id IN ($2)`)).
+		WithArgs(failedID, failedID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	for _, table := range []string{"task_attempts", "task_log_chunks", "artifact_data", "idempotency_keys"} {
+		mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "` + table + `" WHERE task_id IN ($1)`)).
+			WithArgs(failedID).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "task_data" WHERE id IN ($1)`)).
+		WithArgs(failedID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "task_data" WHERE status IN ($1)`)).
+		WillReturnRows(sqlmock.NewRows(columns))
+	mock.ExpectRollback()
+
+	code, count = bulk("delete", server.deleteTasks, "status=failed")
+	assert.Equal(http.StatusOK, code)
+	assert.Equal(1, count)
+	_, err = store.Get(ctx, bundleKey(failedID))
+	assert.ErrorIs(err, errArtifactNotFound)
+	_, err = store.Get(ctx, artifactKey(failedID, uploadID, "report.txt"))
+	assert.ErrorIs(err, errArtifactNotFound)
+
+	assert.NoError(mock.ExpectationsWereMet())
+}
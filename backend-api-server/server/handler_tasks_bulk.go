@@ -0,0 +1,238 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// bulkBatchSize is the number of tasks a bulk operation changes per
+// transaction, so that large operations neither hold locks on all matching
+// tasks nor lose all progress on an error.
+const bulkBatchSize = 100
+
+var completedStatuses = []string{statusFinished, statusFailed, statusCancelled, statusSkipped, statusTimedOut}
+
+// handleBulkTasks returns the handler of a bulk operation on the tasks
+// matching the filter parameters of GET /tasks. A filter is required, so
+// that no request changes all tasks by mistake. The response holds the
+// number of changed tasks.
+func (s *Server) handleBulkTasks(name string, operation func(TaskFilter) (int, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseTaskFilter(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := filter.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if filter.empty() {
+			http.Error(w, "at least one filter is required", http.StatusBadRequest)
+			return
+		}
+		log.Infof("Bulk %s of tasks matching %s", name, r.URL.RawQuery)
+
+		count, err := operation(filter)
+		if err != nil {
+			log.Errorf("failed to %s tasks after %d of them: %v", name, count, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		response := map[string]interface{}{
+			"count": count,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		encoder := json.NewEncoder(w)
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(response); err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+	}
+}
+
+// cancelTasks cancels the queued and running tasks matching the filter, like
+// aborting them one by one.
+func (s *Server) cancelTasks(filter TaskFilter) (int, error) {
+	return s.bulkChange(bulkScope(filter, statusQueued, statusInProgress), func(tx *gorm.DB, tasksData []TaskData) error {
+		for i := range tasksData {
+			taskData := &tasksData[i]
+			taskData.cancel()
+			if err := tx.Save(taskData).Error; err != nil {
+				return err
+			}
+			// Running tasks are only cancelling, they complete once their
+			// agents have stopped them.
+			if err := recordTaskCompletion(tx, taskData); err != nil {
+				return err
+			}
+			if err := skipDependents(tx, taskData); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// requeueTasks puts the completed tasks matching the filter back to their
+// queues with all their attempts. Tasks whose parents still did not succeed
+// are left alone, they could never be picked. Requeueing the parents first
+// makes them eligible.
+func (s *Server) requeueTasks(filter TaskFilter) (int, error) {
+	scope := func(db *gorm.DB) *gorm.DB {
+		return bulkScope(filter, completedStatuses...)(db).Where("NOT " + blockedDependencies)
+	}
+	return s.bulkChange(scope, func(tx *gorm.DB, tasksData []TaskData) error {
+		var queues []string
+		for i := range tasksData {
+			taskData := &tasksData[i]
+			taskData.requeue()
+			// Ranked again in case the aging interval changed, the task
+			// keeps aging from its creation.
+			taskData.rank(s.cfg.PriorityAgingInterval)
+			if err := tx.Save(taskData).Error; err != nil {
+				return err
+			}
+			if err := recordTaskEvent(tx, eventTaskRequeued, taskData); err != nil {
+				return err
+			}
+			pickable, err := pickableNow(tx, taskData)
+			if err != nil {
+				return err
+			}
+			if pickable && !slices.Contains(queues, taskData.Queue) {
+				queues = append(queues, taskData.Queue)
+			}
+		}
+		for _, queue := range queues {
+			if err := notifyTaskQueued(tx, queue); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// deleteTasks deletes the completed tasks matching the filter along with
+// their attempts, logs, dependencies and artifacts.
+func (s *Server) deleteTasks(filter TaskFilter) (int, error) {
+	total := 0
+	after := uuid.Nil
+	for {
+		var keys []string
+		count, err := s.changeTaskBatch(bulkScope(filter, completedStatuses...), &after, func(tx *gorm.DB, tasksData []TaskData) error {
+			ids := make([]uuid.UUID, len(tasksData))
+			events := make([]WebhookEvent, len(tasksData))
+			for i := range tasksData {
+				taskData := &tasksData[i]
+				ids[i] = taskData.ID
+				events[i] = newWebhookEvent(eventTaskDeleted, taskData)
+				if taskData.BundleSize != nil {
+					keys = append(keys, bundleKey(taskData.ID))
+				}
+			}
+			if err := tx.Create(&events).Error; err != nil {
+				return err
+			}
+			var artifactsData []ArtifactData
+			if err := tx.Where("task_id IN ?", ids).Find(&artifactsData).Error; err != nil {
+				return err
+			}
+			for _, artifactData := range artifactsData {
+				keys = append(keys, artifactData.key())
+			}
+
+			if err := tx.Where("task_id IN ? OR parent_id IN ?", ids, ids).Delete(&TaskDependency{}).Error; err != nil {
+				return err
+			}
+			for _, model := range []interface{}{&TaskAttempt{}, &TaskLogChunk{}, &ArtifactData{}, &IdempotencyKey{}} {
+				if err := tx.Where("task_id IN ?", ids).Delete(model).Error; err != nil {
+					return err
+				}
+			}
+			return tx.Where("id IN ?", ids).Delete(&TaskData{}).Error
+		})
+		if err != nil {
+			return total, err
+		}
+		// The contents are removed once the tasks are gone for good, a
+		// failure only leaves unreferenced files behind.
+		for _, key := range keys {
+			if err := s.artifacts.Delete(context.Background(), key); err != nil {
+				log.Errorf("failed to delete %s from the artifact store: %v", key, err)
+			}
+		}
+		total += count
+		if count == 0 {
+			return total, nil
+		}
+	}
+}
+
+// bulkScope selects the tasks matching the filter and one of the statuses.
+func bulkScope(filter TaskFilter, statuses ...string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return filter.apply(db).Where("status IN ?", statuses)
+	}
+}
+
+// bulkChange applies change to the tasks selected by scope, batch by batch in
+// the order of their ids. Every task is changed at most once, even if it is
+// selected again after the change.
+func (s *Server) bulkChange(scope func(*gorm.DB) *gorm.DB, change func(tx *gorm.DB, tasksData []TaskData) error) (int, error) {
+	total := 0
+	after := uuid.Nil
+	for {
+		count, err := s.changeTaskBatch(scope, &after, change)
+		total += count
+		if err != nil || count == 0 {
+			return total, err
+		}
+	}
+}
+
+// changeTaskBatch locks the next batch of the tasks selected by scope whose id
+// follows after, and commits the changes made to them. It returns the number
+// of changed tasks and moves after to the last of them.
+func (s *Server) changeTaskBatch(scope func(*gorm.DB) *gorm.DB, after *uuid.UUID, change func(tx *gorm.DB, tasksData []TaskData) error) (int, error) {
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+
+	var tasksData []TaskData
+	err := scope(tx).
+		Where("id > ?", *after).
+		Order("id").
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Limit(bulkBatchSize).
+		Find(&tasksData).Error
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if len(tasksData) == 0 {
+		tx.Rollback()
+		return 0, nil
+	}
+
+	if err := change(tx, tasksData); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return 0, err
+	}
+	*after = tasksData[len(tasksData)-1].ID
+	return len(tasksData), nil
+}
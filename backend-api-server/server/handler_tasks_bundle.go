@@ -0,0 +1,68 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// handleGetTaskBundle sends the input files of a task, the executor agent
+// unpacks them into the working directory of the task.
+func (s *Server) handleGetTaskBundle(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	idStr, ok := vars["id"]
+	if !ok {
+		http.Error(w, "id parameter is missing", http.StatusBadRequest)
+		return
+	}
+	log.Infof("Downloading input files of task with id %s", idStr)
+
+	taskID, err := uuid.Parse(idStr)
+	if err != nil {
+		http.Error(w, "invalid id format", http.StatusBadRequest)
+		return
+	}
+
+	var taskData TaskData
+	if err := s.db.Select("id", "bundle_size").First(&taskData, "id = ?", taskID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "task not found", http.StatusNotFound)
+		} else {
+			log.Error("failed to retrieve task: " + err.Error())
+			http.Error(w, "failed to retrieve task", http.StatusInternalServerError)
+		}
+		return
+	}
+	if taskData.BundleSize == nil {
+		http.Error(w, "task has no input files", http.StatusNotFound)
+		return
+	}
+
+	contents, err := s.artifacts.Get(r.Context(), bundleKey(taskID))
+	if err != nil {
+		if errors.Is(err, errArtifactNotFound) {
+			http.Error(w, "input files not found", http.StatusNotFound)
+		} else {
+			log.Error("failed to open input files: " + err.Error())
+			http.Error(w, "failed to open input files", http.StatusInternalServerError)
+		}
+		return
+	}
+	defer contents.Close()
+
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(*taskData.BundleSize, 10))
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, contents); err != nil {
+		log.Error("failed to send input files: " + err.Error())
+	}
+}
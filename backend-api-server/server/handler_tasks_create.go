@@ -2,7 +2,12 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
+	"path"
+	"regexp"
 	"time"
 
 	"github.com/google/uuid"
@@ -13,42 +18,320 @@ const (
 	statusQueued     = "queued"
 	statusInProgress = "in_progress"
 	statusFinished   = "finished"
+	statusFailed     = "failed"
+	statusCancelling = "cancelling"
+	statusCancelled  = "cancelled"
+	statusSkipped    = "skipped"
+	statusTimedOut   = "timed_out"
 )
 
 type Task struct {
 	ID         uuid.UUID  `json:"id"`
 	Command    string     `json:"command"`
+	Date       time.Time  `json:"date"`
 	StartedAt  *time.Time `json:"started_at"`
 	FinishedAt *time.Time `json:"finished_at"`
 	Status     string     `json:"status"`
 	Stdout     *string    `json:"stdout"`
 	Stderr     *string    `json:"stderr"`
-	ExitCode   *int       `json:"exit_code"`
+	// Output is the output of both streams line by line in the order it
+	// was written, Stdout and Stderr are views of it.
+	Output   []OutputLine `json:"output"`
+	ExitCode *int         `json:"exit_code"`
+	// StatusReason explains status changes the server made on its own,
+	// e.g. requeueing a task whose lease has expired.
+	StatusReason *string       `json:"status_reason"`
+	MaxAttempts  int           `json:"max_attempts"`
+	Attempt      int           `json:"attempt"`
+	Backoff      BackoffPolicy `json:"backoff"`
+	// NotBefore is the earliest time a failed attempt is retried at, RunAt
+	// the time the task has been scheduled for. The task is not picked
+	// before either of them.
+	NotBefore *time.Time `json:"not_before"`
+	RunAt     *time.Time `json:"run_at"`
+	Priority  int        `json:"priority"`
+	// ScheduleID links the task to the schedule that spawned it.
+	ScheduleID   *uuid.UUID `json:"schedule_id"`
+	ScheduledFor *time.Time `json:"scheduled_for"`
+	// DependsOn lists the tasks that have to finish successfully before
+	// this task can be picked.
+	DependsOn []uuid.UUID `json:"depends_on"`
+	// PipelineID links the task to the pipeline it is a step of.
+	PipelineID   *uuid.UUID `json:"pipeline_id"`
+	PipelineStep *int       `json:"pipeline_step"`
+	// TimeoutSeconds is the execution time limit requested for the task.
+	TimeoutSeconds *int `json:"timeout_seconds"`
+	// Env and Workdir are applied to the command on the agent.
+	Env     map[string]string `json:"env"`
+	Workdir string            `json:"workdir"`
+	// Secrets maps environment variable names to the names of the secrets
+	// exported in them. Values are never part of a task.
+	Secrets map[string]string `json:"secrets"`
+	// Artifacts are glob patterns of the files the agent uploads once the
+	// command has exited.
+	Artifacts []string `json:"artifacts"`
+	// BundleSize is the size of the input files unpacked into the working
+	// directory of the task, it is nil if the task has none.
+	BundleSize *int64 `json:"bundle_size"`
+	// AgentID is the executor agent that picked the task last.
+	AgentID *string `json:"agent_id"`
+	// Labels select the agents allowed to run the task, they must have all
+	// of these labels.
+	Labels map[string]string `json:"labels"`
+	// Queue is the queue the task waits in, only agents subscribed to it
+	// pick the task.
+	Queue string `json:"queue"`
+}
+
+// BackoffPolicy defines how long a failed task waits in the queue before its
+// next attempt. The delay grows by Multiplier after every failed attempt.
+type BackoffPolicy struct {
+	InitialSeconds int     `json:"initial_seconds"`
+	Multiplier     float64 `json:"multiplier"`
+	MaxSeconds     int     `json:"max_seconds"`
+}
+
+const (
+	maxTaskAttempts = 100
+	// maxBackoffSeconds caps the wait between two attempts at a week.
+	maxBackoffSeconds = 7 * 24 * 60 * 60
+	minTaskPriority   = -1000
+	maxTaskPriority   = 1000
+)
+
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+var defaultBackoff = BackoffPolicy{InitialSeconds: 10, Multiplier: 2, MaxSeconds: 600}
+
+// delay returns the wait after the given number of failed attempts. It never
+// exceeds maxBackoffSeconds, even for policies without MaxSeconds.
+func (b BackoffPolicy) delay(failedAttempts int) time.Duration {
+	if b.InitialSeconds <= 0 {
+		return 0
+	}
+	maxSeconds := maxBackoffSeconds
+	if b.MaxSeconds > 0 && b.MaxSeconds < maxSeconds {
+		maxSeconds = b.MaxSeconds
+	}
+	seconds := float64(b.InitialSeconds) * math.Pow(b.Multiplier, float64(failedAttempts-1))
+	seconds = math.Min(seconds, float64(maxSeconds))
+	return time.Duration(seconds * float64(time.Second))
 }
 
 type TaskCreate struct {
-	Command string `json:"command"`
+	Command     string         `json:"command"`
+	MaxAttempts *int           `json:"max_attempts"`
+	Backoff     *BackoffPolicy `json:"backoff"`
+	Priority    int            `json:"priority"`
+	// RunAt delays the execution of the task, it won't be picked earlier.
+	RunAt          *time.Time        `json:"run_at"`
+	DependsOn      []uuid.UUID       `json:"depends_on"`
+	TimeoutSeconds *int              `json:"timeout_seconds"`
+	Env            map[string]string `json:"env"`
+	Workdir        string            `json:"workdir"`
+	Secrets        map[string]string `json:"secrets"`
+	Artifacts      []string          `json:"artifacts"`
+	Labels         map[string]string `json:"labels"`
+	Queue          string            `json:"queue"`
+}
+
+// toTask validates the payload and creates a new queued task from it. The
+// timeout may not exceed maxTimeout, unless that is zero.
+func (c *TaskCreate) toTask(maxTimeout time.Duration) (Task, error) {
+	task := Task{
+		ID:             uuid.New(),
+		Command:        c.Command,
+		Status:         statusQueued,
+		MaxAttempts:    1,
+		Backoff:        defaultBackoff,
+		Priority:       c.Priority,
+		RunAt:          c.RunAt,
+		DependsOn:      c.DependsOn,
+		TimeoutSeconds: c.TimeoutSeconds,
+		Env:            c.Env,
+		Workdir:        c.Workdir,
+		Secrets:        c.Secrets,
+		Artifacts:      c.Artifacts,
+		Labels:         c.Labels,
+		Queue:          c.Queue,
+	}
+
+	if c.Priority < minTaskPriority || c.Priority > maxTaskPriority {
+		return Task{}, errors.New("priority must be between -1000 and 1000")
+	}
+
+	for name := range c.Env {
+		if !envNamePattern.MatchString(name) {
+			return Task{}, fmt.Errorf("invalid env variable name: %q", name)
+		}
+	}
+	for envName, secretName := range c.Secrets {
+		if !envNamePattern.MatchString(envName) {
+			return Task{}, fmt.Errorf("invalid env variable name: %q", envName)
+		}
+		if !secretNamePattern.MatchString(secretName) {
+			return Task{}, fmt.Errorf("invalid secret name: %q", secretName)
+		}
+	}
+	if c.Workdir != "" && !path.IsAbs(c.Workdir) {
+		return Task{}, errors.New("workdir must be an absolute path")
+	}
+	if task.Queue == "" {
+		task.Queue = defaultQueue
+	}
+	if !queueNamePattern.MatchString(task.Queue) {
+		return Task{}, errors.New("invalid queue name")
+	}
+	if err := validateLabels(c.Labels); err != nil {
+		return Task{}, err
+	}
+	if err := validateArtifactPatterns(c.Artifacts); err != nil {
+		return Task{}, err
+	}
+	if c.TimeoutSeconds != nil {
+		// Compared in seconds, a huge value would overflow a time.Duration.
+		maxSeconds := int(maxTimeout / time.Second)
+		if *c.TimeoutSeconds < 1 || (maxSeconds > 0 && *c.TimeoutSeconds > maxSeconds) {
+			return Task{}, fmt.Errorf("timeout_seconds must be between 1 and %d", maxSeconds)
+		}
+	}
+	if c.MaxAttempts != nil {
+		if *c.MaxAttempts < 1 || *c.MaxAttempts > maxTaskAttempts {
+			return Task{}, errors.New("max_attempts must be between 1 and 100")
+		}
+		task.MaxAttempts = *c.MaxAttempts
+	}
+	if c.Backoff != nil {
+		if c.Backoff.InitialSeconds < 0 || c.Backoff.Multiplier < 1 {
+			return Task{}, errors.New("backoff must have a non-negative initial interval and a multiplier of at least 1")
+		}
+		if c.Backoff.MaxSeconds < 1 || c.Backoff.MaxSeconds > maxBackoffSeconds {
+			return Task{}, fmt.Errorf("backoff max_seconds must be between 1 and %d", maxBackoffSeconds)
+		}
+		task.Backoff = *c.Backoff
+	}
+
+	return task, nil
 }
 
+// handleCreateTask accepts the task as a JSON body, or as a multipart form
+// whose leading "task" part holds the JSON, followed by the input files.
 func (s *Server) handleCreateTask(w http.ResponseWriter, r *http.Request) {
 	log.Info("Creating new task")
 	var taskCreate TaskCreate
-	if err := json.NewDecoder(r.Body).Decode(&taskCreate); err != nil {
+	body := r.Body
+	reader, err := r.MultipartReader()
+	if err == nil {
+		part, err := reader.NextPart()
+		if err != nil || part.FormName() != "task" {
+			http.Error(w, "task part must come first", http.StatusBadRequest)
+			return
+		}
+		body = part
+	}
+	if err := json.NewDecoder(body).Decode(&taskCreate); err != nil {
 		log.Error("invalid request payload: " + err.Error())
 		http.Error(w, "invalid request payload", http.StatusBadRequest)
 		return
 	}
-	task := Task{Command: taskCreate.Command}
-	task.ID = uuid.New()
-	task.Status = statusQueued
+	task, err := taskCreate.toTask(s.cfg.MaxTaskTimeout)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	taskData := task.toTaskData()
-	if err := s.db.Create(&taskData).Error; err != nil {
-		log.Error("failed to save task: " + err.Error())
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	var key *IdempotencyKey
+	if value := r.Header.Get(idempotencyKeyHeader); value != "" {
+		if len(value) > maxIdempotencyKeyLength {
+			http.Error(w, "idempotency key is too long", http.StatusBadRequest)
+			return
+		}
+		hash, err := hashTaskCreate(taskCreate)
+		if err != nil {
+			log.Error("failed to hash request: " + err.Error())
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		key = &IdempotencyKey{Key: value, RequestHash: hash, ExpiresAt: time.Now().Add(s.cfg.IdempotencyKeyTTL)}
+		if s.replayTask(w, *key) {
+			return
+		}
+	}
+
+	if reader != nil {
+		if task.Workdir != "" {
+			http.Error(w, "workdir cannot be combined with input files", http.StatusBadRequest)
+			return
+		}
+		_ = http.NewResponseController(w).SetReadDeadline(time.Time{})
+		size, err := s.storeBundle(r, task.ID, reader)
+		if err != nil {
+			s.deleteBundle(task.ID)
+		}
+		switch {
+		case errors.Is(err, errInvalidBundle):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, errArtifactTooLarge):
+			http.Error(w, "input files are too large", http.StatusRequestEntityTooLarge)
+			return
+		case err != nil:
+			log.Error("failed to store input files: " + err.Error())
+			http.Error(w, "failed to store input files", http.StatusInternalServerError)
+			return
+		}
+		task.BundleSize = &size
+	}
+
+	created, err := s.submitTask(task, key)
+	if err != nil && task.BundleSize != nil {
+		// The bundle has been stored up front, so that the task is never
+		// picked without it.
+		s.deleteBundle(task.ID)
+	}
+	if errors.Is(err, errIdempotencyKeyTaken) && s.replayTask(w, *key) {
+		// A concurrent request with the same key created the task first.
 		return
 	}
+	if err != nil {
+		switch {
+		case errors.Is(err, errUnknownParent):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, errIdempotencyKeyTaken):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			log.Error("failed to save task: " + err.Error())
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	writeCreatedTask(w, created)
+}
+
+// replayTask answers a retried request with the task created by the original
+// request of the idempotency key. It reports whether the request has been
+// answered, it has to create the task otherwise.
+func (s *Server) replayTask(w http.ResponseWriter, key IdempotencyKey) bool {
+	task, err := s.lookupIdempotentTask(key)
+	switch {
+	case errors.Is(err, errIdempotencyKeyReused):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return true
+	case err != nil:
+		log.Error("failed to look up idempotency key: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return true
+	case task == nil:
+		return false
+	}
+	log.Infof("Replaying creation of task %s", task.ID)
+	w.Header().Set(idempotentReplayHeader, "true")
+	writeCreatedTask(w, *task)
+	return true
+}
 
+func writeCreatedTask(w http.ResponseWriter, task Task) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	encoder := json.NewEncoder(w)
@@ -58,3 +341,35 @@ func (s *Server) handleCreateTask(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 	}
 }
+
+// submitTask saves a new task and wakes up the agents waiting for one. The
+// idempotency key, if any, is saved along with the task.
+func (s *Server) submitTask(task Task, key *IdempotencyKey) (Task, error) {
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		return Task{}, tx.Error
+	}
+
+	if key != nil {
+		key.TaskID = task.ID
+		if err := claimIdempotencyKey(tx, *key); err != nil {
+			tx.Rollback()
+			return Task{}, err
+		}
+	}
+	taskData := task.toTaskData()
+	taskData.rank(s.cfg.PriorityAgingInterval)
+	if err := createTask(tx, &taskData, false); err != nil {
+		tx.Rollback()
+		return Task{}, err
+	}
+	if err := notifyIfPickable(tx, &taskData); err != nil {
+		tx.Rollback()
+		return Task{}, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return Task{}, err
+	}
+	return taskData.toTask(), nil
+}
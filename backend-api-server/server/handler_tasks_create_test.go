@@ -8,9 +8,12 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -33,7 +36,7 @@ func TestHandlerTaskCreateOK(t *testing.T) {
 	assert.NoError(err)
 
 	// Create task OK
-	server := Server{db: db}
+	server := Server{db: db, cfg: &Config{MaxTaskTimeout: time.Hour}}
 
 	taskPayload := map[string]interface{}{
 		"command": "test command",
@@ -49,6 +52,11 @@ func TestHandlerTaskCreateOK(t *testing.T) {
 	insertQuery := regexp.QuoteMeta(`INSERT INTO "task_data"`)
 	mock.ExpectExec(insertQuery).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "webhook_events"`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_notify(`)).
+		WithArgs(taskQueuedChannel, defaultQueue).
+		WillReturnResult(sqlmock.NewResult(0, 0))
 	mock.ExpectCommit()
 
 	server.handleCreateTask(w, req)
@@ -84,6 +92,11 @@ func TestHandlerTaskCreateOK(t *testing.T) {
 	mock.ExpectBegin()
 	mock.ExpectExec(insertQuery).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "webhook_events"`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_notify(`)).
+		WithArgs(taskQueuedChannel, defaultQueue).
+		WillReturnResult(sqlmock.NewResult(0, 0))
 	mock.ExpectCommit()
 
 	server.handleCreateTask(w, req)
@@ -130,7 +143,7 @@ func TestHandlerTaskCreateNOK(t *testing.T) {
 	mock.ExpectRollback()
 
 	// Init
-	server := Server{db: db}
+	server := Server{db: db, cfg: &Config{MaxTaskTimeout: time.Hour}}
 
 	req := httptest.NewRequest(http.MethodPost, "/tasks", bytes.NewReader([]byte("not a json")))
 	req.Header.Set("Content-Type", "application/json")
@@ -167,3 +180,235 @@ func TestHandlerTaskCreateNOK(t *testing.T) {
 	assert.Contains(string(body), "Internal Server Error")
 	assert.NoError(mock.ExpectationsWereMet())
 }
+
+func TestHandlerTaskCreateInvalidFields(t *testing.T) {
+	assert := assert.New(t)
+
+	server := Server{cfg: &Config{MaxTaskTimeout: time.Hour}}
+	payloads := []map[string]interface{}{
+		{"command": "test command", "max_attempts": 0},
+		{"command": "test command", "max_attempts": 1000},
+		{"command": "test command", "backoff": map[string]interface{}{"initial_seconds": 1, "multiplier": 0.5, "max_seconds": 60}},
+		{"command": "test command", "backoff": map[string]interface{}{"initial_seconds": 1, "multiplier": 2}},
+		{"command": "test command", "backoff": map[string]interface{}{"initial_seconds": 1, "multiplier": 2, "max_seconds": 1e9}},
+		{"command": "test command", "timeout_seconds": 0},
+		{"command": "test command", "timeout_seconds": 3601},
+		{"command": "test command", "timeout_seconds": 1e10},
+		{"command": "test command", "env": map[string]string{"NOT-VALID": "x"}},
+		{"command": "test command", "workdir": "relative/dir"},
+		{"command": "test command", "artifacts": []string{"../outside"}},
+		{"command": "test command", "labels": map[string]string{"os": "linux,windows"}},
+	}
+
+	for _, payload := range payloads {
+		payloadBytes, err := json.Marshal(payload)
+		assert.NoError(err)
+
+		req := httptest.NewRequest(http.MethodPost, "/tasks", bytes.NewReader(payloadBytes))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+
+		server.handleCreateTask(w, req)
+
+		resp := w.Result()
+		assert.Equal(http.StatusBadRequest, resp.StatusCode)
+	}
+}
+
+func TestHandlerTaskCreateDependencies(t *testing.T) {
+	assert := assert.New(t)
+
+	// Mock
+	mockDB, mock, err := sqlmock.New()
+	assert.NoError(err)
+	defer func() { _ = mockDB.Close() }()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:                 mockDB,
+		DriverName:           "postgres",
+		PreferSimpleProtocol: true,
+	})
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	assert.NoError(err)
+
+	server := Server{db: db, cfg: &Config{MaxTaskTimeout: time.Hour}}
+	parentsQuery := regexp.QuoteMeta(`SELECT "id","status","exit_code" FROM "task_data" WHERE id IN ($1) FOR SHARE`)
+	insertQuery := regexp.QuoteMeta(`INSERT INTO "task_data"`)
+	insertDependencyQuery := regexp.QuoteMeta(`INSERT INTO "task_dependencies" ("task_id","parent_id","allow_failure")`)
+
+	create := func(payload map[string]interface{}) *http.Response {
+		payloadBytes, err := json.Marshal(payload)
+		assert.NoError(err)
+		req := httptest.NewRequest(http.MethodPost, "/tasks", bytes.NewReader(payloadBytes))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		server.handleCreateTask(w, req)
+		return w.Result()
+	}
+
+	// Parent still running - task is queued
+	parentID := uuid.New()
+	mock.ExpectBegin()
+	mock.ExpectQuery(parentsQuery).
+		WithArgs(parentID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status", "exit_code"}).AddRow(parentID, statusInProgress, nil))
+	mock.ExpectExec(insertQuery).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "webhook_events"`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(insertDependencyQuery).
+		WithArgs(sqlmock.AnyArg(), parentID, false).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	// Waiting agents are not woken up for a task they cannot pick yet
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "task_data" WHERE id = $1 AND (EXISTS (`)).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectCommit()
+
+	resp := create(map[string]interface{}{"command": "deploy", "depends_on": []string{parentID.String()}})
+	assert.Equal(http.StatusCreated, resp.StatusCode)
+	var returnedTask map[string]interface{}
+	assert.NoError(json.NewDecoder(resp.Body).Decode(&returnedTask))
+	assert.Equal("queued", returnedTask["status"])
+	assert.Equal([]interface{}{parentID.String()}, returnedTask["depends_on"])
+
+	// Parent has failed - task is skipped right away
+	mock.ExpectBegin()
+	mock.ExpectQuery(parentsQuery).
+		WithArgs(parentID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status", "exit_code"}).AddRow(parentID, statusFinished, 1))
+	mock.ExpectExec(insertQuery).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "webhook_events"`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(insertDependencyQuery).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	resp = create(map[string]interface{}{"command": "deploy", "depends_on": []string{parentID.String()}})
+	assert.Equal(http.StatusCreated, resp.StatusCode)
+	assert.NoError(json.NewDecoder(resp.Body).Decode(&returnedTask))
+	assert.Equal("skipped", returnedTask["status"])
+
+	// Unknown parent
+	mock.ExpectBegin()
+	mock.ExpectQuery(parentsQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status", "exit_code"}))
+	mock.ExpectRollback()
+
+	resp = create(map[string]interface{}{"command": "deploy", "depends_on": []string{uuid.NewString()}})
+	assert.Equal(http.StatusBadRequest, resp.StatusCode)
+
+	assert.NoError(mock.ExpectationsWereMet())
+}
+
+func TestHandlerTaskCreateIdempotent(t *testing.T) {
+	assert := assert.New(t)
+
+	// Mock
+	mockDB, mock, err := sqlmock.New()
+	assert.NoError(err)
+	defer func() { _ = mockDB.Close() }()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:                 mockDB,
+		DriverName:           "postgres",
+		PreferSimpleProtocol: true,
+	})
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	assert.NoError(err)
+
+	server := Server{db: db, cfg: &Config{IdempotencyKeyTTL: time.Hour}}
+	lookupQuery := regexp.QuoteMeta(`SELECT * FROM "idempotency_keys" WHERE key = $1 AND expires_at > $2`)
+	claimQuery := regexp.QuoteMeta(`INSERT INTO "idempotency_keys"`) + `.*` +
+		regexp.QuoteMeta(`ON CONFLICT ("key") DO UPDATE SET "task_id"="excluded"."task_id"`) + `.*` +
+		regexp.QuoteMeta(`WHERE "idempotency_keys"."expires_at" < `)
+	keyColumns := []string{"key", "task_id", "request_hash", "expires_at"}
+	hash, err := hashTaskCreate(TaskCreate{Command: "deploy"})
+	assert.NoError(err)
+
+	create := func(key, command string) *http.Response {
+		req := httptest.NewRequest(http.MethodPost, "/tasks", bytes.NewReader([]byte(`{"command":"`+command+`"}`)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(idempotencyKeyHeader, key)
+		w := httptest.NewRecorder()
+		server.handleCreateTask(w, req)
+		return w.Result()
+	}
+
+	// First request - the key is saved with the task
+	mock.ExpectQuery(lookupQuery).
+		WithArgs("deploy-1", sqlmock.AnyArg(), 1).
+		WillReturnRows(sqlmock.NewRows(keyColumns))
+	mock.ExpectBegin()
+	mock.ExpectExec(claimQuery).
+		WithArgs("deploy-1", sqlmock.AnyArg(), hash, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "task_data"`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "webhook_events"`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_notify(`)).
+		WithArgs(taskQueuedChannel, defaultQueue).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	resp := create("deploy-1", "deploy")
+	assert.Equal(http.StatusCreated, resp.StatusCode)
+	assert.Empty(resp.Header.Get(idempotentReplayHeader))
+
+	// Retry - the original task is returned
+	taskID := uuid.New()
+	mock.ExpectQuery(lookupQuery).
+		WithArgs("deploy-1", sqlmock.AnyArg(), 1).
+		WillReturnRows(sqlmock.NewRows(keyColumns).AddRow("deploy-1", taskID, hash, time.Now().Add(time.Hour)))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "task_data" WHERE id = $1`)).
+		WithArgs(taskID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "command", "status"}).AddRow(taskID, "deploy", statusInProgress))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "task_dependencies" WHERE task_id IN ($1)`)).
+		WithArgs(taskID).
+		WillReturnRows(sqlmock.NewRows([]string{"task_id", "parent_id"}))
+
+	resp = create("deploy-1", "deploy")
+	assert.Equal(http.StatusCreated, resp.StatusCode)
+	assert.Equal("true", resp.Header.Get(idempotentReplayHeader))
+	var returnedTask Task
+	assert.NoError(json.NewDecoder(resp.Body).Decode(&returnedTask))
+	assert.Equal(taskID, returnedTask.ID)
+	assert.Equal(statusInProgress, returnedTask.Status)
+
+	// Key reused for a different request
+	mock.ExpectQuery(lookupQuery).
+		WithArgs("deploy-1", sqlmock.AnyArg(), 1).
+		WillReturnRows(sqlmock.NewRows(keyColumns).AddRow("deploy-1", taskID, hash, time.Now().Add(time.Hour)))
+
+	resp = create("deploy-1", "rollback")
+	assert.Equal(http.StatusUnprocessableEntity, resp.StatusCode)
+
+	// Concurrent request claimed the key first
+	mock.ExpectQuery(lookupQuery).
+		WithArgs("deploy-2", sqlmock.AnyArg(), 1).
+		WillReturnRows(sqlmock.NewRows(keyColumns))
+	mock.ExpectBegin()
+	mock.ExpectExec(claimQuery).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	mock.ExpectQuery(lookupQuery).
+		WithArgs("deploy-2", sqlmock.AnyArg(), 1).
+		WillReturnRows(sqlmock.NewRows(keyColumns).AddRow("deploy-2", taskID, hash, time.Now().Add(time.Hour)))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "task_data" WHERE id = $1`)).
+		WithArgs(taskID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "command", "status"}).AddRow(taskID, "deploy", statusQueued))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "task_dependencies" WHERE task_id IN ($1)`)).
+		WithArgs(taskID).
+		WillReturnRows(sqlmock.NewRows([]string{"task_id", "parent_id"}))
+
+	resp = create("deploy-2", "deploy")
+	assert.Equal(http.StatusCreated, resp.StatusCode)
+	assert.Equal("true", resp.Header.Get(idempotentReplayHeader))
+
+	// Key too long
+	resp = create(strings.Repeat("k", maxIdempotencyKeyLength+1), "deploy")
+	assert.Equal(http.StatusBadRequest, resp.StatusCode)
+
+	assert.NoError(mock.ExpectationsWereMet())
+}
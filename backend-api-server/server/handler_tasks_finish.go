@@ -2,7 +2,9 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
@@ -12,10 +14,37 @@ import (
 )
 
 type TaskResult struct {
-	Status   string  `json:"status"`
-	Stdout   *string `json:"stdout"`
-	Stderr   *string `json:"stderr"`
-	ExitCode *int    `json:"exit_code"`
+	Status   string    `json:"status"`
+	Stdout   *string   `json:"stdout"`
+	Stderr   *string   `json:"stderr"`
+	ExitCode *int      `json:"exit_code"`
+	LeaseID  uuid.UUID `json:"lease_id"`
+	// Output holds the interleaved lines of both streams. When it is given,
+	// Stdout and Stderr are derived from it.
+	Output []OutputLine `json:"output"`
+}
+
+var errTaskNotInProgress = errors.New("task is not in progress")
+
+// output returns the output of the result, made of the Stdout and Stderr of
+// agents that report no Output.
+func (u *TaskResult) output() []OutputLine {
+	if u.Output != nil {
+		return u.Output
+	}
+	lines := []OutputLine{}
+	now := time.Now()
+	if u.Stdout != nil && *u.Stdout != "" {
+		lines = append(lines, OutputLine{Stream: streamStdout, Data: *u.Stdout, Time: now})
+	}
+	if u.Stderr != nil && *u.Stderr != "" {
+		lines = append(lines, OutputLine{Stream: streamStderr, Data: *u.Stderr, Time: now})
+	}
+	return lines
+}
+
+func (u *TaskResult) failed() bool {
+	return u.Status == statusFailed || u.Status == statusTimedOut || (u.ExitCode != nil && *u.ExitCode != 0)
 }
 
 func (s *Server) handleFinishTask(w http.ResponseWriter, r *http.Request) {
@@ -32,57 +61,111 @@ func (s *Server) handleFinishTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tx := s.db.Begin()
-	if tx.Error != nil {
-		log.Error("failed to start transaction: " + tx.Error.Error())
+	var taskResult TaskResult
+	if err := json.NewDecoder(r.Body).Decode(&taskResult); err != nil {
+		log.Error("failed to decode request body: " + err.Error())
+		http.Error(w, "failed to decode request body", http.StatusBadRequest)
+		return
+	}
+
+	updatedTask, err := s.finishTask(taskID, taskResult)
+	switch {
+	case errors.Is(err, errInvalidStream), errors.Is(err, errLeaseRequired):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, errTaskNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, errTaskNotInProgress), errors.Is(err, errLeaseNotHeld):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		log.Error("failed to finish task: " + err.Error())
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(updatedTask); err != nil {
+		log.Error("failed to encode response: " + err.Error())
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// finishTask stores the result of a task reported by the agent holding its
+// lease, and skips the dependents of a task that did not succeed.
+func (s *Server) finishTask(taskID uuid.UUID, taskResult TaskResult) (Task, error) {
+	if taskResult.LeaseID == uuid.Nil {
+		return Task{}, errLeaseRequired
+	}
+	if err := validateOutput(taskResult.Output); err != nil {
+		return Task{}, err
+	}
+
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		return Task{}, tx.Error
+	}
+
 	var taskData TaskData
-	err = tx.
+	err := tx.
 		Clauses(clause.Locking{Strength: "UPDATE"}).
 		First(&taskData, "id = ?", taskID).Error
 	if err != nil {
 		tx.Rollback()
 		if err == gorm.ErrRecordNotFound {
-			http.Error(w, "task not found", http.StatusNotFound)
-		} else {
-			log.Error("failed to retrieve task: " + err.Error())
-			http.Error(w, "failed to retrieve task", http.StatusInternalServerError)
+			return Task{}, errTaskNotFound
 		}
-		return
+		return Task{}, err
 	}
 
-	var taskResult TaskResult
-	if err := json.NewDecoder(r.Body).Decode(&taskResult); err != nil {
+	if taskData.Status != statusInProgress && taskData.Status != statusCancelling {
 		tx.Rollback()
-		log.Error("failed to decode request body: " + err.Error())
-		http.Error(w, "failed to decode request body", http.StatusBadRequest)
-		return
+		return Task{}, errTaskNotInProgress
+	}
+	if !taskData.leasedTo(taskResult.LeaseID) {
+		tx.Rollback()
+		return Task{}, errLeaseNotHeld
 	}
 
-	taskData.finish(taskResult)
+	output, err := completeLogChunks(tx, &taskData, taskResult.output())
+	if err != nil {
+		tx.Rollback()
+		return Task{}, err
+	}
+	taskResult.Output = output
+	attempt := taskData.finish(taskResult)
+	if err := endAttemptLogs(tx, &taskData, &attempt); err != nil {
+		tx.Rollback()
+		return Task{}, err
+	}
+	if err := tx.Create(&attempt).Error; err != nil {
+		tx.Rollback()
+		return Task{}, err
+	}
 	if err := tx.Save(&taskData).Error; err != nil {
 		tx.Rollback()
-		log.Error("failed to update task: " + err.Error())
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+		return Task{}, err
 	}
-
-	if err := tx.Commit().Error; err != nil {
-		log.Error("failed to commit transaction: " + err.Error())
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+	if err := recordTaskCompletion(tx, &taskData); err != nil {
+		tx.Rollback()
+		return Task{}, err
+	}
+	if err := skipDependents(tx, &taskData); err != nil {
+		tx.Rollback()
+		return Task{}, err
+	}
+	// Finishing frees a slot of the queue and may unblock dependent tasks.
+	if err := notifyTaskDone(tx, &taskData); err != nil {
+		tx.Rollback()
+		return Task{}, err
 	}
-	updatedTask := taskData.toTask()
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	encoder := json.NewEncoder(w)
-	encoder.SetEscapeHTML(false)
-	if err := encoder.Encode(updatedTask); err != nil {
-		log.Error("failed to encode response: " + err.Error())
-		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+	if err := tx.Commit().Error; err != nil {
+		return Task{}, err
 	}
+	return taskData.toTask(), nil
 }
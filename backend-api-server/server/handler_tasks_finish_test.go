@@ -0,0 +1,71 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestHandlerTaskFinishRequiresLease(t *testing.T) {
+	assert := assert.New(t)
+
+	// Mock
+	mockDB, mock, err := sqlmock.New()
+	assert.NoError(err)
+	defer func() { _ = mockDB.Close() }()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:                 mockDB,
+		DriverName:           "postgres",
+		PreferSimpleProtocol: true,
+	})
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	assert.NoError(err)
+
+	server := Server{db: db, cfg: &Config{LeaseDuration: time.Minute}}
+	selectQuery := regexp.QuoteMeta(`SELECT * FROM "task_data" WHERE id = $1`)
+	columns := []string{"id", "command", "date", "status", "attempt", "lease_id"}
+	send := func(handler http.HandlerFunc, path string, id uuid.UUID, body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/tasks/"+id.String()+"/"+path, strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"id": id.String()})
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w.Code
+	}
+
+	// Without lease_id - rejected before the task is looked at
+	taskID := uuid.New()
+	assert.Equal(http.StatusBadRequest, send(server.handleFinishTask, "finish", taskID, `{"status":"finished","exit_code":0}`))
+	assert.Equal(http.StatusBadRequest, send(server.handleHeartbeatTask, "heartbeat", taskID, `{}`))
+
+	// Task was reaped and leased to another agent - the stale lease is refused
+	staleLease, currentLease := uuid.New(), uuid.New()
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectQuery).
+		WithArgs(taskID, 1).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(taskID, "sleep 10", time.Now(), statusInProgress, 2, currentLease))
+	mock.ExpectRollback()
+
+	body := `{"status":"finished","exit_code":0,"lease_id":"` + staleLease.String() + `"}`
+	assert.Equal(http.StatusConflict, send(server.handleFinishTask, "finish", taskID, body))
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectQuery).
+		WithArgs(taskID, 1).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(taskID, "sleep 10", time.Now(), statusInProgress, 2, currentLease))
+	mock.ExpectRollback()
+
+	assert.Equal(http.StatusConflict, send(server.handleHeartbeatTask, "heartbeat", taskID, `{"lease_id":"`+staleLease.String()+`"}`))
+
+	assert.NoError(mock.ExpectationsWereMet())
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/google/uuid"
@@ -25,17 +26,16 @@ func (s *Server) handleGetTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var taskData TaskData
-	if err := s.db.First(&taskData, "id = ?", taskID).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			http.Error(w, "task not found", http.StatusNotFound)
+	task, err := s.getTask(taskID)
+	if err != nil {
+		if errors.Is(err, errTaskNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
 		} else {
 			log.Error("failed to retrieve task: " + err.Error())
 			http.Error(w, "failed to retrieve task", http.StatusInternalServerError)
 		}
 		return
 	}
-	task := taskData.toTask()
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -46,3 +46,21 @@ func (s *Server) handleGetTask(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 	}
 }
+
+func (s *Server) getTask(taskID uuid.UUID) (Task, error) {
+	var taskData TaskData
+	if err := s.db.First(&taskData, "id = ?", taskID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return Task{}, errTaskNotFound
+		}
+		return Task{}, err
+	}
+	tasksData := []TaskData{taskData}
+	if err := loadDependencies(s.db, tasksData); err != nil {
+		return Task{}, err
+	}
+	if err := loadOutput(s.db, tasksData); err != nil {
+		return Task{}, err
+	}
+	return tasksData[0].toTask(), nil
+}
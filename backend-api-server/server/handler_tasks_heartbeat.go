@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	errTaskNotFound  = errors.New("task not found")
+	errLeaseNotHeld  = errors.New("task lease is no longer held")
+	errLeaseRequired = errors.New("lease_id is required")
+)
+
+type TaskHeartbeat struct {
+	LeaseID uuid.UUID `json:"lease_id"`
+}
+
+// TaskLease is returned to the executor agent on every heartbeat. A task in
+// cancelling status tells the agent to stop the command.
+type TaskLease struct {
+	Status         string    `json:"status"`
+	LeaseExpiresAt time.Time `json:"lease_expires_at"`
+}
+
+func (s *Server) handleHeartbeatTask(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	idStr, ok := vars["id"]
+	if !ok {
+		http.Error(w, "id parameter is missing", http.StatusBadRequest)
+		return
+	}
+	log.Debugf("Renewing lease of task %s", idStr)
+	taskID, err := uuid.Parse(idStr)
+	if err != nil {
+		http.Error(w, "invalid id format", http.StatusBadRequest)
+		return
+	}
+
+	var heartbeat TaskHeartbeat
+	if err := json.NewDecoder(r.Body).Decode(&heartbeat); err != nil {
+		log.Error("failed to decode request body: " + err.Error())
+		http.Error(w, "failed to decode request body", http.StatusBadRequest)
+		return
+	}
+
+	lease, err := s.renewTaskLease(taskID, heartbeat.LeaseID)
+	switch {
+	case errors.Is(err, errTaskNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, errLeaseRequired):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, errLeaseNotHeld):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		log.Error("failed to renew task lease: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(lease); err != nil {
+		log.Error("failed to encode response: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// renewTaskLease extends the lease of a running task, it fails with
+// errLeaseNotHeld once the agent has lost the task.
+func (s *Server) renewTaskLease(taskID, leaseID uuid.UUID) (TaskLease, error) {
+	if leaseID == uuid.Nil {
+		return TaskLease{}, errLeaseRequired
+	}
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		return TaskLease{}, tx.Error
+	}
+
+	var taskData TaskData
+	err := tx.
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(&taskData, "id = ?", taskID).Error
+	if err != nil {
+		tx.Rollback()
+		if err == gorm.ErrRecordNotFound {
+			return TaskLease{}, errTaskNotFound
+		}
+		return TaskLease{}, err
+	}
+
+	if !taskData.leasedTo(leaseID) {
+		tx.Rollback()
+		return TaskLease{}, errLeaseNotHeld
+	}
+
+	taskData.renewLease(s.cfg.LeaseDuration)
+	if err := tx.Save(&taskData).Error; err != nil {
+		tx.Rollback()
+		return TaskLease{}, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return TaskLease{}, err
+	}
+	return TaskLease{
+		Status:         taskData.Status,
+		LeaseExpiresAt: *taskData.LeaseExpiresAt,
+	}, nil
+}
@@ -2,27 +2,57 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// handleListTasks returns a page of the tasks matching the filters of the
+// query. The next_cursor of the response is passed as cursor to get the
+// following page, it is null on the last one.
 func (s *Server) handleListTasks(w http.ResponseWriter, r *http.Request) {
 	log.Info("Listing tasks")
-	var tasksData []TaskData
-	if err := s.db.Find(&tasksData).Error; err != nil {
+	query, err := parseTaskQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page, err := s.listTasks(query)
+	if errors.Is(err, errInvalidTaskQuery) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
 		log.Error("failed to retrieve tasks: " + err.Error())
 		http.Error(w, "failed to retrieve tasks", http.StatusInternalServerError)
 		return
 	}
 
-	tasks := make([]Task, len(tasksData))
-	for i, td := range tasksData {
-		tasks[i] = td.toTask()
+	var tasks interface{} = page.Tasks
+	if len(query.Fields) > 0 {
+		projected := make([]map[string]json.RawMessage, len(page.Tasks))
+		for i, task := range page.Tasks {
+			if projected[i], err = projectTask(task, query.Fields); err != nil {
+				log.Error("failed to encode task: " + err.Error())
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+		}
+		tasks = projected
+	}
+	var nextCursor *string
+	if page.NextCursor != "" {
+		nextCursor = &page.NextCursor
 	}
-
 	response := map[string]interface{}{
-		"tasks": tasks,
+		"tasks":       tasks,
+		"next_cursor": nextCursor,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -33,3 +63,120 @@ func (s *Server) handleListTasks(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 	}
 }
+
+// parseTaskQuery reads the filter parameters and the sort, limit, cursor
+// and fields parameters. Fields are given as a comma separated list or
+// repeated parameters.
+func parseTaskQuery(values url.Values) (TaskQuery, error) {
+	filter, err := parseTaskFilter(values)
+	if err != nil {
+		return TaskQuery{}, err
+	}
+	query := TaskQuery{
+		TaskFilter: filter,
+		Sort:       values.Get("sort"),
+		Cursor:     values.Get("cursor"),
+		Fields:     splitList(values["fields"]),
+	}
+	if value := values.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return TaskQuery{}, errors.New("invalid limit")
+		}
+		query.Limit = limit
+	}
+	return query, nil
+}
+
+// parseTaskFilter reads the status, created_after, created_before,
+// exit_code and command parameters. Statuses are given as a comma separated
+// list or repeated parameters.
+func parseTaskFilter(values url.Values) (TaskFilter, error) {
+	filter := TaskFilter{
+		Statuses: splitList(values["status"]),
+		Command:  values.Get("command"),
+	}
+	var err error
+	if filter.CreatedAfter, err = parseTimeParam(values, "created_after"); err != nil {
+		return TaskFilter{}, err
+	}
+	if filter.CreatedBefore, err = parseTimeParam(values, "created_before"); err != nil {
+		return TaskFilter{}, err
+	}
+	if value := values.Get("exit_code"); value != "" {
+		exitCode, err := strconv.Atoi(value)
+		if err != nil {
+			return TaskFilter{}, errors.New("invalid exit_code")
+		}
+		filter.ExitCode = &exitCode
+	}
+	return filter, nil
+}
+
+func parseTimeParam(values url.Values, name string) (*time.Time, error) {
+	value := values.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s, expected an RFC 3339 timestamp", name)
+	}
+	return &t, nil
+}
+
+func splitList(values []string) []string {
+	var list []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+
+// listTasks returns the page of tasks selected by the query. Only the
+// columns of the requested fields are loaded, so that pages without the
+// output of the tasks stay small.
+func (s *Server) listTasks(query TaskQuery) (TaskPage, error) {
+	if err := query.validate(); err != nil {
+		return TaskPage{}, err
+	}
+	db, err := query.apply(s.db)
+	if err != nil {
+		return TaskPage{}, err
+	}
+	if columns := query.columns(); columns != nil {
+		db = db.Select(columns)
+	}
+
+	var tasksData []TaskData
+	if err := db.Limit(query.Limit + 1).Find(&tasksData).Error; err != nil {
+		return TaskPage{}, err
+	}
+	var page TaskPage
+	if len(tasksData) > query.Limit {
+		tasksData = tasksData[:query.Limit]
+		if page.NextCursor, err = query.nextCursor(tasksData[len(tasksData)-1]); err != nil {
+			return TaskPage{}, err
+		}
+	}
+	if query.wantsField("depends_on") {
+		if err := loadDependencies(s.db, tasksData); err != nil {
+			return TaskPage{}, err
+		}
+	}
+	if query.wantsField("stdout") || query.wantsField("stderr") || query.wantsField("output") {
+		if err := loadOutput(s.db, tasksData); err != nil {
+			return TaskPage{}, err
+		}
+	}
+
+	page.Tasks = make([]Task, len(tasksData))
+	for i, td := range tasksData {
+		page.Tasks[i] = td.toTask()
+	}
+	return page, nil
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestHandlerListTasks(t *testing.T) {
+	assert := assert.New(t)
+
+	// Mock
+	mockDB, mock, err := sqlmock.New()
+	assert.NoError(err)
+	defer func() { _ = mockDB.Close() }()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:                 mockDB,
+		DriverName:           "postgres",
+		PreferSimpleProtocol: true,
+	})
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	assert.NoError(err)
+
+	server := Server{db: db}
+
+	type listResponse struct {
+		Tasks      []map[string]json.RawMessage `json:"tasks"`
+		NextCursor *string                      `json:"next_cursor"`
+	}
+	list := func(query string) (*http.Response, listResponse) {
+		req := httptest.NewRequest(http.MethodGet, "/tasks?"+query, nil)
+		w := httptest.NewRecorder()
+		server.handleListTasks(w, req)
+		var response listResponse
+		if w.Code == http.StatusOK {
+			assert.NoError(json.NewDecoder(w.Body).Decode(&response))
+		}
+		return w.Result(), response
+	}
+
+	// All fields - dependencies and the output of completed tasks are loaded
+	firstID, secondID := uuid.New(), uuid.New()
+	date := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "task_data" ORDER BY date ASC,id ASC LIMIT $1`)).
+		WithArgs(defaultTaskPageSize + 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "command", "date", "status", "attempt", "log_from", "log_to"}).
+			AddRow(firstID, "echo hello", date, statusFinished, 2, 3, 5).
+			AddRow(secondID, "echo world", date, statusQueued, 0, 0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "task_dependencies" WHERE task_id IN ($1,$2)`)).
+		WithArgs(firstID, secondID).
+		WillReturnRows(sqlmock.NewRows([]string{"task_id", "parent_id"}).AddRow(secondID, firstID))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "task_log_chunks" WHERE (task_id = $1 AND seq > $2 AND seq <= $3) ORDER BY task_id, seq ASC`)).
+		WithArgs(firstID, 3, 5).
+		WillReturnRows(sqlmock.NewRows([]string{"task_id", "seq", "attempt", "stream", "data", "time"}).
+			AddRow(firstID, 4, 2, streamStdout, "hello\n", date).
+			AddRow(firstID, 5, 2, streamStderr, "done\n", date))
+
+	resp, response := list("")
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	assert.Len(response.Tasks, 2)
+	assert.JSONEq(`"hello\n"`, string(response.Tasks[0]["stdout"]))
+	assert.JSONEq(`"done\n"`, string(response.Tasks[0]["stderr"]))
+	assert.JSONEq(`null`, string(response.Tasks[1]["output"]))
+	assert.JSONEq(`["`+firstID.String()+`"]`, string(response.Tasks[1]["depends_on"]))
+	assert.Nil(response.NextCursor)
+
+	// Filters and fields - only the requested columns are loaded
+	mock.ExpectQuery(regexp.QuoteMeta(
+		`SELECT "id","priority","status" FROM "task_data" WHERE status IN ($1,$2) AND date >= $3 AND exit_code = $4 AND command LIKE $5 ORDER BY priority DESC,id DESC LIMIT $6`)).
+		WithArgs(statusFailed, statusTimedOut, date, 1, `%make\_all%`, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "priority", "status"}).
+			AddRow(firstID, 5, statusFailed).
+			AddRow(secondID, 3, statusTimedOut).
+			AddRow(uuid.New(), 3, statusFailed))
+
+	resp, response = list("status=failed,timed_out&created_after=2024-01-01T00:00:00Z&exit_code=1" +
+		"&command=make_all&sort=-priority&fields=id,status&limit=2")
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	assert.Len(response.Tasks, 2)
+	assert.Equal(map[string]json.RawMessage{
+		"id":     json.RawMessage(`"` + secondID.String() + `"`),
+		"status": json.RawMessage(`"` + statusTimedOut + `"`),
+	}, response.Tasks[1])
+	assert.NotNil(response.NextCursor)
+
+	// Next page - continues after the last task of the previous one
+	mock.ExpectQuery(regexp.QuoteMeta(
+		`SELECT "id","priority","status" FROM "task_data" WHERE status IN ($1,$2) AND (priority, id) < ($3, $4) ORDER BY priority DESC,id DESC LIMIT $5`)).
+		WithArgs(statusFailed, statusTimedOut, 3, secondID, 101).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "priority", "status"}))
+
+	resp, response = list("status=failed&status=timed_out&sort=-priority&fields=id,status&cursor=" + *response.NextCursor)
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	assert.Empty(response.Tasks)
+	assert.Nil(response.NextCursor)
+
+	// Invalid queries
+	cursor, err := (&TaskQuery{Sort: "date"}).nextCursor(TaskData{ID: firstID, Date: date})
+	assert.NoError(err)
+	for _, query := range []string{
+		"sort=status",
+		"fields=id,lease_id",
+		"status=done",
+		"limit=0",
+		"limit=1001",
+		"exit_code=one",
+		"created_before=yesterday",
+		"cursor=garbage",
+		"sort=-date&cursor=" + cursor,
+	} {
+		resp, _ = list(query)
+		assert.Equal(http.StatusBadRequest, resp.StatusCode, query)
+	}
+
+	assert.NoError(mock.ExpectationsWereMet())
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// handleGetTaskLogs returns the output chunks of a task after the offset
+// given in the query. With follow=true the chunks are streamed as
+// Server-Sent Events until the task completes; reconnecting clients resume
+// from the Last-Event-ID header.
+func (s *Server) handleGetTaskLogs(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	idStr, ok := vars["id"]
+	if !ok {
+		http.Error(w, "id parameter is missing", http.StatusBadRequest)
+		return
+	}
+	log.Infof("Getting logs of task with id %s", idStr)
+
+	taskID, err := uuid.Parse(idStr)
+	if err != nil {
+		http.Error(w, "invalid id format", http.StatusBadRequest)
+		return
+	}
+
+	offset := 0
+	offsetStr := r.URL.Query().Get("offset")
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+		offsetStr = lastEventID
+	}
+	if offsetStr != "" {
+		offset, err = strconv.Atoi(offsetStr)
+		if err != nil || offset < 0 {
+			http.Error(w, "invalid offset", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var taskData TaskData
+	if err := s.db.Select("id", "status").First(&taskData, "id = ?", taskID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "task not found", http.StatusNotFound)
+		} else {
+			log.Error("failed to retrieve task: " + err.Error())
+			http.Error(w, "failed to retrieve task", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if r.URL.Query().Get("follow") == "true" {
+		s.followTaskLogs(w, r, taskID, offset)
+		return
+	}
+
+	chunks, err := logChunksAfter(s.db, taskID, offset)
+	if err != nil {
+		log.Error("failed to retrieve task logs: " + err.Error())
+		http.Error(w, "failed to retrieve task logs", http.StatusInternalServerError)
+		return
+	}
+	if len(chunks) > 0 {
+		offset = chunks[len(chunks)-1].Seq
+	}
+
+	response := map[string]interface{}{
+		"chunks":      chunks,
+		"next_offset": offset,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(response); err != nil {
+		log.Error("failed to encode response: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+func (s *Server) followTaskLogs(w http.ResponseWriter, r *http.Request, taskID uuid.UUID, offset int) {
+	// The stream lasts as long as the task runs, longer than the write
+	// timeout of the server.
+	controller := http.NewResponseController(w)
+	_ = controller.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	_ = controller.Flush()
+
+	taskData, err := s.followLogs(r.Context(), taskID, offset, func(chunks []TaskLogChunk) error {
+		for _, chunk := range chunks {
+			data, err := json.Marshal(chunk)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", chunk.Seq, chunk.Stream, data); err != nil {
+				return err
+			}
+		}
+		return controller.Flush()
+	})
+	if err != nil {
+		// Errors of clients that went away are not worth logging.
+		if r.Context().Err() == nil {
+			log.Error("failed to follow task logs: " + err.Error())
+		}
+		return
+	}
+	fmt.Fprintf(w, "event: end\ndata: {\"status\":%q}\n\n", taskData.Status)
+	_ = controller.Flush()
+}
+
+// followLogs passes the output chunks of a task after the offset to emit,
+// until the task has completed and all of its chunks are emitted. It looks
+// for new chunks when notified of them, or every LogFollowInterval in case a
+// notification was missed. It returns the completed task.
+func (s *Server) followLogs(ctx context.Context, taskID uuid.UUID, offset int, emit func([]TaskLogChunk) error) (TaskData, error) {
+	ticker := time.NewTicker(s.cfg.LogFollowInterval)
+	defer ticker.Stop()
+
+	for {
+		// Waiting starts before the logs are read so that no notification
+		// can be missed.
+		woken, stop := s.notifier.waitLogs(taskID)
+		taskData, count, err := s.emitLogsAfter(taskID, &offset, emit)
+		if err != nil || (count == 0 && taskData.completed()) {
+			stop()
+			return taskData, err
+		}
+		if count == logChunksPageSize {
+			stop()
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			stop()
+			return TaskData{}, ctx.Err()
+		case <-woken:
+		case <-ticker.C:
+		}
+		stop()
+	}
+}
+
+// emitLogsAfter passes the next page of chunks after the offset to emit and
+// moves the offset to the last of them. It returns the task and the number
+// of emitted chunks.
+func (s *Server) emitLogsAfter(taskID uuid.UUID, offset *int, emit func([]TaskLogChunk) error) (TaskData, int, error) {
+	// The status is read before the chunks, so that no chunk written
+	// before the task completed can be missed.
+	var taskData TaskData
+	if err := s.db.Select("id", "status").First(&taskData, "id = ?", taskID).Error; err != nil {
+		return TaskData{}, 0, err
+	}
+	chunks, err := logChunksAfter(s.db, taskID, *offset)
+	if err != nil {
+		return TaskData{}, 0, err
+	}
+	if len(chunks) == 0 {
+		return taskData, 0, nil
+	}
+	if err := emit(chunks); err != nil {
+		return TaskData{}, 0, err
+	}
+	*offset = chunks[len(chunks)-1].Seq
+	return taskData, len(chunks), nil
+}
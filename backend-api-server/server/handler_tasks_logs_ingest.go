@@ -0,0 +1,94 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TaskLogs is a batch of output chunks streamed by the executor agent holding
+// the lease of the task.
+type TaskLogs struct {
+	LeaseID uuid.UUID      `json:"lease_id"`
+	Chunks  []TaskLogChunk `json:"chunks"`
+}
+
+func (s *Server) handleIngestTaskLogs(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	idStr, ok := vars["id"]
+	if !ok {
+		http.Error(w, "id parameter is missing", http.StatusBadRequest)
+		return
+	}
+	log.Debugf("Receiving logs of task %s", idStr)
+	taskID, err := uuid.Parse(idStr)
+	if err != nil {
+		http.Error(w, "invalid id format", http.StatusBadRequest)
+		return
+	}
+
+	var taskLogs TaskLogs
+	if err := json.NewDecoder(r.Body).Decode(&taskLogs); err != nil {
+		log.Error("failed to decode request body: " + err.Error())
+		http.Error(w, "failed to decode request body", http.StatusBadRequest)
+		return
+	}
+	if taskLogs.LeaseID == uuid.Nil {
+		http.Error(w, errLeaseRequired.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, chunk := range taskLogs.Chunks {
+		if err := validateOutput([]OutputLine{chunk.OutputLine}); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		log.Error("failed to start transaction: " + tx.Error.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	var taskData TaskData
+	err = tx.
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(&taskData, "id = ?", taskID).Error
+	if err != nil {
+		tx.Rollback()
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "task not found", http.StatusNotFound)
+		} else {
+			log.Error("failed to retrieve task: " + err.Error())
+			http.Error(w, "failed to retrieve task", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if !taskData.leasedTo(taskLogs.LeaseID) {
+		tx.Rollback()
+		http.Error(w, "task lease is no longer held", http.StatusConflict)
+		return
+	}
+
+	if err := appendLogChunks(tx, &taskData, taskLogs.Chunks); err != nil {
+		tx.Rollback()
+		log.Error("failed to save task logs: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		log.Error("failed to commit transaction: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
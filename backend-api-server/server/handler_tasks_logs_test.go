@@ -0,0 +1,179 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestHandlerTaskLogsIngest(t *testing.T) {
+	assert := assert.New(t)
+
+	// Mock
+	mockDB, mock, err := sqlmock.New()
+	assert.NoError(err)
+	defer func() { _ = mockDB.Close() }()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:                 mockDB,
+		DriverName:           "postgres",
+		PreferSimpleProtocol: true,
+	})
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	assert.NoError(err)
+
+	server := Server{db: db}
+	selectQuery := regexp.QuoteMeta(`SELECT * FROM "task_data" WHERE id = $1`)
+	lastSeqQuery := regexp.QuoteMeta(`SELECT COALESCE(MAX(seq), 0) FROM "task_log_chunks" WHERE task_id = $1`)
+	insertQuery := regexp.QuoteMeta(`INSERT INTO "task_log_chunks"`)
+	columns := []string{"id", "command", "date", "status", "attempt", "lease_id"}
+
+	ingest := func(id uuid.UUID, body string) *http.Response {
+		req := httptest.NewRequest(http.MethodPost, "/tasks/"+id.String()+"/logs", strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"id": id.String()})
+		w := httptest.NewRecorder()
+		server.handleIngestTaskLogs(w, req)
+		return w.Result()
+	}
+
+	// Chunks are appended after the last stored one
+	taskID := uuid.New()
+	leaseID := uuid.New()
+	body := `{"lease_id":"` + leaseID.String() + `","chunks":[` +
+		`{"stream":"stdout","data":"hello\n","time":"2024-01-01T00:00:00Z"},` +
+		`{"stream":"stderr","data":"oops\n","time":"2024-01-01T00:00:01Z"}]}`
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectQuery).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(taskID, "echo hello", time.Now(), statusInProgress, 2, leaseID))
+	mock.ExpectQuery(lastSeqQuery).
+		WithArgs(taskID).
+		WillReturnRows(sqlmock.NewRows([]string{"coalesce"}).AddRow(7))
+	mock.ExpectExec(insertQuery).
+		WithArgs(
+			taskID, 8, 2, streamStdout, "hello\n", sqlmock.AnyArg(),
+			taskID, 9, 2, streamStderr, "oops\n", sqlmock.AnyArg(),
+		).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_notify($1, $2)`)).
+		WithArgs(taskLogsChannel, taskID.String()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	resp := ingest(taskID, body)
+	assert.Equal(http.StatusNoContent, resp.StatusCode)
+
+	// Stale lease - logs are rejected
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectQuery).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(taskID, "echo hello", time.Now(), statusInProgress, 2, uuid.New()))
+	mock.ExpectRollback()
+
+	resp = ingest(taskID, body)
+	assert.Equal(http.StatusConflict, resp.StatusCode)
+
+	// Unknown stream
+	resp = ingest(taskID, `{"lease_id":"`+leaseID.String()+`","chunks":[{"stream":"stdin","data":"x"}]}`)
+	assert.Equal(http.StatusBadRequest, resp.StatusCode)
+
+	assert.NoError(mock.ExpectationsWereMet())
+}
+
+func TestHandlerTaskLogsFollow(t *testing.T) {
+	assert := assert.New(t)
+
+	// Mock
+	mockDB, mock, err := sqlmock.New()
+	assert.NoError(err)
+	defer func() { _ = mockDB.Close() }()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:                 mockDB,
+		DriverName:           "postgres",
+		PreferSimpleProtocol: true,
+	})
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	assert.NoError(err)
+
+	server := Server{db: db, cfg: &Config{LogFollowInterval: time.Millisecond}}
+	statusQuery := regexp.QuoteMeta(`SELECT "id","status" FROM "task_data" WHERE id = $1`)
+	chunksQuery := regexp.QuoteMeta(`SELECT * FROM "task_log_chunks" WHERE task_id = $1 AND seq > $2 ORDER BY seq ASC LIMIT $3`)
+	chunkColumns := []string{"task_id", "seq", "attempt", "stream", "data", "time"}
+	taskID := uuid.New()
+
+	// Resumed from Last-Event-ID, streamed until the task has finished
+	mock.ExpectQuery(statusQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(taskID, statusInProgress))
+	mock.ExpectQuery(statusQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(taskID, statusInProgress))
+	mock.ExpectQuery(chunksQuery).
+		WithArgs(taskID, 3, logChunksPageSize).
+		WillReturnRows(sqlmock.NewRows(chunkColumns).
+			AddRow(taskID, 4, 1, streamStdout, "one\n", time.Now()))
+	mock.ExpectQuery(statusQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(taskID, statusFinished))
+	mock.ExpectQuery(chunksQuery).
+		WithArgs(taskID, 4, logChunksPageSize).
+		WillReturnRows(sqlmock.NewRows(chunkColumns).
+			AddRow(taskID, 5, 1, streamStderr, "two\n", time.Now()))
+	mock.ExpectQuery(statusQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(taskID, statusFinished))
+	mock.ExpectQuery(chunksQuery).
+		WithArgs(taskID, 5, logChunksPageSize).
+		WillReturnRows(sqlmock.NewRows(chunkColumns))
+
+	req := httptest.NewRequest(http.MethodGet, "/tasks/"+taskID.String()+"/logs?follow=true", nil)
+	req.Header.Set("Last-Event-ID", "3")
+	req = mux.SetURLVars(req, map[string]string{"id": taskID.String()})
+	w := httptest.NewRecorder()
+	server.handleGetTaskLogs(w, req)
+
+	resp := w.Result()
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	assert.Equal("text/event-stream", resp.Header.Get("Content-Type"))
+	body, _ := io.ReadAll(resp.Body)
+	events := strings.Split(strings.TrimSpace(string(body)), "\n\n")
+	assert.Len(events, 3)
+	assert.True(strings.HasPrefix(events[0], "id: 4\nevent: stdout\ndata: {"))
+	assert.True(strings.HasPrefix(events[1], "id: 5\nevent: stderr\ndata: {"))
+	assert.Equal(`event: end`+"\n"+`data: {"status":"finished"}`, events[2])
+
+	// Woken up by the notification of new chunks instead of polling
+	server.cfg.LogFollowInterval = time.Hour
+	server.notifier = newTaskNotifier()
+	mock.ExpectQuery(statusQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(taskID, statusInProgress))
+	mock.ExpectQuery(chunksQuery).
+		WithArgs(taskID, 0, logChunksPageSize).
+		WillReturnRows(sqlmock.NewRows(chunkColumns).
+			AddRow(taskID, 1, 1, streamStdout, "one\n", time.Now()))
+	mock.ExpectQuery(statusQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(taskID, statusFinished))
+	mock.ExpectQuery(chunksQuery).
+		WithArgs(taskID, 1, logChunksPageSize).
+		WillReturnRows(sqlmock.NewRows(chunkColumns))
+
+	var emitted []TaskLogChunk
+	taskData, err := server.followLogs(context.Background(), taskID, 0, func(chunks []TaskLogChunk) error {
+		emitted = append(emitted, chunks...)
+		server.notifier.broadcast(taskLogsChannel, taskID.String())
+		return nil
+	})
+	assert.NoError(err)
+	assert.Equal(statusFinished, taskData.Status)
+	assert.Len(emitted, 1)
+	assert.Empty(server.notifier.waiters)
+
+	assert.NoError(mock.ExpectationsWereMet())
+}
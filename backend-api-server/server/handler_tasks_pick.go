@@ -1,59 +1,379 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"net/url"
 	"time"
 
+	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
 
+// PickedTask is the task handed over to an executor agent. The agent has to
+// present the lease when sending heartbeats and the result of the task.
+type PickedTask struct {
+	Task
+	LeaseID        uuid.UUID `json:"lease_id"`
+	LeaseExpiresAt time.Time `json:"lease_expires_at"`
+	// TimeoutSeconds overrides the one of the task with the effective
+	// timeout the agent has to enforce.
+	TimeoutSeconds int `json:"timeout_seconds"`
+	// SecretEnv holds the resolved values of the secrets of the task. It is
+	// only ever sent to the agent that picked the task.
+	SecretEnv map[string]string `json:"secret_env"`
+}
+
+// pickRequest describes the agent picking a task.
+type pickRequest struct {
+	AgentID string
+	// Labels are matched against the label selectors of tasks.
+	Labels map[string]string
+	// Queues are the queues the agent takes tasks from.
+	Queues []string
+}
+
+var errNoQueuedTask = errors.New("failed to find queued task")
+
+// parsePickRequest reads the agent_id, label and queue parameters of an agent.
+func parsePickRequest(query url.Values) (pickRequest, error) {
+	req := pickRequest{AgentID: query.Get("agent_id"), Queues: query["queue"]}
+	if req.AgentID != "" && !agentIDPattern.MatchString(req.AgentID) {
+		return pickRequest{}, errInvalidAgentID
+	}
+	if len(req.Queues) == 0 {
+		req.Queues = []string{defaultQueue}
+	}
+	labels, err := parseLabels(query["label"])
+	if err != nil {
+		return pickRequest{}, err
+	}
+	req.Labels = labels
+	return req, nil
+}
+
+// handlePickTask leases the next runnable task to the agent. Agents that
+// identify themselves with agent_id must have registered first. Agents send
+// their labels as label=key=value query parameters, only tasks whose label
+// selector they satisfy are handed to them. The queue parameters name the
+// queues the agent takes tasks from, the default queue if none are given.
+// With wait set, the request is held until a task is available or the wait
+// duration, capped by MaxPickWait, has passed.
 func (s *Server) handlePickTask(w http.ResponseWriter, r *http.Request) {
 	log.Debug("Executor tries picking a queued task")
+	query := r.URL.Query()
+	req, err := parsePickRequest(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var wait time.Duration
+	if value := query.Get("wait"); value != "" {
+		wait, err = time.ParseDuration(value)
+		if err != nil || wait < 0 {
+			http.Error(w, "invalid wait duration", http.StatusBadRequest)
+			return
+		}
+		wait = min(wait, s.cfg.MaxPickWait)
+		_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(wait + serverTimeout))
+	}
+	if req.AgentID != "" {
+		if err := checkAgent(s.db, req.AgentID); errors.Is(err, errUnknownAgent) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		} else if err != nil {
+			log.Error("failed to retrieve agent: " + err.Error())
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	task, err := s.awaitTask(r.Context(), req, time.Now().Add(wait))
+	if errors.Is(err, errNoQueuedTask) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error("failed to pick task: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if r.Context().Err() != nil {
+		log.Warnf("Agent went away before getting task %s", task.ID)
+		s.releaseLease(task)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	err = encoder.Encode(task)
+	if err == nil {
+		err = http.NewResponseController(w).Flush()
+	}
+	if err != nil {
+		log.Errorf("failed to send task %s to the agent: %v", task.ID, err)
+		s.releaseLease(task)
+	}
+}
+
+// releaseLease takes back a task whose lease never reached the agent, e.g.
+// because the agent disconnected in the meantime. The task is queued again
+// without counting the attempt, or cancelled if it was aborted meanwhile.
+func (s *Server) releaseLease(task *PickedTask) {
 	tx := s.db.Begin()
 	if tx.Error != nil {
-		log.Error("failed to start transaction: " + tx.Error.Error())
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		log.Error("failed to begin transaction: " + tx.Error.Error())
 		return
 	}
 
 	var taskData TaskData
 	err := tx.
-		Where("status = ?", "queued").
-		Order("date ASC").
 		Clauses(clause.Locking{Strength: "UPDATE"}).
-		First(&taskData).Error
+		First(&taskData, "id = ?", task.ID).Error
 	if err != nil {
 		tx.Rollback()
-		http.Error(w, "failed to find queued task", http.StatusNotFound)
+		log.Error("failed to retrieve task: " + err.Error())
+		return
+	}
+	if !taskData.leasedTo(task.LeaseID) {
+		tx.Rollback()
 		return
 	}
 
-	taskData.Status = statusInProgress
-	now := time.Now()
-	taskData.StartedAt = &now
-
+	taskData.releaseLease()
 	if err := tx.Save(&taskData).Error; err != nil {
 		tx.Rollback()
 		log.Error("failed to update task: " + err.Error())
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
-
+	event := eventTaskRequeued
+	if taskData.completed() {
+		event = eventTaskCancelled
+	}
+	if err := recordTaskEvent(tx, event, &taskData); err != nil {
+		tx.Rollback()
+		log.Error("failed to record task event: " + err.Error())
+		return
+	}
+	if err := skipDependents(tx, &taskData); err != nil {
+		tx.Rollback()
+		log.Error("failed to skip dependent tasks: " + err.Error())
+		return
+	}
+	if err := notifyTaskDone(tx, &taskData); err != nil {
+		tx.Rollback()
+		log.Error("failed to notify queued task: " + err.Error())
+		return
+	}
 	if err := tx.Commit().Error; err != nil {
 		log.Error("failed to commit transaction: " + err.Error())
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
-	task := taskData.toTask()
+	log.Infof("Released lease of task %s", task.ID)
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	encoder := json.NewEncoder(w)
-	encoder.SetEscapeHTML(false)
-	if err := encoder.Encode(task); err != nil {
-		log.Error("failed to encode response: " + err.Error())
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+// awaitTask picks a task for the agent. While there is none, it waits for
+// tasks to be queued until the deadline, or as long as ctx lasts if the
+// deadline is zero.
+func (s *Server) awaitTask(ctx context.Context, req pickRequest, deadline time.Time) (*PickedTask, error) {
+	for {
+		woken, stop := s.notifier.wait(req.Queues)
+		task, err := s.pickTask(req)
+		if !errors.Is(err, errNoQueuedTask) {
+			stop()
+			return task, err
+		}
+		again := s.waitForTask(ctx, woken, deadline)
+		stop()
+		if !again {
+			return nil, err
+		}
+	}
+}
+
+// waitForTask blocks until woken is closed, the next PickPollInterval has
+// passed or the deadline is reached. It reports whether to look for a task
+// again.
+func (s *Server) waitForTask(ctx context.Context, woken <-chan struct{}, deadline time.Time) bool {
+	timeout := s.cfg.PickPollInterval
+	if !deadline.IsZero() {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return false
+		}
+		if timeout <= 0 || remaining < timeout {
+			timeout = remaining
+		}
+	}
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-woken:
+		return true
+	case <-expired:
+		return true
+	}
+}
+
+// pickTask leases the next runnable task to the agent, it returns
+// errNoQueuedTask if there is none.
+func (s *Server) pickTask(req pickRequest) (*PickedTask, error) {
+	labelsJSON, err := json.Marshal(req.Labels)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		task, err := s.pickNextTask(req, labelsJSON)
+		// The task has been failed instead, the next one may be runnable.
+		if errors.Is(err, errSecretUnavailable) {
+			continue
+		}
+		return task, err
+	}
+}
+
+// pickNextTask leases the first runnable task to the agent. A task whose
+// secrets cannot be resolved is failed instead, with errSecretUnavailable.
+func (s *Server) pickNextTask(req pickRequest, labelsJSON []byte) (*PickedTask, error) {
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	now := time.Now()
+	var taskData TaskData
+	err := tx.
+		Where("status = ?", statusQueued).
+		Where("not_before IS NULL OR not_before <= ?", now).
+		Where("run_at IS NULL OR run_at <= ?", now).
+		Where("NOT "+pendingDependencies).
+		Where(labelsSatisfied, string(labelsJSON)).
+		Where("queue IN ?", req.Queues).
+		Where(queueAvailable).
+		// Matches idx_task_data_pick, see TaskData.rank.
+		Order("pick_rank DESC, date ASC").
+		// Tasks locked by concurrent picks are passed over instead of
+		// waiting for those picks to commit.
+		Clauses(clause.Locking{Strength: "UPDATE", Options: clause.LockingOptionsSkipLocked}).
+		Take(&taskData).Error
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errNoQueuedTask
+		}
+		return nil, err
+	}
+
+	if err := reserveQueueSlot(tx, taskData.Queue); err != nil {
+		tx.Rollback()
+		if errors.Is(err, errQueueUnavailable) {
+			return nil, errNoQueuedTask
+		}
+		return nil, err
+	}
+
+	secretEnv, err := s.resolveSecrets(tx, &taskData)
+	if errors.Is(err, errSecretUnavailable) {
+		// The task cannot run without its secrets, it is failed instead of
+		// being handed out over and over again.
+		log.Warnf("Failing task %s: %v", taskData.ID, err)
+		if failErr := failUnpickableTask(tx, &taskData, err.Error()); failErr != nil {
+			return nil, failErr
+		}
+		return nil, err
+	}
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	taskData.lease(s.cfg.LeaseDuration)
+	taskData.AgentID = nil
+	if req.AgentID != "" {
+		taskData.AgentID = &req.AgentID
+	}
+
+	if err := tx.Save(&taskData).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := recordTaskEvent(tx, eventTaskPicked, &taskData); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return &PickedTask{
+		Task:           taskData.toTask(),
+		LeaseID:        *taskData.LeaseID,
+		LeaseExpiresAt: *taskData.LeaseExpiresAt,
+		TimeoutSeconds: s.taskTimeoutSeconds(&taskData),
+		SecretEnv:      secretEnv,
+	}, nil
+}
+
+// failUnpickableTask fails a queued task that cannot be handed out to agents
+// and commits the transaction.
+func failUnpickableTask(tx *gorm.DB, taskData *TaskData, reason string) error {
+	taskData.fail(reason)
+	if err := tx.Save(taskData).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := recordTaskCompletion(tx, taskData); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := skipDependents(tx, taskData); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := notifyTaskDone(tx, taskData); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// rankQueuedTasks ranks the queued tasks again with the current
+// PriorityAgingInterval, which may have changed since they were created.
+func (s *Server) rankQueuedTasks() error {
+	rank := gorm.Expr("priority")
+	if seconds := int64(s.cfg.PriorityAgingInterval / time.Second); seconds > 0 {
+		rank = gorm.Expr("priority * ? - FLOOR(EXTRACT(EPOCH FROM date))", seconds)
+	}
+	return s.db.Model(&TaskData{}).
+		Where("status = ?", statusQueued).
+		Update("pick_rank", rank).Error
+}
+
+// taskTimeoutSeconds returns the timeout of the task, falling back to the
+// default one and capped by the maximal one.
+func (s *Server) taskTimeoutSeconds(taskData *TaskData) int {
+	seconds := int(s.cfg.DefaultTaskTimeout / time.Second)
+	if taskData.TimeoutSeconds != nil {
+		seconds = *taskData.TimeoutSeconds
+	}
+	// Compared in seconds, converting a huge timeout to a time.Duration
+	// would overflow and skip the cap.
+	if maxSeconds := int(s.cfg.MaxTaskTimeout / time.Second); maxSeconds > 0 && seconds > maxSeconds {
+		seconds = maxSeconds
 	}
+	return seconds
 }
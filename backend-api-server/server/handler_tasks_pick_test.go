@@ -0,0 +1,309 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestHandlerTaskPick(t *testing.T) {
+	assert := assert.New(t)
+
+	// Mock
+	mockDB, mock, err := sqlmock.New()
+	assert.NoError(err)
+	defer func() { _ = mockDB.Close() }()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:                 mockDB,
+		DriverName:           "postgres",
+		PreferSimpleProtocol: true,
+	})
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	assert.NoError(err)
+
+	server := Server{db: db, cfg: &Config{
+		LeaseDuration:         time.Minute,
+		PriorityAgingInterval: time.Minute,
+		DefaultTaskTimeout:    time.Hour,
+		MaxTaskTimeout:        2 * time.Hour,
+		MaxPickWait:           time.Minute,
+	}, notifier: newTaskNotifier()}
+	selectQuery := `(?s)` + regexp.QuoteMeta(`SELECT * FROM "task_data" WHERE status = $1 AND (not_before IS NULL OR not_before <= $2) AND (run_at IS NULL OR run_at <= $3) AND (NOT EXISTS (`) +
+		`.*` + regexp.QuoteMeta(`AND COALESCE(labels, '{}'::jsonb) <@ CAST($4 AS jsonb) AND queue IN ($5) AND (NOT EXISTS (`) +
+		`.*` + regexp.QuoteMeta(`ORDER BY pick_rank DESC, date ASC LIMIT $6 FOR UPDATE SKIP LOCKED`)
+	queueQuery := regexp.QuoteMeta(`SELECT * FROM "queue_data" WHERE name = $1 LIMIT $2 FOR UPDATE`)
+	countQuery := regexp.QuoteMeta(`SELECT count(*) FROM "task_data" WHERE queue = $1 AND status IN ($2,$3)`)
+
+	// Pick queued task
+	taskID := uuid.New()
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "command", "date", "status", "priority", "max_attempts", "queue"}).
+			AddRow(taskID, "echo hello", time.Now(), statusQueued, 10, 1, defaultQueue))
+	mock.ExpectQuery(queueQuery).
+		WithArgs(defaultQueue, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "paused", "max_in_flight"}))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "task_data" SET`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "webhook_events"`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_notify($1, $2)`)).
+		WithArgs(taskLogsChannel, taskID.String()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	req := httptest.NewRequest(http.MethodGet, "/tasks/pick", nil)
+	w := httptest.NewRecorder()
+	server.handlePickTask(w, req)
+
+	resp := w.Result()
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	var picked map[string]interface{}
+	assert.NoError(json.NewDecoder(resp.Body).Decode(&picked))
+	assert.Equal(taskID.String(), picked["id"])
+	assert.Equal(statusInProgress, picked["status"])
+	assert.Equal(float64(10), picked["priority"])
+	assert.Equal(float64(1), picked["attempt"])
+	assert.NotEmpty(picked["lease_id"])
+	assert.NotEmpty(picked["started_at"])
+	assert.Equal(float64(3600), picked["timeout_seconds"])
+
+	// Nothing to pick
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectQuery).
+		WillReturnError(gorm.ErrRecordNotFound)
+	mock.ExpectRollback()
+
+	w = httptest.NewRecorder()
+	server.handlePickTask(w, req)
+	assert.Equal(http.StatusNotFound, w.Result().StatusCode)
+
+	// Agent labels are matched against the label selectors of tasks
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectQuery).
+		WithArgs(statusQueued, sqlmock.AnyArg(), sqlmock.AnyArg(), `{"os":"linux","tool":"terraform"}`, defaultQueue, 1).
+		WillReturnError(gorm.ErrRecordNotFound)
+	mock.ExpectRollback()
+
+	w = httptest.NewRecorder()
+	server.handlePickTask(w, httptest.NewRequest(http.MethodGet, "/tasks/pick?label=os=linux&label=tool=terraform", nil))
+	assert.Equal(http.StatusNotFound, w.Result().StatusCode)
+
+	// Queue filled up by a concurrent pick
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "command", "date", "status", "queue"}).
+			AddRow(uuid.New(), "deploy", time.Now(), statusQueued, "deploy"))
+	mock.ExpectQuery(queueQuery).
+		WithArgs("deploy", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "paused", "max_in_flight"}).AddRow("deploy", false, 2))
+	mock.ExpectQuery(countQuery).
+		WithArgs("deploy", statusInProgress, statusCancelling).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectRollback()
+
+	w = httptest.NewRecorder()
+	server.handlePickTask(w, httptest.NewRequest(http.MethodGet, "/tasks/pick?queue=deploy", nil))
+	assert.Equal(http.StatusNotFound, w.Result().StatusCode)
+
+	// Waiting pick is woken up by a queued task
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectQuery).
+		WillReturnError(gorm.ErrRecordNotFound)
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "command", "date", "status", "queue"}).
+			AddRow(taskID, "echo hello", time.Now(), statusQueued, defaultQueue))
+	mock.ExpectQuery(queueQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "paused", "max_in_flight"}))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "task_data" SET`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "webhook_events"`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_notify($1, $2)`)).
+		WithArgs(taskLogsChannel, taskID.String()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(10 * time.Millisecond):
+				server.notifier.broadcast(taskQueuedChannel, defaultQueue)
+			}
+		}
+	}()
+	w = httptest.NewRecorder()
+	server.handlePickTask(w, httptest.NewRequest(http.MethodGet, "/tasks/pick?wait=30s", nil))
+	close(done)
+	assert.Equal(http.StatusOK, w.Result().StatusCode)
+
+	// Wait expires without a task
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectQuery).
+		WillReturnError(gorm.ErrRecordNotFound)
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectQuery).
+		WillReturnError(gorm.ErrRecordNotFound)
+	mock.ExpectRollback()
+
+	w = httptest.NewRecorder()
+	server.handlePickTask(w, httptest.NewRequest(http.MethodGet, "/tasks/pick?wait=50ms", nil))
+	assert.Equal(http.StatusNotFound, w.Result().StatusCode)
+
+	// Malformed wait duration
+	w = httptest.NewRecorder()
+	server.handlePickTask(w, httptest.NewRequest(http.MethodGet, "/tasks/pick?wait=soon", nil))
+	assert.Equal(http.StatusBadRequest, w.Result().StatusCode)
+
+	// Task whose secret was deleted is failed, the next one is picked
+	unpickableID := uuid.New()
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "command", "date", "status", "queue", "secrets"}).
+			AddRow(unpickableID, "deploy", time.Now(), statusQueued, defaultQueue, `{"TOKEN":"deploy-token"}`))
+	mock.ExpectQuery(queueQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "paused", "max_in_flight"}))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "secret_data" WHERE name IN ($1)`)).
+		WithArgs("deploy-token").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "nonce", "ciphertext"}))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "task_data" SET`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "webhook_events"`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_notify($1, $2)`)).
+		WithArgs(taskLogsChannel, unpickableID.String()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "task_data" WHERE status = $1 AND (id IN (SELECT task_id FROM task_dependencies`)).
+		WithArgs(statusQueued, unpickableID).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM "queue_data" WHERE name = $1 AND max_in_flight IS NOT NULL`)).
+		WithArgs(defaultQueue).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT DISTINCT "queue" FROM "task_data" WHERE status = $1 AND id IN (SELECT task_id FROM task_dependencies WHERE parent_id = $2)`)).
+		WithArgs(statusQueued, unpickableID).
+		WillReturnRows(sqlmock.NewRows([]string{"queue"}))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "command", "date", "status", "queue"}).
+			AddRow(taskID, "echo hello", time.Now(), statusQueued, defaultQueue))
+	mock.ExpectQuery(queueQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "paused", "max_in_flight"}))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "task_data" SET`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "webhook_events"`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_notify($1, $2)`)).
+		WithArgs(taskLogsChannel, taskID.String()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w = httptest.NewRecorder()
+	server.handlePickTask(w, httptest.NewRequest(http.MethodGet, "/tasks/pick", nil))
+	assert.Equal(http.StatusOK, w.Result().StatusCode)
+	picked = nil
+	assert.NoError(json.NewDecoder(w.Result().Body).Decode(&picked))
+	assert.Equal(taskID.String(), picked["id"])
+
+	// Agent that went away while waiting gives the task back
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "command", "date", "status", "queue"}).
+			AddRow(taskID, "echo hello", time.Now(), statusQueued, defaultQueue))
+	mock.ExpectQuery(queueQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "paused", "max_in_flight"}))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "task_data" SET`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "webhook_events"`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_notify($1, $2)`)).
+		WithArgs(taskLogsChannel, taskID.String()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "task_data" WHERE id = $1 ORDER BY "task_data"."id" LIMIT $2 FOR UPDATE`)).
+		WithArgs(taskID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status", "lease_id"}).
+			// Lease already taken back by the reaper
+			AddRow(taskID, statusQueued, nil))
+	mock.ExpectRollback()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w = httptest.NewRecorder()
+	server.handlePickTask(w, httptest.NewRequest(http.MethodGet, "/tasks/pick", nil).WithContext(ctx))
+	assert.Empty(w.Body.String())
+
+	// Agents have to be registered
+	agentQuery := regexp.QuoteMeta(`SELECT count(*) FROM "agent_data" WHERE id = $1`)
+	mock.ExpectQuery(agentQuery).
+		WithArgs("agent-2").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+	w = httptest.NewRecorder()
+	server.handlePickTask(w, httptest.NewRequest(http.MethodGet, "/tasks/pick?agent_id=agent-2", nil))
+	assert.Equal(http.StatusForbidden, w.Result().StatusCode)
+
+	mock.ExpectQuery(agentQuery).
+		WithArgs("agent-1").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectQuery).
+		WillReturnError(gorm.ErrRecordNotFound)
+	mock.ExpectRollback()
+
+	w = httptest.NewRecorder()
+	server.handlePickTask(w, httptest.NewRequest(http.MethodGet, "/tasks/pick?agent_id=agent-1", nil))
+	assert.Equal(http.StatusNotFound, w.Result().StatusCode)
+
+	// Database failure is not mistaken for an empty queue
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectQuery).
+		WillReturnError(errors.New("connection reset"))
+	mock.ExpectRollback()
+
+	w = httptest.NewRecorder()
+	server.handlePickTask(w, httptest.NewRequest(http.MethodGet, "/tasks/pick", nil))
+	assert.Equal(http.StatusInternalServerError, w.Result().StatusCode)
+
+	// Malformed agent id
+	w = httptest.NewRecorder()
+	server.handlePickTask(w, httptest.NewRequest(http.MethodGet, "/tasks/pick?agent_id=a/b", nil))
+	assert.Equal(http.StatusBadRequest, w.Result().StatusCode)
+
+	// Malformed agent labels
+	w = httptest.NewRecorder()
+	server.handlePickTask(w, httptest.NewRequest(http.MethodGet, "/tasks/pick?label=linux", nil))
+	assert.Equal(http.StatusBadRequest, w.Result().StatusCode)
+
+	assert.NoError(mock.ExpectationsWereMet())
+}
+
+func TestTaskTimeoutSeconds(t *testing.T) {
+	assert := assert.New(t)
+
+	server := Server{cfg: &Config{DefaultTaskTimeout: time.Hour, MaxTaskTimeout: 2 * time.Hour}}
+	assert.Equal(3600, server.taskTimeoutSeconds(&TaskData{}))
+	assert.Equal(30, server.taskTimeoutSeconds(&TaskData{TimeoutSeconds: intPointer(30)}))
+	assert.Equal(7200, server.taskTimeoutSeconds(&TaskData{TimeoutSeconds: intPointer(100000)}))
+	// Would wrap around as a time.Duration
+	assert.Equal(7200, server.taskTimeoutSeconds(&TaskData{TimeoutSeconds: intPointer(1e10)}))
+}
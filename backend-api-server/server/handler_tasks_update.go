@@ -0,0 +1,100 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TaskUpdate reschedules a queued task. Either RunAt or RunNow has to be
+// given.
+type TaskUpdate struct {
+	RunAt  *time.Time `json:"run_at"`
+	RunNow bool       `json:"run_now"`
+}
+
+func (s *Server) handleUpdateTask(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	idStr, ok := vars["id"]
+	if !ok {
+		http.Error(w, "id parameter is missing", http.StatusBadRequest)
+		return
+	}
+	log.Infof("Updating a task with id %s", idStr)
+
+	taskID, err := uuid.Parse(idStr)
+	if err != nil {
+		http.Error(w, "invalid id format", http.StatusBadRequest)
+		return
+	}
+
+	var taskUpdate TaskUpdate
+	if err := json.NewDecoder(r.Body).Decode(&taskUpdate); err != nil {
+		log.Error("invalid request payload: " + err.Error())
+		http.Error(w, "invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if (taskUpdate.RunAt != nil) == taskUpdate.RunNow {
+		http.Error(w, "either run_at or run_now must be given", http.StatusBadRequest)
+		return
+	}
+
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		log.Error("failed to start transaction: " + tx.Error.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	var taskData TaskData
+	err = tx.
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(&taskData, "id = ?", taskID).Error
+	if err != nil {
+		tx.Rollback()
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "task not found", http.StatusNotFound)
+		} else {
+			log.Error("failed to retrieve task: " + err.Error())
+			http.Error(w, "failed to retrieve task", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if taskData.Status != statusQueued {
+		tx.Rollback()
+		http.Error(w, "only queued tasks can be rescheduled", http.StatusConflict)
+		return
+	}
+
+	// A pending retry backoff is kept, run_now only drops the schedule.
+	taskData.RunAt = taskUpdate.RunAt
+	if err := tx.Save(&taskData).Error; err != nil {
+		tx.Rollback()
+		log.Error("failed to update task: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		log.Error("failed to commit transaction: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	task := taskData.toTask()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(task); err != nil {
+		log.Error("failed to encode response: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
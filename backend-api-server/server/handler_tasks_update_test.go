@@ -0,0 +1,118 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestHandlerTaskUpdate(t *testing.T) {
+	assert := assert.New(t)
+
+	// Mock
+	mockDB, mock, err := sqlmock.New()
+	assert.NoError(err)
+	defer func() { _ = mockDB.Close() }()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:                 mockDB,
+		DriverName:           "postgres",
+		PreferSimpleProtocol: true,
+	})
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	assert.NoError(err)
+
+	server := Server{db: db}
+	selectQuery := regexp.QuoteMeta(`SELECT * FROM "task_data" WHERE id = $1 ORDER BY "task_data"."id" LIMIT $2 FOR UPDATE`)
+	updateQuery := regexp.QuoteMeta(`UPDATE "task_data" SET`)
+	columns := []string{"id", "command", "date", "status", "not_before", "run_at"}
+	update := func(id string, body string) (int, Task) {
+		req := httptest.NewRequest(http.MethodPatch, "/tasks/"+id, strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"id": id})
+		w := httptest.NewRecorder()
+		server.handleUpdateTask(w, req)
+		var task Task
+		if w.Code == http.StatusOK {
+			assert.NoError(json.NewDecoder(w.Body).Decode(&task))
+		}
+		return w.Code, task
+	}
+
+	// Reschedule - the retry backoff is kept
+	taskID := uuid.New()
+	notBefore := time.Now().Add(time.Minute).UTC().Truncate(time.Second)
+	runAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectQuery).
+		WithArgs(taskID, 1).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(taskID, "backup", time.Now(), statusQueued, notBefore, nil))
+	mock.ExpectExec(updateQuery).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	code, task := update(taskID.String(), `{"run_at": "`+runAt.Format(time.RFC3339)+`"}`)
+	assert.Equal(http.StatusOK, code)
+	assert.True(runAt.Equal(*task.RunAt))
+	assert.True(notBefore.Equal(*task.NotBefore))
+
+	// Run now - only the schedule is dropped, not the retry backoff
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectQuery).
+		WithArgs(taskID, 1).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(taskID, "backup", time.Now(), statusQueued, notBefore, runAt))
+	mock.ExpectExec(updateQuery).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	code, task = update(taskID.String(), `{"run_now": true}`)
+	assert.Equal(http.StatusOK, code)
+	assert.Nil(task.RunAt)
+	assert.True(notBefore.Equal(*task.NotBefore))
+
+	// Only queued tasks can be rescheduled
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectQuery).
+		WithArgs(taskID, 1).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(taskID, "backup", time.Now(), statusInProgress, nil, nil))
+	mock.ExpectRollback()
+
+	code, _ = update(taskID.String(), `{"run_now": true}`)
+	assert.Equal(http.StatusConflict, code)
+
+	// Unknown task
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectQuery).
+		WithArgs(taskID, 1).
+		WillReturnError(gorm.ErrRecordNotFound)
+	mock.ExpectRollback()
+
+	code, _ = update(taskID.String(), `{"run_now": true}`)
+	assert.Equal(http.StatusNotFound, code)
+
+	// Either run_at or run_now has to be given
+	for _, body := range []string{
+		`{}`,
+		`{"run_now": false}`,
+		`{"run_at": "` + runAt.Format(time.RFC3339) + `", "run_now": true}`,
+		`not json`,
+	} {
+		code, _ = update(taskID.String(), body)
+		assert.Equal(http.StatusBadRequest, code, body)
+	}
+
+	code, _ = update("not-a-uuid", `{"run_now": true}`)
+	assert.Equal(http.StatusBadRequest, code)
+
+	assert.NoError(mock.ExpectationsWereMet())
+}
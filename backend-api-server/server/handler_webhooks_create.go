@@ -0,0 +1,112 @@
+package server
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// Webhook is a subscription to task events. Its secret is only returned when
+// it is created.
+type Webhook struct {
+	ID     uuid.UUID `json:"id"`
+	URL    string    `json:"url"`
+	Secret string    `json:"secret,omitempty"`
+	Events []string  `json:"events"`
+	Date   time.Time `json:"date"`
+}
+
+type WebhookCreate struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+}
+
+// webhookSecretBytes is the length of the generated secrets.
+const webhookSecretBytes = 32
+
+// toWebhookData validates the payload and creates a new webhook from it, with
+// its secret sealed by aead. A secret is generated unless one is given, it is
+// returned in plaintext.
+func (c *WebhookCreate) toWebhookData(aead cipher.AEAD) (WebhookData, string, error) {
+	target, err := url.Parse(c.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return WebhookData{}, "", errors.New("url must be an absolute http or https URL")
+	}
+	events := []string{}
+	for _, event := range c.Events {
+		if !slices.Contains(webhookEventTypes, event) {
+			return WebhookData{}, "", fmt.Errorf("unknown event %q", event)
+		}
+		if !slices.Contains(events, event) {
+			events = append(events, event)
+		}
+	}
+
+	secret := c.Secret
+	if secret == "" {
+		random := make([]byte, webhookSecretBytes)
+		if _, err := rand.Read(random); err != nil {
+			return WebhookData{}, "", err
+		}
+		secret = hex.EncodeToString(random)
+	}
+
+	webhookData := WebhookData{
+		ID:     uuid.New(),
+		URL:    c.URL,
+		Events: events,
+	}
+	if err := webhookData.sealSecret(aead, secret); err != nil {
+		return WebhookData{}, "", err
+	}
+	return webhookData, secret, nil
+}
+
+func (s *Server) handleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	log.Info("Creating new webhook")
+	var webhookCreate WebhookCreate
+	if err := json.NewDecoder(r.Body).Decode(&webhookCreate); err != nil {
+		log.Error("invalid request payload: " + err.Error())
+		http.Error(w, "invalid request payload", http.StatusBadRequest)
+		return
+	}
+	webhookData, secret, err := webhookCreate.toWebhookData(s.secrets)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !s.cfg.WebhookAllowPrivateTargets {
+		if err := checkWebhookHost(r.Context(), webhookData.URL); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := s.db.Create(&webhookData).Error; err != nil {
+		log.Error("failed to save webhook: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	webhook := webhookData.toWebhook()
+	webhook.Secret = secret
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(webhook); err != nil {
+		log.Error("failed to encode response: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// handleDeleteWebhook deletes a webhook along with its deliveries, pending
+// deliveries are not sent anymore.
+func (s *Server) handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	idStr, ok := vars["id"]
+	if !ok {
+		http.Error(w, "id parameter is missing", http.StatusBadRequest)
+		return
+	}
+	log.Infof("Deleting a webhook with id %s", idStr)
+
+	webhookID, err := uuid.Parse(idStr)
+	if err != nil {
+		http.Error(w, "invalid id format", http.StatusBadRequest)
+		return
+	}
+
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		log.Error("failed to delete webhook: " + tx.Error.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	result := tx.Delete(&WebhookData{}, "id = ?", webhookID)
+	if result.Error != nil {
+		tx.Rollback()
+		log.Error("failed to delete webhook: " + result.Error.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		tx.Rollback()
+		http.Error(w, "webhook not found", http.StatusNotFound)
+		return
+	}
+	if err := tx.Delete(&WebhookDelivery{}, "webhook_id = ?", webhookID).Error; err != nil {
+		tx.Rollback()
+		log.Error("failed to delete webhook deliveries: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		log.Error("failed to delete webhook: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
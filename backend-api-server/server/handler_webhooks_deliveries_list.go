@@ -0,0 +1,87 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultDeliveryPageSize = 100
+	maxDeliveryPageSize     = 1000
+)
+
+var deliveryStatuses = []string{deliveryPending, deliverySucceeded, deliveryFailed}
+
+// handleListWebhookDeliveries lists the latest deliveries of a webhook, newest
+// first. They can be narrowed down to a status and limited in number.
+func (s *Server) handleListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	idStr, ok := vars["id"]
+	if !ok {
+		http.Error(w, "id parameter is missing", http.StatusBadRequest)
+		return
+	}
+	log.Infof("Listing deliveries of webhook with id %s", idStr)
+
+	webhookID, err := uuid.Parse(idStr)
+	if err != nil {
+		http.Error(w, "invalid id format", http.StatusBadRequest)
+		return
+	}
+	params := r.URL.Query()
+	status := params.Get("status")
+	if status != "" && !slices.Contains(deliveryStatuses, status) {
+		http.Error(w, "invalid status", http.StatusBadRequest)
+		return
+	}
+	limit := defaultDeliveryPageSize
+	if raw := params.Get("limit"); raw != "" {
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxDeliveryPageSize {
+			http.Error(w, "limit must be between 1 and "+strconv.Itoa(maxDeliveryPageSize), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var webhookData WebhookData
+	if err := s.db.Select("id").First(&webhookData, "id = ?", webhookID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "webhook not found", http.StatusNotFound)
+		} else {
+			log.Error("failed to retrieve webhook: " + err.Error())
+			http.Error(w, "failed to retrieve webhook", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	query := s.db.Where("webhook_id = ?", webhookID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	deliveries := []WebhookDelivery{}
+	if err := query.Order("created_at DESC").Limit(limit).Find(&deliveries).Error; err != nil {
+		log.Error("failed to retrieve webhook deliveries: " + err.Error())
+		http.Error(w, "failed to retrieve webhook deliveries", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"deliveries": deliveries,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(response); err != nil {
+		log.Error("failed to encode response: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
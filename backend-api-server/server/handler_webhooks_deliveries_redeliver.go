@@ -0,0 +1,83 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// handleRedeliverWebhook sends the event of a delivery to the webhook again.
+// The redelivery is a new pending delivery with attempts of its own, the
+// original one is kept as it is. Pending deliveries are still being
+// attempted and cannot be redelivered.
+func (s *Server) handleRedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	idStr, ok := vars["id"]
+	if !ok {
+		http.Error(w, "id parameter is missing", http.StatusBadRequest)
+		return
+	}
+	deliveryIDStr, ok := vars["delivery_id"]
+	if !ok {
+		http.Error(w, "delivery_id parameter is missing", http.StatusBadRequest)
+		return
+	}
+	log.Infof("Redelivering delivery %s of webhook with id %s", deliveryIDStr, idStr)
+
+	webhookID, err := uuid.Parse(idStr)
+	if err != nil {
+		http.Error(w, "invalid id format", http.StatusBadRequest)
+		return
+	}
+	deliveryID, err := uuid.Parse(deliveryIDStr)
+	if err != nil {
+		http.Error(w, "invalid delivery_id format", http.StatusBadRequest)
+		return
+	}
+
+	var original WebhookDelivery
+	if err := s.db.First(&original, "id = ? AND webhook_id = ?", deliveryID, webhookID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "delivery not found", http.StatusNotFound)
+		} else {
+			log.Error("failed to retrieve webhook delivery: " + err.Error())
+			http.Error(w, "failed to retrieve webhook delivery", http.StatusInternalServerError)
+		}
+		return
+	}
+	if original.Status == deliveryPending {
+		http.Error(w, "delivery is still pending", http.StatusConflict)
+		return
+	}
+
+	now := time.Now()
+	delivery := WebhookDelivery{
+		ID:            uuid.New(),
+		WebhookID:     original.WebhookID,
+		EventID:       original.EventID,
+		EventType:     original.EventType,
+		TaskID:        original.TaskID,
+		Status:        deliveryPending,
+		NextAttemptAt: &now,
+		RedeliveryOf:  &original.ID,
+	}
+	if err := s.db.Create(&delivery).Error; err != nil {
+		log.Error("failed to save webhook delivery: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(delivery); err != nil {
+		log.Error("failed to encode response: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+func (s *Server) handleGetWebhook(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	idStr, ok := vars["id"]
+	if !ok {
+		http.Error(w, "id parameter is missing", http.StatusBadRequest)
+		return
+	}
+	log.Infof("Getting a webhook with id %s", idStr)
+
+	webhookID, err := uuid.Parse(idStr)
+	if err != nil {
+		http.Error(w, "invalid id format", http.StatusBadRequest)
+		return
+	}
+
+	var webhookData WebhookData
+	if err := s.db.First(&webhookData, "id = ?", webhookID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			http.Error(w, "webhook not found", http.StatusNotFound)
+		} else {
+			log.Error("failed to retrieve webhook: " + err.Error())
+			http.Error(w, "failed to retrieve webhook", http.StatusInternalServerError)
+		}
+		return
+	}
+	webhook := webhookData.toWebhook()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(webhook); err != nil {
+		log.Error("failed to encode response: " + err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func (s *Server) handleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	log.Info("Listing webhooks")
+	var webhooksData []WebhookData
+	if err := s.db.Order("date ASC").Find(&webhooksData).Error; err != nil {
+		log.Error("failed to retrieve webhooks: " + err.Error())
+		http.Error(w, "failed to retrieve webhooks", http.StatusInternalServerError)
+		return
+	}
+
+	webhooks := make([]Webhook, len(webhooksData))
+	for i, wd := range webhooksData {
+		webhooks[i] = wd.toWebhook()
+	}
+
+	response := map[string]interface{}{
+		"webhooks": webhooks,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(response); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	idempotencyKeyHeader    = "Idempotency-Key"
+	idempotentReplayHeader  = "Idempotent-Replayed"
+	maxIdempotencyKeyLength = 255
+)
+
+var (
+	errIdempotencyKeyReused = errors.New("idempotency key was used for a different request")
+	errIdempotencyKeyTaken  = errors.New("idempotency key is taken by a request in progress")
+)
+
+// IdempotencyKey remembers the task created by a request with an
+// Idempotency-Key header until it expires, so that retries of the request
+// get that task instead of creating another one. RequestHash tells retries
+// apart from different requests reusing the key.
+type IdempotencyKey struct {
+	Key         string    `gorm:"primaryKey"`
+	TaskID      uuid.UUID `gorm:"type:uuid"`
+	RequestHash string
+	ExpiresAt   time.Time `gorm:"index"`
+}
+
+// hashTaskCreate returns the hash of the payload of a task creation.
+func hashTaskCreate(c TaskCreate) (string, error) {
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(payload)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// lookupIdempotentTask returns the task created by an earlier request with
+// the key, or nil if the key is unknown or has expired.
+func (s *Server) lookupIdempotentTask(key IdempotencyKey) (*Task, error) {
+	var stored IdempotencyKey
+	err := s.db.Where("key = ? AND expires_at > ?", key.Key, time.Now()).First(&stored).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if stored.RequestHash != key.RequestHash {
+		return nil, errIdempotencyKeyReused
+	}
+	task, err := s.getTask(stored.TaskID)
+	if err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// claimIdempotencyKey saves the key for the task within the transaction
+// creating it. An expired key is taken over, while a live one, or one saved
+// by a concurrent transaction, fails with errIdempotencyKeyTaken.
+func claimIdempotencyKey(tx *gorm.DB, key IdempotencyKey) error {
+	result := tx.
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "key"}},
+			DoUpdates: clause.AssignmentColumns([]string{"task_id", "request_hash", "expires_at"}),
+			Where: clause.Where{Exprs: []clause.Expression{
+				clause.Lt{Column: clause.Column{Table: "idempotency_keys", Name: "expires_at"}, Value: time.Now()},
+			}},
+		}).
+		Create(&key)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errIdempotencyKeyTaken
+	}
+	return nil
+}
+
+// deleteExpiredIdempotencyKeys forgets the keys whose retry window has
+// passed.
+func (s *Server) deleteExpiredIdempotencyKeys() error {
+	return s.db.Where("expires_at < ?", time.Now()).Delete(&IdempotencyKey{}).Error
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const maxTaskLabels = 32
+
+var labelPattern = regexp.MustCompile(`^[A-Za-z0-9_./-]{1,63}$`)
+
+// labelsSatisfied matches tasks whose label selector is a subset of the
+// labels of the picking agent. Tasks without a selector run anywhere.
+const labelsSatisfied = "COALESCE(labels, '{}'::jsonb) <@ CAST(? AS jsonb)"
+
+func validateLabels(labels map[string]string) error {
+	if len(labels) > maxTaskLabels {
+		return fmt.Errorf("at most %d labels can be given", maxTaskLabels)
+	}
+	for key, value := range labels {
+		if !labelPattern.MatchString(key) || !labelPattern.MatchString(value) {
+			return fmt.Errorf("invalid label: %q=%q", key, value)
+		}
+	}
+	return nil
+}
+
+// parseLabels reads labels given as key=value pairs, as agents send them in
+// the query of pick requests.
+func parseLabels(pairs []string) (map[string]string, error) {
+	labels := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, errors.New("labels must be given as key=value")
+		}
+		labels[key] = value
+	}
+	if err := validateLabels(labels); err != nil {
+		return nil, err
+	}
+	return labels, nil
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// taskQueuedChannel is the Postgres notification channel that is notified
+// whenever a task might have become pickable.
+const taskQueuedChannel = "task_queued"
+
+// taskLogsChannel is the Postgres notification channel that is notified with
+// the id of a task whenever it got new output or its status changed.
+const taskLogsChannel = "task_logs"
+
+const notifierReconnectDelay = 5 * time.Second
+
+// notifyTaskQueued wakes up the pick requests waiting for a task of the queue
+// on every server replica. The notification is only delivered once the
+// transaction commits, so waiters never look for the task before it is
+// visible.
+func notifyTaskQueued(tx *gorm.DB, queue string) error {
+	return tx.Exec("SELECT pg_notify(?, ?)", taskQueuedChannel, queue).Error
+}
+
+// notifyTaskLogs wakes up the followers of the logs and the watchers of the
+// task on every server replica once the transaction commits.
+func notifyTaskLogs(tx *gorm.DB, taskID uuid.UUID) error {
+	return tx.Exec("SELECT pg_notify(?, ?)", taskLogsChannel, taskID.String()).Error
+}
+
+// pickableNow reports whether agents can pick the queued task right away.
+// Tasks waiting for their run_at, a retry backoff or a parent are found by
+// the PickPollInterval polling of waiting agents or woken up by whatever
+// unblocks them.
+func pickableNow(tx *gorm.DB, taskData *TaskData) (bool, error) {
+	now := time.Now()
+	if taskData.Status != statusQueued ||
+		(taskData.NotBefore != nil && taskData.NotBefore.After(now)) ||
+		(taskData.RunAt != nil && taskData.RunAt.After(now)) {
+		return false, nil
+	}
+	if len(taskData.DependsOn) == 0 {
+		return true, nil
+	}
+	var pending int64
+	err := tx.Model(&TaskData{}).
+		Where("id = ?", taskData.ID).
+		Where(pendingDependencies).
+		Count(&pending).Error
+	return pending == 0, err
+}
+
+// notifyIfPickable notifies the queue of a new or requeued task if agents can
+// pick it right away.
+func notifyIfPickable(tx *gorm.DB, taskData *TaskData) error {
+	pickable, err := pickableNow(tx, taskData)
+	if err != nil || !pickable {
+		return err
+	}
+	return notifyTaskQueued(tx, taskData.Queue)
+}
+
+// notifyTaskDone notifies the queues with tasks that became pickable when a
+// running task completed or was requeued: the task itself, the tasks of its
+// queue if the queue is capped and the dependents whose last pending parent
+// it was.
+func notifyTaskDone(tx *gorm.DB, taskData *TaskData) error {
+	var queues []string
+	pickable, err := pickableNow(tx, taskData)
+	if err != nil {
+		return err
+	}
+	if !pickable {
+		var capped int64
+		err := tx.Model(&QueueData{}).
+			Where("name = ? AND max_in_flight IS NOT NULL", taskData.Queue).
+			Count(&capped).Error
+		if err != nil {
+			return err
+		}
+		pickable = capped > 0
+	}
+	if pickable {
+		queues = append(queues, taskData.Queue)
+	}
+
+	if taskData.completed() {
+		var dependentQueues []string
+		err := tx.Model(&TaskData{}).
+			Distinct("queue").
+			Where("status = ?", statusQueued).
+			Where("id IN (SELECT task_id FROM task_dependencies WHERE parent_id = ?)", taskData.ID).
+			Where("NOT "+pendingDependencies).
+			Pluck("queue", &dependentQueues).Error
+		if err != nil {
+			return err
+		}
+		for _, queue := range dependentQueues {
+			if !slices.Contains(queues, queue) {
+				queues = append(queues, queue)
+			}
+		}
+	}
+
+	for _, queue := range queues {
+		if err := notifyTaskQueued(tx, queue); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// taskNotifier fans out the notifications of taskQueuedChannel to the pick
+// requests waiting for a task of the notified queue, and those of
+// taskLogsChannel to the followers and watchers of the notified task.
+type taskNotifier struct {
+	mu      sync.Mutex
+	waiters map[*taskWaiter]struct{}
+}
+
+type taskWaiter struct {
+	channel  string
+	payloads []string
+	woken    chan struct{}
+}
+
+func newTaskNotifier() *taskNotifier {
+	return &taskNotifier{waiters: map[*taskWaiter]struct{}{}}
+}
+
+// wait returns a channel that is closed by the next notification of one of
+// the queues, and a function to stop waiting. Waiters have to get it before
+// looking for a task so that they cannot miss one. Without a notifier the
+// channel is nil and never closed.
+func (n *taskNotifier) wait(queues []string) (<-chan struct{}, func()) {
+	return n.subscribe(taskQueuedChannel, queues)
+}
+
+// waitLogs is like wait for the next output or change of status of the task.
+// Followers and watchers have to get it before reading the task.
+func (n *taskNotifier) waitLogs(taskID uuid.UUID) (<-chan struct{}, func()) {
+	return n.subscribe(taskLogsChannel, []string{taskID.String()})
+}
+
+func (n *taskNotifier) subscribe(channel string, payloads []string) (<-chan struct{}, func()) {
+	if n == nil {
+		return nil, func() {}
+	}
+	waiter := &taskWaiter{channel: channel, payloads: payloads, woken: make(chan struct{})}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.waiters[waiter] = struct{}{}
+	return waiter.woken, func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		delete(n.waiters, waiter)
+	}
+}
+
+// broadcast wakes up the current waiters of the payload, a queue or a task,
+// on the channel.
+func (n *taskNotifier) broadcast(channel, payload string) {
+	n.wake(func(waiter *taskWaiter) bool {
+		return waiter.channel == channel && slices.Contains(waiter.payloads, payload)
+	})
+}
+
+// broadcastAll wakes up all current waiters.
+func (n *taskNotifier) broadcastAll() {
+	n.wake(func(*taskWaiter) bool { return true })
+}
+
+func (n *taskNotifier) wake(match func(*taskWaiter) bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for waiter := range n.waiters {
+		if match(waiter) {
+			close(waiter.woken)
+			delete(n.waiters, waiter)
+		}
+	}
+}
+
+// listen keeps a dedicated connection listening on the channels until
+// ctx is done, reconnecting whenever the connection is lost.
+func (n *taskNotifier) listen(ctx context.Context, dsn string) {
+	for {
+		err := n.listenOnce(ctx, dsn)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Errorf("task notifications interrupted, reconnecting in %s: %v", notifierReconnectDelay, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(notifierReconnectDelay):
+		}
+	}
+}
+
+func (n *taskNotifier) listenOnce(ctx context.Context, dsn string) error {
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	for _, channel := range []string{taskQueuedChannel, taskLogsChannel} {
+		if _, err := conn.Exec(ctx, "LISTEN "+channel); err != nil {
+			return err
+		}
+	}
+	// Notifications sent while not listening are lost, the waiters look
+	// again in case they missed one.
+	n.broadcastAll()
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		if notification.Channel == taskQueuedChannel {
+			log.Debugf("Task queued in %q, waking up waiting agents", notification.Payload)
+		}
+		n.broadcast(notification.Channel, notification.Payload)
+	}
+}
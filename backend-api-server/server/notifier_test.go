@@ -0,0 +1,110 @@
+package server
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestTaskNotifier(t *testing.T) {
+	assert := assert.New(t)
+
+	notifier := newTaskNotifier()
+	builds, stopBuilds := notifier.wait([]string{"builds"})
+	both, stopBoth := notifier.wait([]string{defaultQueue, "deploy"})
+	defer stopBuilds()
+	defer stopBoth()
+
+	// Only the waiters of the notified queue are woken up
+	notifier.broadcast(taskQueuedChannel, "deploy")
+	assert.True(isClosed(both))
+	assert.False(isClosed(builds))
+
+	notifier.broadcastAll()
+	assert.True(isClosed(builds))
+
+	// Waiters that stopped waiting are forgotten
+	deploy, stop := notifier.wait([]string{"deploy"})
+	stop()
+	notifier.broadcast(taskQueuedChannel, "deploy")
+	assert.False(isClosed(deploy))
+	assert.Empty(notifier.waiters)
+}
+
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestNotifyTaskDone(t *testing.T) {
+	assert := assert.New(t)
+
+	// Mock
+	mockDB, mock, err := sqlmock.New()
+	assert.NoError(err)
+	defer func() { _ = mockDB.Close() }()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:                 mockDB,
+		DriverName:           "postgres",
+		PreferSimpleProtocol: true,
+	})
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	assert.NoError(err)
+
+	cappedQuery := regexp.QuoteMeta(`SELECT count(*) FROM "queue_data" WHERE name = $1 AND max_in_flight IS NOT NULL`)
+	dependentsQuery := regexp.QuoteMeta(`SELECT DISTINCT "queue" FROM "task_data" WHERE status = $1 AND id IN (SELECT task_id FROM task_dependencies WHERE parent_id = $2) AND (NOT EXISTS (`)
+	notifyQuery := regexp.QuoteMeta(`SELECT pg_notify(`)
+
+	// Finished task of an uncapped queue only wakes up its dependents
+	exitCode := 0
+	taskData := TaskData{ID: uuid.New(), Status: statusFinished, ExitCode: &exitCode, Queue: defaultQueue}
+	mock.ExpectQuery(cappedQuery).
+		WithArgs(defaultQueue).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(dependentsQuery).
+		WithArgs(statusQueued, taskData.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"queue"}).AddRow("deploy"))
+	mock.ExpectExec(notifyQuery).
+		WithArgs(taskQueuedChannel, "deploy").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	assert.NoError(notifyTaskDone(db, &taskData))
+
+	// Finished task of a capped queue frees a slot
+	mock.ExpectQuery(cappedQuery).
+		WithArgs(defaultQueue).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(dependentsQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"queue"}))
+	mock.ExpectExec(notifyQuery).
+		WithArgs(taskQueuedChannel, defaultQueue).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	assert.NoError(notifyTaskDone(db, &taskData))
+
+	// Retry waiting for its backoff is not pickable yet
+	notBefore := time.Now().Add(time.Minute)
+	taskData = TaskData{ID: uuid.New(), Status: statusQueued, NotBefore: &notBefore, Queue: defaultQueue}
+	mock.ExpectQuery(cappedQuery).
+		WithArgs(defaultQueue).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	assert.NoError(notifyTaskDone(db, &taskData))
+
+	// Requeued task is pickable right away
+	taskData.NotBefore = nil
+	mock.ExpectExec(notifyQuery).
+		WithArgs(taskQueuedChannel, defaultQueue).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	assert.NoError(notifyTaskDone(db, &taskData))
+
+	assert.NoError(mock.ExpectationsWereMet())
+}
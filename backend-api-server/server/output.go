@@ -0,0 +1,44 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	streamStdout = "stdout"
+	streamStderr = "stderr"
+)
+
+var errInvalidStream = errors.New("stream must be stdout or stderr")
+
+// OutputLine is a line written by the command of a task, in the order the
+// agent read it from either of the streams.
+type OutputLine struct {
+	Stream string    `json:"stream"`
+	Data   string    `json:"data"`
+	Time   time.Time `json:"time"`
+}
+
+func validateOutput(lines []OutputLine) error {
+	for _, line := range lines {
+		if line.Stream != streamStdout && line.Stream != streamStderr {
+			return errInvalidStream
+		}
+	}
+	return nil
+}
+
+// outputView concatenates the lines of one stream, it is what the stdout and
+// stderr fields of a task used to hold.
+func outputView(lines []OutputLine, stream string) *string {
+	var view strings.Builder
+	for _, line := range lines {
+		if line.Stream == stream {
+			view.WriteString(line.Data)
+		}
+	}
+	text := view.String()
+	return &text
+}
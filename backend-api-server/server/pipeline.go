@@ -0,0 +1,137 @@
+package server
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	onFailureStop     = "stop"
+	onFailureContinue = "continue"
+)
+
+const (
+	pipelineStatusQueued    = "queued"
+	pipelineStatusRunning   = "running"
+	pipelineStatusSucceeded = "succeeded"
+	pipelineStatusFailed    = "failed"
+	pipelineStatusCancelled = "cancelled"
+)
+
+// PipelineData is an ordered list of steps, each of them executed as a task
+// after the previous one.
+type PipelineData struct {
+	ID        uuid.UUID `json:"id" gorm:"type:uuid;primaryKey"`
+	Name      string    `json:"name"`
+	OnFailure string    `json:"on_failure"`
+	StepNames []string  `json:"step_names" gorm:"serializer:json"`
+	Date      time.Time `json:"date" gorm:"autoCreateTime"`
+	// CancelledAt is set once the pipeline is aborted.
+	CancelledAt *time.Time `json:"cancelled_at"`
+}
+
+// toPipeline aggregates the state of the pipeline from the tasks of its
+// steps, which have to be ordered by step.
+func (d *PipelineData) toPipeline(stepsData []TaskData) Pipeline {
+	pipeline := Pipeline{
+		ID:        d.ID,
+		Name:      d.Name,
+		OnFailure: d.OnFailure,
+		Steps:     make([]PipelineStep, len(stepsData)),
+	}
+
+	queued, completed, succeeded, cancelled := 0, 0, 0, 0
+	for i, stepData := range stepsData {
+		step := PipelineStep{
+			TaskID:     stepData.ID,
+			Command:    stepData.Command,
+			Status:     stepData.Status,
+			ExitCode:   stepData.ExitCode,
+			StartedAt:  stepData.StartedAt,
+			FinishedAt: stepData.FinishedAt,
+		}
+		// Steps may have been deleted, the names are looked up by the index
+		// of the step rather than its position.
+		if index := stepData.PipelineStep; index != nil && *index >= 0 && *index < len(d.StepNames) {
+			step.Name = d.StepNames[*index]
+		}
+		if stepData.StartedAt != nil && stepData.FinishedAt != nil {
+			duration := stepData.FinishedAt.Sub(*stepData.StartedAt).Seconds()
+			step.DurationSeconds = &duration
+		}
+		pipeline.Steps[i] = step
+
+		if stepData.StartedAt != nil && (pipeline.StartedAt == nil || stepData.StartedAt.Before(*pipeline.StartedAt)) {
+			pipeline.StartedAt = stepData.StartedAt
+		}
+		if stepData.FinishedAt != nil && (pipeline.FinishedAt == nil || stepData.FinishedAt.After(*pipeline.FinishedAt)) {
+			pipeline.FinishedAt = stepData.FinishedAt
+		}
+
+		switch {
+		case stepData.Status == statusQueued:
+			queued++
+		case stepData.completed():
+			completed++
+			if stepData.succeeded() {
+				succeeded++
+			}
+			if stepData.Status == statusCancelled {
+				cancelled++
+			}
+		}
+	}
+
+	switch {
+	case queued == len(stepsData):
+		pipeline.Status = pipelineStatusQueued
+	case completed < len(stepsData):
+		pipeline.Status = pipelineStatusRunning
+		pipeline.FinishedAt = nil
+	case succeeded == len(stepsData):
+		pipeline.Status = pipelineStatusSucceeded
+	case d.CancelledAt != nil:
+		pipeline.Status = pipelineStatusCancelled
+	case cancelled > 0 && d.OnFailure == onFailureStop:
+		// A cancelled step stops the pipeline, while with the continue
+		// policy it is just one more step that did not succeed.
+		pipeline.Status = pipelineStatusCancelled
+	default:
+		pipeline.Status = pipelineStatusFailed
+	}
+	if pipeline.StartedAt != nil && pipeline.FinishedAt != nil {
+		duration := pipeline.FinishedAt.Sub(*pipeline.StartedAt).Seconds()
+		pipeline.DurationSeconds = &duration
+	}
+
+	return pipeline
+}
+
+func (p *Pipeline) completed() bool {
+	return p.Status != pipelineStatusQueued && p.Status != pipelineStatusRunning
+}
+
+// loadPipelineSteps returns the tasks of the steps of the given pipelines,
+// ordered by step.
+func loadPipelineSteps(db *gorm.DB, pipelineIDs []uuid.UUID) (map[uuid.UUID][]TaskData, error) {
+	steps := make(map[uuid.UUID][]TaskData, len(pipelineIDs))
+	if len(pipelineIDs) == 0 {
+		return steps, nil
+	}
+
+	var tasksData []TaskData
+	err := db.
+		Select("id", "command", "status", "exit_code", "started_at", "finished_at", "pipeline_id", "pipeline_step").
+		Where("pipeline_id IN ?", pipelineIDs).
+		Order("pipeline_step ASC").
+		Find(&tasksData).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, taskData := range tasksData {
+		steps[*taskData.PipelineID] = append(steps[*taskData.PipelineID], taskData)
+	}
+	return steps, nil
+}
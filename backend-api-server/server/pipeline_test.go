@@ -0,0 +1,124 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPipelineStatus(t *testing.T) {
+	assert := assert.New(t)
+
+	pipelineData := PipelineData{
+		ID:        uuid.New(),
+		Name:      "build-test-deploy",
+		OnFailure: onFailureStop,
+		StepNames: []string{"build", "test", "deploy"},
+	}
+	start := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	at := func(seconds int) *time.Time {
+		t := start.Add(time.Duration(seconds) * time.Second)
+		return &t
+	}
+	step := func(status string, exitCode *int, startedAt, finishedAt *time.Time) TaskData {
+		return TaskData{ID: uuid.New(), Status: status, ExitCode: exitCode, StartedAt: startedAt, FinishedAt: finishedAt}
+	}
+
+	// Nothing started yet
+	pipeline := pipelineData.toPipeline([]TaskData{
+		{ID: uuid.New(), Status: statusQueued, PipelineStep: intPointer(0)},
+		{ID: uuid.New(), Status: statusQueued, PipelineStep: intPointer(1)},
+		{ID: uuid.New(), Status: statusQueued, PipelineStep: intPointer(2)},
+	})
+	assert.Equal(pipelineStatusQueued, pipeline.Status)
+	assert.Equal("build", pipeline.Steps[0].Name)
+	assert.Nil(pipeline.StartedAt)
+
+	// First step deleted - the names follow the index of the steps
+	pipeline = pipelineData.toPipeline([]TaskData{
+		{ID: uuid.New(), Status: statusQueued, PipelineStep: intPointer(1)},
+		{ID: uuid.New(), Status: statusQueued, PipelineStep: intPointer(2)},
+	})
+	assert.Equal("test", pipeline.Steps[0].Name)
+	assert.Equal("deploy", pipeline.Steps[1].Name)
+
+	// First step done, second one running
+	pipeline = pipelineData.toPipeline([]TaskData{
+		step(statusFinished, intPointer(0), at(0), at(10)),
+		step(statusInProgress, nil, at(11), nil),
+		step(statusQueued, nil, nil, nil),
+	})
+	assert.Equal(pipelineStatusRunning, pipeline.Status)
+	assert.Equal(10.0, *pipeline.Steps[0].DurationSeconds)
+	assert.Nil(pipeline.Steps[1].DurationSeconds)
+	assert.Equal(start, *pipeline.StartedAt)
+	assert.Nil(pipeline.FinishedAt)
+
+	// All steps succeeded
+	pipeline = pipelineData.toPipeline([]TaskData{
+		step(statusFinished, intPointer(0), at(0), at(10)),
+		step(statusFinished, intPointer(0), at(11), at(20)),
+		step(statusFinished, intPointer(0), at(21), at(30)),
+	})
+	assert.Equal(pipelineStatusSucceeded, pipeline.Status)
+	assert.Equal(30.0, *pipeline.DurationSeconds)
+
+	// Second step failed, third one skipped
+	pipeline = pipelineData.toPipeline([]TaskData{
+		step(statusFinished, intPointer(0), at(0), at(10)),
+		step(statusFinished, intPointer(2), at(11), at(20)),
+		step(statusSkipped, nil, nil, nil),
+	})
+	assert.Equal(pipelineStatusFailed, pipeline.Status)
+	assert.Equal(2, *pipeline.Steps[1].ExitCode)
+	assert.Equal(*at(20), *pipeline.FinishedAt)
+
+	// Step cancelled
+	pipeline = pipelineData.toPipeline([]TaskData{
+		step(statusCancelled, nil, at(0), at(5)),
+		step(statusSkipped, nil, nil, nil),
+		step(statusSkipped, nil, nil, nil),
+	})
+	assert.Equal(pipelineStatusCancelled, pipeline.Status)
+
+	// Step cancelled with the continue policy - the following ones ran
+	continued := pipelineData
+	continued.OnFailure = onFailureContinue
+	pipeline = continued.toPipeline([]TaskData{
+		step(statusFinished, intPointer(0), at(0), at(10)),
+		step(statusCancelled, nil, at(11), at(15)),
+		step(statusFinished, intPointer(0), at(16), at(20)),
+	})
+	assert.Equal(pipelineStatusFailed, pipeline.Status)
+
+	// Pipeline aborted
+	continued.CancelledAt = at(12)
+	pipeline = continued.toPipeline([]TaskData{
+		step(statusFinished, intPointer(0), at(0), at(10)),
+		step(statusCancelled, nil, at(11), at(15)),
+		step(statusCancelled, nil, nil, at(12)),
+	})
+	assert.Equal(pipelineStatusCancelled, pipeline.Status)
+}
+
+func TestPipelineCreateValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	pipelineCreate := PipelineCreate{Steps: []PipelineStepCreate{
+		{Name: "build", TaskCreate: TaskCreate{Command: "make"}},
+	}}
+	assert.NoError(pipelineCreate.validate(time.Hour))
+	assert.Equal(onFailureStop, pipelineCreate.OnFailure)
+
+	invalid := []PipelineCreate{
+		{},
+		{OnFailure: "retry", Steps: []PipelineStepCreate{{TaskCreate: TaskCreate{Command: "make"}}}},
+		{Steps: []PipelineStepCreate{{TaskCreate: TaskCreate{Command: "make", DependsOn: []uuid.UUID{uuid.New()}}}}},
+		{Steps: []PipelineStepCreate{{TaskCreate: TaskCreate{Command: "make", Priority: 10000}}}},
+	}
+	for _, c := range invalid {
+		assert.Error(c.validate(time.Hour))
+	}
+}
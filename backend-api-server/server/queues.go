@@ -0,0 +1,142 @@
+package server
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const defaultQueue = "default"
+
+var queueNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+var errQueueUnavailable = errors.New("queue is paused or at capacity")
+
+// QueueData holds the settings of a queue. Queues without settings are
+// running and have no concurrency cap.
+type QueueData struct {
+	Name string `gorm:"primaryKey"`
+	// Paused queues keep their tasks queued, agents don't pick them.
+	Paused bool
+	// MaxInFlight caps the number of tasks of the queue running at the same
+	// time, nil means no cap.
+	MaxInFlight *int
+	UpdatedAt   time.Time
+}
+
+type Queue struct {
+	Name        string `json:"name"`
+	Paused      bool   `json:"paused"`
+	MaxInFlight *int   `json:"max_in_flight"`
+	Queued      int    `json:"queued"`
+	InFlight    int    `json:"in_flight"`
+}
+
+// queueAvailable matches tasks whose queue is neither paused nor running as
+// many tasks as it is allowed to.
+const queueAvailable = `NOT EXISTS (
+	SELECT 1 FROM queue_data q
+	WHERE q.name = task_data.queue
+	AND (q.paused OR q.max_in_flight <= (
+		SELECT COUNT(*) FROM task_data r
+		WHERE r.queue = q.name AND r.status IN ('in_progress', 'cancelling'))))`
+
+// reserveQueueSlot checks again that the queue can take one more running
+// task, holding a lock on its settings until the transaction ends. Concurrent
+// picks from a capped queue are serialized this way, so they cannot exceed
+// the cap together.
+func reserveQueueSlot(tx *gorm.DB, queue string) error {
+	var queueData QueueData
+	err := tx.
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("name = ?", queue).
+		Limit(1).
+		Find(&queueData).Error
+	if err != nil {
+		return err
+	}
+	if queueData.Name == "" {
+		return nil
+	}
+	if queueData.Paused {
+		return errQueueUnavailable
+	}
+	if queueData.MaxInFlight == nil {
+		return nil
+	}
+
+	var inFlight int64
+	err = tx.Model(&TaskData{}).
+		Where("queue = ? AND status IN ?", queue, []string{statusInProgress, statusCancelling}).
+		Count(&inFlight).Error
+	if err != nil {
+		return err
+	}
+	if inFlight >= int64(*queueData.MaxInFlight) {
+		return errQueueUnavailable
+	}
+	return nil
+}
+
+// upsertQueue stores the settings of a queue, creating it if necessary.
+func upsertQueue(db *gorm.DB, queueData *QueueData, columns ...string) error {
+	return db.
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "name"}},
+			DoUpdates: clause.AssignmentColumns(append(columns, "updated_at")),
+		}).
+		Create(queueData).Error
+}
+
+// loadQueues lists the queues that have settings or tasks waiting or running,
+// with the number of those tasks.
+func loadQueues(db *gorm.DB) ([]Queue, error) {
+	queuesData := []QueueData{}
+	if err := db.Order("name ASC").Find(&queuesData).Error; err != nil {
+		return nil, err
+	}
+
+	var counts []struct {
+		Queue  string
+		Status string
+		Count  int
+	}
+	err := db.Model(&TaskData{}).
+		Select("queue, status, COUNT(*) AS count").
+		Where("status IN ?", []string{statusQueued, statusInProgress, statusCancelling}).
+		Group("queue, status").
+		Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	queues := []Queue{}
+	byName := map[string]int{}
+	for _, queueData := range queuesData {
+		byName[queueData.Name] = len(queues)
+		queues = append(queues, Queue{
+			Name:        queueData.Name,
+			Paused:      queueData.Paused,
+			MaxInFlight: queueData.MaxInFlight,
+		})
+	}
+	for _, count := range counts {
+		i, ok := byName[count.Queue]
+		if !ok {
+			i = len(queues)
+			byName[count.Queue] = i
+			queues = append(queues, Queue{Name: count.Queue})
+		}
+		if count.Status == statusQueued {
+			queues[i].Queued += count.Count
+		} else {
+			queues[i].InFlight += count.Count
+		}
+	}
+	sort.Slice(queues, func(i, j int) bool { return queues[i].Name < queues[j].Name })
+	return queues, nil
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm/clause"
+)
+
+const reaperBatchSize = 100
+
+const logPurgeInterval = time.Hour
+
+// runReaper periodically takes back tasks whose executor agent stopped
+// renewing the lease, e.g. because its container died. It also forgets
+// expired idempotency keys and, if a LogRetention is set, purges the logs of
+// tasks completed longer than it ago every logPurgeInterval.
+func (s *Server) runReaper(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.ReaperInterval)
+	defer ticker.Stop()
+	purgeTicker := time.NewTicker(logPurgeInterval)
+	defer purgeTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-purgeTicker.C:
+			if s.cfg.LogRetention <= 0 {
+				continue
+			}
+			if err := s.purgeTaskLogs(time.Now().Add(-s.cfg.LogRetention)); err != nil {
+				log.Error("failed to purge task logs: " + err.Error())
+			}
+		case <-ticker.C:
+			if err := s.reapExpiredLeases(); err != nil {
+				log.Error("failed to reap expired leases: " + err.Error())
+			}
+			if err := s.deleteExpiredIdempotencyKeys(); err != nil {
+				log.Error("failed to delete expired idempotency keys: " + err.Error())
+			}
+		}
+	}
+}
+
+func (s *Server) reapExpiredLeases() error {
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	// Tasks picked before leases were introduced have no expiry at all, those
+	// are considered expired as well. Locked rows are skipped so that several
+	// server replicas can reap concurrently.
+	var tasksData []TaskData
+	err := tx.
+		Where("status IN ?", []string{statusInProgress, statusCancelling}).
+		Where("lease_expires_at IS NULL OR lease_expires_at < ?", time.Now()).
+		Clauses(clause.Locking{Strength: "UPDATE", Options: clause.LockingOptionsSkipLocked}).
+		Limit(reaperBatchSize).
+		Find(&tasksData).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for i := range tasksData {
+		taskData := &tasksData[i]
+		attempt := taskData.expireLease(s.cfg.MaxLeaseExpirations)
+		if err := endAttemptLogs(tx, taskData, &attempt); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Create(&attempt).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Save(taskData).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := recordTaskCompletion(tx, taskData); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := skipDependents(tx, taskData); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := notifyTaskDone(tx, taskData); err != nil {
+			tx.Rollback()
+			return err
+		}
+		log.Warnf("Task %s: %s", taskData.ID, *taskData.StatusReason)
+	}
+
+	return tx.Commit().Error
+}
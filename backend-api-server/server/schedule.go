@@ -0,0 +1,102 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	// Embed the time zone database, the container image doesn't ship it.
+	_ "time/tzdata"
+
+	"github.com/google/uuid"
+	"github.com/robfig/cron/v3"
+)
+
+var cronParser = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// ScheduleData is a recurring schedule that spawns a task from its template
+// every time its cron expression fires.
+type ScheduleData struct {
+	ID         uuid.UUID  `json:"id" gorm:"type:uuid;primaryKey"`
+	Name       string     `json:"name"`
+	Cron       string     `json:"cron"`
+	Timezone   string     `json:"timezone"`
+	Enabled    bool       `json:"enabled"`
+	Task       TaskCreate `json:"task" gorm:"serializer:json"`
+	Date       time.Time  `json:"date" gorm:"autoCreateTime"`
+	NextRunAt  *time.Time `json:"next_run_at" gorm:"index"`
+	LastRunAt  *time.Time `json:"last_run_at"`
+	LastTaskID *uuid.UUID `json:"last_task_id" gorm:"type:uuid"`
+}
+
+func (s *Schedule) toScheduleData() ScheduleData {
+	return ScheduleData{
+		ID:         s.ID,
+		Name:       s.Name,
+		Cron:       s.Cron,
+		Timezone:   s.Timezone,
+		Enabled:    s.Enabled,
+		Task:       s.Task,
+		NextRunAt:  s.NextRunAt,
+		LastRunAt:  s.LastRunAt,
+		LastTaskID: s.LastTaskID,
+	}
+}
+
+func (d *ScheduleData) toSchedule() Schedule {
+	return Schedule{
+		ID:         d.ID,
+		Name:       d.Name,
+		Cron:       d.Cron,
+		Timezone:   d.Timezone,
+		Enabled:    d.Enabled,
+		Task:       d.Task,
+		NextRunAt:  d.NextRunAt,
+		LastRunAt:  d.LastRunAt,
+		LastTaskID: d.LastTaskID,
+	}
+}
+
+// nextRun returns the first time after the given one when the cron
+// expression fires in the given time zone.
+func nextRun(expr, timezone string, after time.Time) (time.Time, error) {
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timezone: %w", err)
+	}
+	schedule, err := cronParser.Parse(expr)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid cron expression: %w", err)
+	}
+	next := schedule.Next(after.In(loc))
+	if next.IsZero() {
+		return time.Time{}, errors.New("cron expression never fires")
+	}
+	return next, nil
+}
+
+// reschedule computes the next fire time of the schedule. Disabled schedules
+// have no next fire time.
+func (d *ScheduleData) reschedule(now time.Time) error {
+	if !d.Enabled {
+		d.NextRunAt = nil
+		return nil
+	}
+	next, err := nextRun(d.Cron, d.Timezone, now)
+	if err != nil {
+		return err
+	}
+	d.NextRunAt = &next
+	return nil
+}
+
+// spawnTask creates the task of the current fire of the schedule.
+func (d *ScheduleData) spawnTask(maxTimeout time.Duration) (TaskData, error) {
+	task, err := d.Task.toTask(maxTimeout)
+	if err != nil {
+		return TaskData{}, err
+	}
+	task.ScheduleID = &d.ID
+	task.ScheduledFor = d.NextRunAt
+
+	return task.toTaskData(), nil
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNextRun(t *testing.T) {
+	assert := assert.New(t)
+
+	after := time.Date(2025, 3, 1, 12, 30, 0, 0, time.UTC)
+
+	next, err := nextRun("0 * * * *", "UTC", after)
+	assert.NoError(err)
+	assert.Equal(time.Date(2025, 3, 1, 13, 0, 0, 0, time.UTC), next.UTC())
+
+	// 03:00 in Budapest is 02:00 in UTC during winter time
+	next, err = nextRun("0 3 * * *", "Europe/Budapest", after)
+	assert.NoError(err)
+	assert.Equal(time.Date(2025, 3, 2, 2, 0, 0, 0, time.UTC), next.UTC())
+
+	next, err = nextRun("@daily", "UTC", after)
+	assert.NoError(err)
+	assert.Equal(time.Date(2025, 3, 2, 0, 0, 0, 0, time.UTC), next.UTC())
+
+	_, err = nextRun("not a cron", "UTC", after)
+	assert.Error(err)
+
+	_, err = nextRun("0 * * * *", "Mars/Olympus_Mons", after)
+	assert.Error(err)
+}
+
+func TestScheduleCreate(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Date(2025, 3, 1, 12, 30, 0, 0, time.UTC)
+	disabled := false
+
+	// Valid schedule with defaults
+	scheduleCreate := ScheduleCreate{Cron: "*/15 * * * *", Task: TaskCreate{Command: "make backup", Priority: 5}}
+	schedule, err := scheduleCreate.toSchedule(now, time.Hour)
+	assert.NoError(err)
+	assert.Equal("UTC", schedule.Timezone)
+	assert.True(schedule.Enabled)
+	assert.Equal(time.Date(2025, 3, 1, 12, 45, 0, 0, time.UTC), schedule.NextRunAt.UTC())
+
+	// Spawned task refers back to the schedule
+	scheduleData := schedule.toScheduleData()
+	taskData, err := scheduleData.spawnTask(time.Hour)
+	assert.NoError(err)
+	assert.Equal("make backup", taskData.Command)
+	assert.Equal(statusQueued, taskData.Status)
+	assert.Equal(5, taskData.Priority)
+	assert.Equal(schedule.ID, *taskData.ScheduleID)
+	assert.Equal(*schedule.NextRunAt, *taskData.ScheduledFor)
+
+	// Disabled schedule has no next run
+	scheduleCreate.Enabled = &disabled
+	schedule, err = scheduleCreate.toSchedule(now, time.Hour)
+	assert.NoError(err)
+	assert.Nil(schedule.NextRunAt)
+
+	// Invalid schedules
+	invalid := []ScheduleCreate{
+		{Cron: "61 * * * *", Task: TaskCreate{Command: "true"}},
+		{Cron: "@hourly", Timezone: "Nowhere", Task: TaskCreate{Command: "true"}},
+		{Cron: "@hourly", Task: TaskCreate{Command: "true", RunAt: &now}},
+		{Cron: "@hourly", Task: TaskCreate{Command: "true", Priority: 5000}},
+	}
+	for _, c := range invalid {
+		_, err := c.toSchedule(now, time.Hour)
+		assert.Error(err)
+	}
+}
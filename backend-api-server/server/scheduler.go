@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm/clause"
+)
+
+const schedulerBatchSize = 100
+
+// runScheduler periodically spawns the tasks of the schedules that are due.
+func (s *Server) runScheduler(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.SchedulerInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.fireDueSchedules(); err != nil {
+				log.Error("failed to fire schedules: " + err.Error())
+			}
+		}
+	}
+}
+
+// fireDueSchedules creates one task for every due schedule. The schedule rows
+// are locked until the tasks are saved and the schedules are moved to their
+// next fire time, so with several server replicas every fire still creates
+// exactly one task. The unique index on the schedule and the fire time of the
+// tasks guards the same.
+func (s *Server) fireDueSchedules() error {
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	now := time.Now()
+	var schedulesData []ScheduleData
+	err := tx.
+		Where("enabled AND next_run_at <= ?", now).
+		Clauses(clause.Locking{Strength: "UPDATE", Options: clause.LockingOptionsSkipLocked}).
+		Limit(schedulerBatchSize).
+		Find(&schedulesData).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for i := range schedulesData {
+		scheduleData := &schedulesData[i]
+		taskData, err := scheduleData.spawnTask(s.cfg.MaxTaskTimeout)
+		if err != nil {
+			log.Errorf("Schedule %s has an invalid task: %v", scheduleData.ID, err)
+		} else {
+			taskData.rank(s.cfg.PriorityAgingInterval)
+			if err := tx.Create(&taskData).Error; err != nil {
+				tx.Rollback()
+				return err
+			}
+			if err := recordTaskEvent(tx, eventTaskCreated, &taskData); err != nil {
+				tx.Rollback()
+				return err
+			}
+			if err := notifyIfPickable(tx, &taskData); err != nil {
+				tx.Rollback()
+				return err
+			}
+			log.Infof("Schedule %s spawned task %s", scheduleData.ID, taskData.ID)
+			scheduleData.LastTaskID = &taskData.ID
+		}
+
+		// Missed fires, e.g. while no server was running, are not caught up.
+		scheduleData.LastRunAt = scheduleData.NextRunAt
+		if err := scheduleData.reschedule(now); err != nil {
+			log.Errorf("Schedule %s cannot be rescheduled, disabling it: %v", scheduleData.ID, err)
+			scheduleData.Enabled = false
+			scheduleData.NextRunAt = nil
+		}
+		if err := tx.Save(scheduleData).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit().Error
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SecretData is a secret value encrypted with AES-GCM under the key from the
+// config. The name of the secret is authenticated along with the value, so a
+// ciphertext cannot be moved over to another secret.
+type SecretData struct {
+	Name       string    `json:"name" gorm:"primaryKey"`
+	Nonce      []byte    `json:"-"`
+	Ciphertext []byte    `json:"-"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+var secretNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,128}$`)
+
+var (
+	errSecretUnavailable = errors.New("secret is unavailable")
+)
+
+// newSecretsCipher creates the cipher of the secrets store from a base64
+// encoded 256 bit key.
+func newSecretsCipher(key string) (cipher.AEAD, error) {
+	rawKey, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("secrets key is not valid base64: %w", err)
+	}
+	if len(rawKey) != 32 {
+		return nil, errors.New("secrets key must be 32 bytes long")
+	}
+	block, err := aes.NewCipher(rawKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (d *SecretData) seal(aead cipher.AEAD, value string) error {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	d.Nonce = nonce
+	d.Ciphertext = aead.Seal(nil, nonce, []byte(value), []byte(d.Name))
+	return nil
+}
+
+func (d *SecretData) open(aead cipher.AEAD) (string, error) {
+	value, err := aead.Open(nil, d.Nonce, d.Ciphertext, []byte(d.Name))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secret %s: %w", d.Name, err)
+	}
+	return string(value), nil
+}
+
+// resolveSecrets decrypts the secrets referenced by the task into the
+// environment variables they are exported as. Secrets that cannot be resolved
+// make it fail with errSecretUnavailable.
+func (s *Server) resolveSecrets(db *gorm.DB, taskData *TaskData) (map[string]string, error) {
+	if len(taskData.Secrets) == 0 {
+		return nil, nil
+	}
+
+	names := make([]string, 0, len(taskData.Secrets))
+	for _, name := range taskData.Secrets {
+		names = append(names, name)
+	}
+	var secretsData []SecretData
+	if err := db.Where("name IN ?", names).Find(&secretsData).Error; err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(secretsData))
+	for i := range secretsData {
+		value, err := secretsData[i].open(s.secrets)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errSecretUnavailable, err)
+		}
+		values[secretsData[i].Name] = value
+	}
+
+	secretEnv := make(map[string]string, len(taskData.Secrets))
+	for envName, secretName := range taskData.Secrets {
+		value, ok := values[secretName]
+		if !ok {
+			return nil, fmt.Errorf("%w: secret %s not found", errSecretUnavailable, secretName)
+		}
+		secretEnv[envName] = value
+	}
+	return secretEnv, nil
+}
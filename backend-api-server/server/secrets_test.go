@@ -0,0 +1,85 @@
+package server
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+var testSecretsKey = base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+
+func TestSecretSealOpen(t *testing.T) {
+	assert := assert.New(t)
+
+	aead, err := newSecretsCipher(testSecretsKey)
+	assert.NoError(err)
+
+	secretData := SecretData{Name: "github-token"}
+	assert.NoError(secretData.seal(aead, "ghp_supersecret"))
+	assert.NotContains(string(secretData.Ciphertext), "ghp_supersecret")
+
+	value, err := secretData.open(aead)
+	assert.NoError(err)
+	assert.Equal("ghp_supersecret", value)
+
+	// Ciphertext moved over to another secret cannot be decrypted
+	movedData := SecretData{Name: "other", Nonce: secretData.Nonce, Ciphertext: secretData.Ciphertext}
+	_, err = movedData.open(aead)
+	assert.Error(err)
+
+	// Invalid keys
+	_, err = newSecretsCipher("not base64!")
+	assert.Error(err)
+	_, err = newSecretsCipher(base64.StdEncoding.EncodeToString([]byte("short")))
+	assert.Error(err)
+}
+
+func TestResolveSecrets(t *testing.T) {
+	assert := assert.New(t)
+
+	// Mock
+	mockDB, mock, err := sqlmock.New()
+	assert.NoError(err)
+	defer func() { _ = mockDB.Close() }()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:                 mockDB,
+		DriverName:           "postgres",
+		PreferSimpleProtocol: true,
+	})
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	assert.NoError(err)
+
+	aead, err := newSecretsCipher(testSecretsKey)
+	assert.NoError(err)
+	server := Server{db: db, secrets: aead}
+
+	secretData := SecretData{Name: "github-token"}
+	assert.NoError(secretData.seal(aead, "ghp_supersecret"))
+	taskData := TaskData{ID: uuid.New(), Secrets: map[string]string{"GITHUB_TOKEN": "github-token"}}
+
+	// Secret is exported in the given variable
+	mock.ExpectQuery(`SELECT \* FROM "secret_data" WHERE name IN \(\$1\)`).
+		WithArgs("github-token").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "nonce", "ciphertext"}).
+			AddRow(secretData.Name, secretData.Nonce, secretData.Ciphertext))
+
+	secretEnv, err := server.resolveSecrets(db, &taskData)
+	assert.NoError(err)
+	assert.Equal(map[string]string{"GITHUB_TOKEN": "ghp_supersecret"}, secretEnv)
+
+	// Secret was deleted
+	mock.ExpectQuery(`SELECT \* FROM "secret_data"`).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "nonce", "ciphertext"}))
+
+	_, err = server.resolveSecrets(db, &taskData)
+	assert.True(errors.Is(err, errSecretUnavailable))
+
+	assert.NoError(mock.ExpectationsWereMet())
+}
@@ -2,33 +2,54 @@ package server
 
 import (
 	"context"
+	"crypto/cipher"
 	"fmt"
 	loggo "log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"backend-api-server/taskpb"
+
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
 
+// serverTimeout limits reading requests and writing responses, handlers
+// streaming large bodies or waiting for tasks lift it.
+const serverTimeout = 15 * time.Second
+
 type Server struct {
 	cfg    *Config
 	router *mux.Router
 	db     *gorm.DB
+	// secrets encrypts the values of the secrets store.
+	secrets   cipher.AEAD
+	artifacts ArtifactStore
+	notifier  *taskNotifier
+	// webhookClient sends the webhook deliveries.
+	webhookClient *http.Client
 }
 
 func New(cfg *Config) *Server {
 	setLogConfigFromEnv()
-	s := Server{cfg: cfg}
+	s := Server{
+		cfg:           cfg,
+		notifier:      newTaskNotifier(),
+		webhookClient: newWebhookClient(cfg.WebhookTimeout, cfg.WebhookAllowPrivateTargets),
+	}
 	s.router = mux.NewRouter()
 	s.setRoutes()
 	s.initDB()
+	s.initSecrets()
+	s.initArtifacts()
 
 	return &s
 }
@@ -36,14 +57,63 @@ func New(cfg *Config) *Server {
 func (s *Server) setRoutes() {
 	s.router.HandleFunc("/tasks", s.handleCreateTask).Methods(http.MethodPost)
 	s.router.HandleFunc("/tasks", s.handleListTasks).Methods(http.MethodGet)
+	s.router.HandleFunc("/tasks:batch", s.handleCreateTaskBatch).Methods(http.MethodPost)
+	s.router.HandleFunc("/tasks:cancel", s.handleBulkTasks("cancel", s.cancelTasks)).Methods(http.MethodPost)
+	s.router.HandleFunc("/tasks:requeue", s.handleBulkTasks("requeue", s.requeueTasks)).Methods(http.MethodPost)
+	s.router.HandleFunc("/tasks:delete", s.handleBulkTasks("delete", s.deleteTasks)).Methods(http.MethodPost)
 	s.router.HandleFunc("/tasks/pick", s.handlePickTask).Methods(http.MethodGet)
 	s.router.HandleFunc("/tasks/{id}", s.handleGetTask).Methods(http.MethodGet)
+	s.router.HandleFunc("/tasks/{id}", s.handleUpdateTask).Methods(http.MethodPatch)
 	s.router.HandleFunc("/tasks/{id}/finish", s.handleFinishTask).Methods(http.MethodPost)
+	s.router.HandleFunc("/tasks/{id}/abort", s.handleAbortTask).Methods(http.MethodPost)
+	s.router.HandleFunc("/tasks/{id}/logs", s.handleGetTaskLogs).Methods(http.MethodGet)
+	s.router.HandleFunc("/tasks/{id}/logs", s.handleIngestTaskLogs).Methods(http.MethodPost)
+	s.router.HandleFunc("/tasks/{id}/bundle", s.handleGetTaskBundle).Methods(http.MethodGet)
+	s.router.HandleFunc("/tasks/{id}/artifacts", s.handleUploadArtifacts).Methods(http.MethodPost)
+	s.router.HandleFunc("/tasks/{id}/artifacts", s.handleListArtifacts).Methods(http.MethodGet)
+	s.router.HandleFunc("/tasks/{id}/artifacts/{name:.+}", s.handleGetArtifact).Methods(http.MethodGet)
+
+	s.router.HandleFunc("/agents", s.handleListAgents).Methods(http.MethodGet)
+	s.router.HandleFunc("/agents/{id}", s.handleRegisterAgent).Methods(http.MethodPut)
+	s.router.HandleFunc("/agents/{id}/heartbeat", s.handleAgentHeartbeat).Methods(http.MethodPost)
+	s.router.HandleFunc("/agents/{id}/socket", s.handleAgentSocket).Methods(http.MethodGet)
+
+	s.router.HandleFunc("/queues", s.handleListQueues).Methods(http.MethodGet)
+	s.router.HandleFunc("/queues/{name}", s.handleUpdateQueue).Methods(http.MethodPatch)
+	s.router.HandleFunc("/queues/{name}/pause", s.handlePauseQueue).Methods(http.MethodPost)
+	s.router.HandleFunc("/queues/{name}/resume", s.handleResumeQueue).Methods(http.MethodPost)
+
+	s.router.HandleFunc("/pipelines", s.handleCreatePipeline).Methods(http.MethodPost)
+	s.router.HandleFunc("/pipelines", s.handleListPipelines).Methods(http.MethodGet)
+	s.router.HandleFunc("/pipelines/{id}", s.handleGetPipeline).Methods(http.MethodGet)
+	s.router.HandleFunc("/pipelines/{id}/abort", s.handleAbortPipeline).Methods(http.MethodPost)
+
+	s.router.HandleFunc("/secrets", s.handleListSecrets).Methods(http.MethodGet)
+	s.router.HandleFunc("/secrets/{name}", s.handlePutSecret).Methods(http.MethodPut)
+	s.router.HandleFunc("/secrets/{name}", s.handleGetSecret).Methods(http.MethodGet)
+	s.router.HandleFunc("/secrets/{name}", s.handleDeleteSecret).Methods(http.MethodDelete)
+
+	s.router.HandleFunc("/schedules", s.handleCreateSchedule).Methods(http.MethodPost)
+	s.router.HandleFunc("/schedules", s.handleListSchedules).Methods(http.MethodGet)
+	s.router.HandleFunc("/schedules/{id}", s.handleGetSchedule).Methods(http.MethodGet)
+	s.router.HandleFunc("/schedules/{id}", s.handleUpdateSchedule).Methods(http.MethodPatch)
+	s.router.HandleFunc("/schedules/{id}", s.handleDeleteSchedule).Methods(http.MethodDelete)
+	s.router.HandleFunc("/webhooks", s.handleCreateWebhook).Methods(http.MethodPost)
+	s.router.HandleFunc("/webhooks", s.handleListWebhooks).Methods(http.MethodGet)
+	s.router.HandleFunc("/webhooks/{id}", s.handleGetWebhook).Methods(http.MethodGet)
+	s.router.HandleFunc("/webhooks/{id}", s.handleDeleteWebhook).Methods(http.MethodDelete)
+	s.router.HandleFunc("/webhooks/{id}/deliveries", s.handleListWebhookDeliveries).Methods(http.MethodGet)
+	s.router.HandleFunc("/webhooks/{id}/deliveries/{delivery_id}/redeliver", s.handleRedeliverWebhook).Methods(http.MethodPost)
+	s.router.HandleFunc("/tasks/{id}/heartbeat", s.handleHeartbeatTask).Methods(http.MethodPost)
+	s.router.HandleFunc("/tasks/{id}/attempts", s.handleListTaskAttempts).Methods(http.MethodGet)
 }
 
-func (s *Server) initDB() {
-	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+func (s *Server) dsn() string {
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
 		s.cfg.DBHost, s.cfg.DBPort, s.cfg.DBUser, s.cfg.DBPassword, s.cfg.DBName)
+}
+
+func (s *Server) initDB() {
 	newLogger := logger.New(
 		loggo.New(os.Stdout, "\r\n", loggo.LstdFlags),
 		logger.Config{
@@ -52,13 +122,43 @@ func (s *Server) initDB() {
 			Colorful:      true,
 		},
 	)
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: newLogger})
+	db, err := gorm.Open(postgres.Open(s.dsn()), &gorm.Config{Logger: newLogger})
 	if err != nil {
 		log.Fatalf("failed to connect database: %v", err)
 	}
-	db.AutoMigrate(&TaskData{})
+	db.AutoMigrate(&TaskData{}, &TaskAttempt{}, &ScheduleData{}, &TaskDependency{}, &PipelineData{}, &SecretData{}, &TaskLogChunk{}, &ArtifactData{}, &AgentData{}, &QueueData{}, &IdempotencyKey{}, &WebhookData{}, &WebhookEvent{}, &WebhookDelivery{})
 	log.Info("Postgres connection successful")
 	s.db = db
+	if err := s.rankQueuedTasks(); err != nil {
+		log.Fatalf("failed to rank queued tasks: %v", err)
+	}
+}
+
+func (s *Server) initSecrets() {
+	secrets, err := newSecretsCipher(s.cfg.SecretsKey)
+	if err != nil {
+		log.Fatalf("failed to set up secrets store: %v", err)
+	}
+	s.secrets = secrets
+}
+
+func (s *Server) initArtifacts() {
+	switch s.cfg.ArtifactStore {
+	case artifactStoreLocal:
+		store, err := newLocalArtifactStore(s.cfg.ArtifactDir)
+		if err != nil {
+			log.Fatalf("failed to set up artifact store: %v", err)
+		}
+		s.artifacts = store
+	case artifactStoreS3:
+		if s.cfg.S3Bucket == "" {
+			log.Fatal("S3_BUCKET is required by the s3 artifact store")
+		}
+		s.artifacts = newS3ArtifactStore(s.cfg)
+	default:
+		log.Fatalf("unknown artifact store: %q", s.cfg.ArtifactStore)
+	}
+	log.Infof("Storing artifacts in the %s artifact store", s.cfg.ArtifactStore)
 }
 
 func setLogConfigFromEnv() {
@@ -73,10 +173,19 @@ func (s *Server) Run() {
 	srv := &http.Server{
 		Addr:         ":" + s.cfg.ServerPort,
 		Handler:      s.router,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
+		ReadTimeout:  serverTimeout,
+		WriteTimeout: serverTimeout,
 	}
 
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	go s.runReaper(bgCtx)
+	go s.runScheduler(bgCtx)
+	go s.runWebhookDispatcher(bgCtx)
+	go s.notifier.listen(bgCtx, s.dsn())
+
+	grpcServer := s.startGRPC()
+
 	go func() {
 		log.Info("Starting the server on :" + s.cfg.ServerPort)
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -88,6 +197,7 @@ func (s *Server) Run() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 	log.Info("Shutting down the server...")
+	stopBackground()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -95,5 +205,44 @@ func (s *Server) Run() {
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Fatalf("Server shutdown error: %v", err)
 	}
+	if grpcServer != nil {
+		stopGRPC(ctx, grpcServer)
+	}
 	log.Info("Server gracefully stopped")
 }
+
+// startGRPC serves the gRPC API next to the REST API.
+func (s *Server) startGRPC() *grpc.Server {
+	if s.cfg.GRPCPort == "" {
+		return nil
+	}
+	listener, err := net.Listen("tcp", ":"+s.cfg.GRPCPort)
+	if err != nil {
+		log.Fatalf("failed to listen for gRPC: %v", err)
+	}
+	grpcServer := grpc.NewServer()
+	taskpb.RegisterTaskServiceServer(grpcServer, &taskService{s: s})
+
+	go func() {
+		log.Info("Starting the gRPC server on :" + s.cfg.GRPCPort)
+		if err := grpcServer.Serve(listener); err != nil {
+			log.Fatal("gRPC Serve error: ", err)
+		}
+	}()
+	return grpcServer
+}
+
+// stopGRPC waits for the running calls until ctx is done, streams that are
+// still open then are cut off.
+func stopGRPC(ctx context.Context, grpcServer *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		grpcServer.Stop()
+	}
+}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// TaskLogChunk is a piece of output streamed by the executor agent while the
+// task runs. Seq numbers the chunks of a task across all of its attempts, it
+// is the offset users resume following the logs from. The chunks are the only
+// copy of the output, tasks and their attempts refer to a range of them.
+type TaskLogChunk struct {
+	TaskID     uuid.UUID `json:"-" gorm:"type:uuid;primaryKey"`
+	Seq        int       `json:"seq" gorm:"primaryKey;autoIncrement:false"`
+	Attempt    int       `json:"attempt"`
+	OutputLine `gorm:"embedded"`
+}
+
+const logChunksPageSize = 500
+
+// appendLogChunks stores chunks at the end of the logs of the task and wakes
+// up its followers. The task row has to be locked by the transaction so that
+// sequence numbers are not handed out twice.
+func appendLogChunks(tx *gorm.DB, taskData *TaskData, chunks []TaskLogChunk) error {
+	if len(chunks) == 0 {
+		return nil
+	}
+	var lastSeq int
+	err := tx.Model(&TaskLogChunk{}).
+		Where("task_id = ?", taskData.ID).
+		Select("COALESCE(MAX(seq), 0)").
+		Scan(&lastSeq).Error
+	if err != nil {
+		return err
+	}
+	// The chunks of earlier attempts may have been purged, numbers are
+	// not handed out again.
+	lastSeq = max(lastSeq, taskData.LogTo)
+	for i := range chunks {
+		chunks[i].TaskID = taskData.ID
+		chunks[i].Seq = lastSeq + i + 1
+		chunks[i].Attempt = taskData.Attempt
+	}
+	if err := tx.Create(&chunks).Error; err != nil {
+		return err
+	}
+	return notifyTaskLogs(tx, taskData.ID)
+}
+
+// logChunksAfter returns the next page of chunks following the given offset.
+func logChunksAfter(db *gorm.DB, taskID uuid.UUID, offset int) ([]TaskLogChunk, error) {
+	chunks := []TaskLogChunk{}
+	err := db.
+		Where("task_id = ? AND seq > ?", taskID, offset).
+		Order("seq ASC").
+		Limit(logChunksPageSize).
+		Find(&chunks).Error
+	return chunks, err
+}
+
+// completeLogChunks makes the log chunks of the current attempt of the task
+// hold the output reported with its result, which is returned. Only the
+// lines the agent did not stream are added. If the streamed chunks are not
+// the beginning of the output, e.g. because a batch of them was lost, they
+// are replaced by it. A result without more output than what was streamed
+// leaves the chunks as they are.
+func completeLogChunks(tx *gorm.DB, taskData *TaskData, output []OutputLine) ([]OutputLine, error) {
+	var streamed []TaskLogChunk
+	err := tx.
+		Where("task_id = ? AND seq > ?", taskData.ID, taskData.LogTo).
+		Order("seq ASC").
+		Find(&streamed).Error
+	if err != nil {
+		return nil, err
+	}
+	lines := make([]OutputLine, len(streamed))
+	for i, chunk := range streamed {
+		lines[i] = chunk.OutputLine
+	}
+	if len(output) <= len(lines) {
+		return lines, nil
+	}
+
+	missing := output[len(lines):]
+	for i, line := range lines {
+		if line.Stream != output[i].Stream || line.Data != output[i].Data {
+			err := tx.
+				Where("task_id = ? AND seq > ?", taskData.ID, taskData.LogTo).
+				Delete(&TaskLogChunk{}).Error
+			if err != nil {
+				return nil, err
+			}
+			missing = output
+			break
+		}
+	}
+	chunks := make([]TaskLogChunk, len(missing))
+	for i, line := range missing {
+		chunks[i].OutputLine = line
+	}
+	if err := appendLogChunks(tx, taskData, chunks); err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+// endAttemptLogs assigns the log chunks written since the previous attempt
+// ended to the attempt, and makes them the output of the task.
+func endAttemptLogs(tx *gorm.DB, taskData *TaskData, attempt *TaskAttempt) error {
+	var lastSeq int
+	err := tx.Model(&TaskLogChunk{}).
+		Where("task_id = ?", taskData.ID).
+		Select("COALESCE(MAX(seq), 0)").
+		Scan(&lastSeq).Error
+	if err != nil {
+		return err
+	}
+	attempt.LogFrom, attempt.LogTo = taskData.LogTo, max(lastSeq, taskData.LogTo)
+	taskData.LogFrom, taskData.LogTo = attempt.LogFrom, attempt.LogTo
+	return nil
+}
+
+// purgeTaskLogs deletes the log chunks of the tasks completed before the
+// cutoff. Their output is gone with them.
+func (s *Server) purgeTaskLogs(cutoff time.Time) error {
+	return s.db.
+		Where("task_id IN (SELECT id FROM task_data WHERE status IN ? AND finished_at < ?)", completedStatuses, cutoff).
+		Delete(&TaskLogChunk{}).Error
+}
+
+// legacyOutput returns the output fields of a task or an attempt completed
+// before the output was kept in log chunks, from the output stored in its own
+// columns. ok is false if there is none.
+func legacyOutput(logTo int, output []OutputLine, stdout, stderr *string) (lines []OutputLine, stdoutView, stderrView *string, ok bool) {
+	if logTo > 0 || (output == nil && stdout == nil && stderr == nil) {
+		return nil, nil, nil, false
+	}
+	if output == nil {
+		// Stored before the output lines were, only the streams are known.
+		return nil, stdout, stderr, true
+	}
+	return output, outputView(output, streamStdout), outputView(output, streamStderr), true
+}
+
+// loadOutput fills the output of the given completed tasks from the log
+// chunks of their last attempt, or from their own columns if they completed
+// before the upgrade.
+func loadOutput(db *gorm.DB, tasksData []TaskData) error {
+	var conditions []string
+	var args []interface{}
+	byID := map[uuid.UUID]*TaskData{}
+	for i := range tasksData {
+		taskData := &tasksData[i]
+		if !taskData.completed() {
+			continue
+		}
+		output, stdout, stderr, ok := legacyOutput(taskData.LogTo, taskData.LegacyOutput, taskData.LegacyStdout, taskData.LegacyStderr)
+		if ok {
+			taskData.Output, taskData.Stdout, taskData.Stderr = output, stdout, stderr
+			continue
+		}
+		if taskData.Attempt == 0 {
+			continue
+		}
+		taskData.Output = []OutputLine{}
+		byID[taskData.ID] = taskData
+		if taskData.LogTo > taskData.LogFrom {
+			conditions = append(conditions, "(task_id = ? AND seq > ? AND seq <= ?)")
+			args = append(args, taskData.ID, taskData.LogFrom, taskData.LogTo)
+		}
+	}
+
+	if len(conditions) > 0 {
+		var chunks []TaskLogChunk
+		err := db.
+			Where(strings.Join(conditions, " OR "), args...).
+			Order("task_id, seq ASC").
+			Find(&chunks).Error
+		if err != nil {
+			return err
+		}
+		for _, chunk := range chunks {
+			taskData := byID[chunk.TaskID]
+			taskData.Output = append(taskData.Output, chunk.OutputLine)
+		}
+	}
+	for _, taskData := range byID {
+		taskData.Stdout = outputView(taskData.Output, streamStdout)
+		taskData.Stderr = outputView(taskData.Output, streamStderr)
+	}
+	return nil
+}
+
+// loadAttemptOutput fills the output of the attempts of a task from its log
+// chunks, or from their own columns for attempts made before the upgrade.
+func loadAttemptOutput(db *gorm.DB, taskID uuid.UUID, attempts []TaskAttempt) error {
+	if len(attempts) == 0 {
+		return nil
+	}
+	from, to := attempts[0].LogFrom, attempts[0].LogTo
+	for _, attempt := range attempts {
+		from, to = min(from, attempt.LogFrom), max(to, attempt.LogTo)
+	}
+	var chunks []TaskLogChunk
+	if to > from {
+		err := db.
+			Where("task_id = ? AND seq > ? AND seq <= ?", taskID, from, to).
+			Order("seq ASC").
+			Find(&chunks).Error
+		if err != nil {
+			return err
+		}
+	}
+	for i := range attempts {
+		attempt := &attempts[i]
+		output, stdout, stderr, ok := legacyOutput(attempt.LogTo, attempt.LegacyOutput, attempt.LegacyStdout, attempt.LegacyStderr)
+		if ok {
+			attempt.Output, attempt.Stdout, attempt.Stderr = output, stdout, stderr
+			continue
+		}
+		attempt.Output = []OutputLine{}
+		for _, chunk := range chunks {
+			if chunk.Seq > attempt.LogFrom && chunk.Seq <= attempt.LogTo {
+				attempt.Output = append(attempt.Output, chunk.OutputLine)
+			}
+		}
+		attempt.Stdout = outputView(attempt.Output, streamStdout)
+		attempt.Stderr = outputView(attempt.Output, streamStderr)
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package server
+
+import (
+	"database/sql/driver"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestCompleteLogChunks(t *testing.T) {
+	assert := assert.New(t)
+
+	// Mock
+	mockDB, mock, err := sqlmock.New()
+	assert.NoError(err)
+	defer func() { _ = mockDB.Close() }()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:                 mockDB,
+		DriverName:           "postgres",
+		PreferSimpleProtocol: true,
+	})
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	assert.NoError(err)
+
+	streamedQuery := regexp.QuoteMeta(`SELECT * FROM "task_log_chunks" WHERE task_id = $1 AND seq > $2 ORDER BY seq ASC`)
+	lastSeqQuery := regexp.QuoteMeta(`SELECT COALESCE(MAX(seq), 0) FROM "task_log_chunks" WHERE task_id = $1`)
+	insertQuery := regexp.QuoteMeta(`INSERT INTO "task_log_chunks"`)
+	notifyQuery := regexp.QuoteMeta(`SELECT pg_notify($1, $2)`)
+	chunkColumns := []string{"task_id", "seq", "attempt", "stream", "data", "time"}
+	now := time.Now()
+	taskData := TaskData{ID: uuid.New(), Status: statusInProgress, Attempt: 2, LogTo: 3}
+	output := []OutputLine{
+		{Stream: streamStdout, Data: "one\n", Time: now},
+		{Stream: streamStderr, Data: "two\n", Time: now},
+		{Stream: streamStdout, Data: "three\n", Time: now},
+	}
+
+	// Streamed chunks begin the output - only the missing lines are added
+	mock.ExpectQuery(streamedQuery).
+		WithArgs(taskData.ID, 3).
+		WillReturnRows(sqlmock.NewRows(chunkColumns).
+			AddRow(taskData.ID, 4, 2, streamStdout, "one\n", now))
+	mock.ExpectQuery(lastSeqQuery).
+		WithArgs(taskData.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"coalesce"}).AddRow(4))
+	mock.ExpectBegin()
+	mock.ExpectExec(insertQuery).
+		WithArgs(
+			taskData.ID, 5, 2, streamStderr, "two\n", sqlmock.AnyArg(),
+			taskData.ID, 6, 2, streamStdout, "three\n", sqlmock.AnyArg(),
+		).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+	mock.ExpectExec(notifyQuery).
+		WithArgs(taskLogsChannel, taskData.ID.String()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	lines, err := completeLogChunks(db, &taskData, output)
+	assert.NoError(err)
+	assert.Equal(output, lines)
+
+	// Streamed chunks with a gap - replaced by the output
+	mock.ExpectQuery(streamedQuery).
+		WithArgs(taskData.ID, 3).
+		WillReturnRows(sqlmock.NewRows(chunkColumns).
+			AddRow(taskData.ID, 4, 2, streamStdout, "three\n", now))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "task_log_chunks" WHERE task_id = $1 AND seq > $2`)).
+		WithArgs(taskData.ID, 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery(lastSeqQuery).
+		WithArgs(taskData.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"coalesce"}).AddRow(3))
+	mock.ExpectBegin()
+	mock.ExpectExec(insertQuery).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectCommit()
+	mock.ExpectExec(notifyQuery).
+		WithArgs(taskLogsChannel, taskData.ID.String()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	lines, err = completeLogChunks(db, &taskData, output)
+	assert.NoError(err)
+	assert.Equal(output, lines)
+
+	// Result without output - the streamed chunks are kept
+	mock.ExpectQuery(streamedQuery).
+		WithArgs(taskData.ID, 3).
+		WillReturnRows(sqlmock.NewRows(chunkColumns).
+			AddRow(taskData.ID, 4, 2, streamStdout, "one\n", now))
+
+	lines, err = completeLogChunks(db, &taskData, []OutputLine{})
+	assert.NoError(err)
+	assert.Len(lines, 1)
+
+	// Attempt ends with the last chunk
+	mock.ExpectQuery(lastSeqQuery).
+		WithArgs(taskData.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"coalesce"}).AddRow(6))
+
+	attempt := taskData.newAttempt(now)
+	assert.NoError(endAttemptLogs(db, &taskData, &attempt))
+	assert.Equal(3, attempt.LogFrom)
+	assert.Equal(6, attempt.LogTo)
+	assert.Equal(6, taskData.LogTo)
+
+	assert.NoError(mock.ExpectationsWereMet())
+}
+
+func TestLoadAttemptOutput(t *testing.T) {
+	assert := assert.New(t)
+
+	// Mock
+	mockDB, mock, err := sqlmock.New()
+	assert.NoError(err)
+	defer func() { _ = mockDB.Close() }()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:                 mockDB,
+		DriverName:           "postgres",
+		PreferSimpleProtocol: true,
+	})
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	assert.NoError(err)
+
+	taskID := uuid.New()
+	now := time.Now()
+	legacyStdout := "before the upgrade\n"
+	attempts := []TaskAttempt{
+		{Number: 0, LegacyStdout: &legacyStdout},
+		{Number: 1, LogFrom: 0, LogTo: 2},
+		{Number: 2, LogFrom: 2, LogTo: 2},
+		{Number: 3, LogFrom: 2, LogTo: 3},
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "task_log_chunks" WHERE task_id = $1 AND seq > $2 AND seq <= $3 ORDER BY seq ASC`)).
+		WithArgs(taskID, 0, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"task_id", "seq", "attempt", "stream", "data", "time"}).
+			AddRow(taskID, 1, 1, streamStdout, "first\n", now).
+			AddRow(taskID, 2, 1, streamStderr, "failed\n", now).
+			AddRow(taskID, 3, 3, streamStdout, "third\n", now))
+
+	// Every attempt gets its own range of chunks
+	assert.NoError(loadAttemptOutput(db, taskID, attempts))
+	assert.Equal("first\n", *attempts[1].Stdout)
+	assert.Equal("failed\n", *attempts[1].Stderr)
+	assert.Empty(attempts[2].Output)
+	assert.Equal("", *attempts[2].Stdout)
+	assert.Equal("third\n", *attempts[3].Stdout)
+
+	// Attempt made before the upgrade keeps the output stored with it
+	assert.Equal(legacyStdout, *attempts[0].Stdout)
+	assert.Nil(attempts[0].Output)
+
+	assert.NoError(mock.ExpectationsWereMet())
+}
+
+func TestPurgeTaskLogs(t *testing.T) {
+	assert := assert.New(t)
+
+	// Mock
+	mockDB, mock, err := sqlmock.New()
+	assert.NoError(err)
+	defer func() { _ = mockDB.Close() }()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:                 mockDB,
+		DriverName:           "postgres",
+		PreferSimpleProtocol: true,
+	})
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	assert.NoError(err)
+
+	server := Server{db: db}
+	cutoff := time.Now().Add(-time.Hour)
+
+	// Only the chunks of tasks completed before the cutoff are deleted
+	args := []driver.Value{}
+	for _, status := range completedStatuses {
+		args = append(args, status)
+	}
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "task_log_chunks" WHERE task_id IN (SELECT id FROM task_data WHERE status IN ($1,$2,$3,$4,$5) AND finished_at < $6)`)).
+		WithArgs(append(args, cutoff)...).
+		WillReturnResult(sqlmock.NewResult(0, 12))
+	mock.ExpectCommit()
+
+	assert.NoError(server.purgeTaskLogs(cutoff))
+
+	assert.NoError(mock.ExpectationsWereMet())
+}
+
+func TestLoadOutput(t *testing.T) {
+	assert := assert.New(t)
+
+	// Mock
+	mockDB, mock, err := sqlmock.New()
+	assert.NoError(err)
+	defer func() { _ = mockDB.Close() }()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:                 mockDB,
+		DriverName:           "postgres",
+		PreferSimpleProtocol: true,
+	})
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	assert.NoError(err)
+
+	now := time.Now()
+	exitCode := 0
+	legacyStdout, legacyStderr := "built\n", "warning\n"
+	tasksData := []TaskData{
+		// Completed before the upgrade, with output lines
+		{ID: uuid.New(), Status: statusFinished, Attempt: 1, ExitCode: &exitCode, LegacyOutput: []OutputLine{
+			{Stream: streamStdout, Data: "built\n", Time: now},
+			{Stream: streamStderr, Data: "warning\n", Time: now},
+		}},
+		// Completed before the output lines were stored
+		{ID: uuid.New(), Status: statusFinished, ExitCode: &exitCode, LegacyStdout: &legacyStdout, LegacyStderr: &legacyStderr},
+		// Completed after the upgrade
+		{ID: uuid.New(), Status: statusFinished, Attempt: 1, ExitCode: &exitCode, LogFrom: 0, LogTo: 1},
+		// Still queued
+		{ID: uuid.New(), Status: statusQueued},
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "task_log_chunks" WHERE (task_id = $1 AND seq > $2 AND seq <= $3) ORDER BY task_id, seq ASC`)).
+		WithArgs(tasksData[2].ID, 0, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"task_id", "seq", "attempt", "stream", "data", "time"}).
+			AddRow(tasksData[2].ID, 1, 1, streamStdout, "streamed\n", now))
+
+	assert.NoError(loadOutput(db, tasksData))
+	assert.Len(tasksData[0].Output, 2)
+	assert.Equal("built\n", *tasksData[0].Stdout)
+	assert.Equal("warning\n", *tasksData[0].Stderr)
+	assert.Nil(tasksData[1].Output)
+	assert.Equal(legacyStdout, *tasksData[1].Stdout)
+	assert.Equal(legacyStderr, *tasksData[1].Stderr)
+	assert.Equal("streamed\n", *tasksData[2].Stdout)
+	assert.Nil(tasksData[3].Output)
+	assert.Nil(tasksData[3].Stdout)
+
+	// Requeued task does not fall back to the output of its earlier run
+	tasksData[1].requeue()
+	assert.Nil(tasksData[1].LegacyStdout)
+	assert.Nil(tasksData[1].LegacyStderr)
+
+	assert.NoError(mock.ExpectationsWereMet())
+}
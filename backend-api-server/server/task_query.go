@@ -0,0 +1,297 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultTaskPageSize = 100
+	maxTaskPageSize     = 1000
+	defaultTaskSort     = "date"
+)
+
+var errInvalidTaskQuery = errors.New("invalid task query")
+
+// TaskFilter selects tasks by their state, it is shared by the listing and
+// the bulk operations on tasks.
+type TaskFilter struct {
+	Statuses      []string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	ExitCode      *int
+	// Command matches tasks whose command contains it. No index serves a
+	// substring match, it scans the tasks left by the other filters.
+	Command string
+}
+
+// TaskQuery selects a page of tasks. Pages are read with keyset pagination,
+// the cursor holds the sort key and id of the last task of the previous page,
+// so that deep pages cost as little as the first one.
+type TaskQuery struct {
+	TaskFilter
+	// Sort is one of the taskSortColumns, prefixed with "-" to sort in
+	// descending order. Ties are broken by the id of the tasks.
+	Sort   string
+	Limit  int
+	Cursor string
+	// Fields are the fields of the tasks to load and return, all of them if
+	// empty.
+	Fields []string
+}
+
+// TaskPage is a page of tasks, NextCursor is empty on the last page.
+type TaskPage struct {
+	Tasks      []Task
+	NextCursor string
+}
+
+// taskSortColumns are the columns tasks can be sorted by.
+var taskSortColumns = []string{"date", "priority", "command"}
+
+// taskFieldColumns maps the fields of a task to the columns they are loaded
+// from. depends_on is loaded from the dependencies of the tasks instead, the
+// output fields from the log chunks the columns point to, or the output
+// columns of tasks completed before the output was kept in log chunks.
+var taskFieldColumns = map[string][]string{
+	"id":              {"id"},
+	"command":         {"command"},
+	"date":            {"date"},
+	"started_at":      {"started_at"},
+	"finished_at":     {"finished_at"},
+	"status":          {"status"},
+	"stdout":          {"status", "attempt", "log_from", "log_to", "stdout", "stderr", "output"},
+	"stderr":          {"status", "attempt", "log_from", "log_to", "stdout", "stderr", "output"},
+	"output":          {"status", "attempt", "log_from", "log_to", "stdout", "stderr", "output"},
+	"exit_code":       {"exit_code"},
+	"status_reason":   {"status_reason"},
+	"max_attempts":    {"max_attempts"},
+	"attempt":         {"attempt"},
+	"backoff":         {"backoff_initial_seconds", "backoff_multiplier", "backoff_max_seconds"},
+	"not_before":      {"not_before"},
+	"run_at":          {"run_at"},
+	"priority":        {"priority"},
+	"schedule_id":     {"schedule_id"},
+	"scheduled_for":   {"scheduled_for"},
+	"depends_on":      nil,
+	"pipeline_id":     {"pipeline_id"},
+	"pipeline_step":   {"pipeline_step"},
+	"timeout_seconds": {"timeout_seconds"},
+	"env":             {"env"},
+	"workdir":         {"workdir"},
+	"secrets":         {"secrets"},
+	"artifacts":       {"artifacts"},
+	"bundle_size":     {"bundle_size"},
+	"agent_id":        {"agent_id"},
+	"labels":          {"labels"},
+	"queue":           {"queue"},
+}
+
+// taskCursor points behind the last task of a page.
+type taskCursor struct {
+	Sort  string          `json:"sort"`
+	Value json.RawMessage `json:"value"`
+	ID    uuid.UUID       `json:"id"`
+}
+
+func (q *TaskQuery) validate() error {
+	if q.Sort == "" {
+		q.Sort = defaultTaskSort
+	}
+	if !slices.Contains(taskSortColumns, strings.TrimPrefix(q.Sort, "-")) {
+		return fmt.Errorf("%w: sort must be one of %s, optionally prefixed with -",
+			errInvalidTaskQuery, strings.Join(taskSortColumns, ", "))
+	}
+	if q.Limit == 0 {
+		q.Limit = defaultTaskPageSize
+	}
+	if q.Limit < 0 || q.Limit > maxTaskPageSize {
+		return fmt.Errorf("%w: limit must be between 1 and %d", errInvalidTaskQuery, maxTaskPageSize)
+	}
+	for _, field := range q.Fields {
+		if _, ok := taskFieldColumns[field]; !ok {
+			return fmt.Errorf("%w: unknown field %q", errInvalidTaskQuery, field)
+		}
+	}
+	return q.TaskFilter.validate()
+}
+
+func (f *TaskFilter) validate() error {
+	for _, status := range f.Statuses {
+		if !validStatus(status) {
+			return fmt.Errorf("%w: unknown status %q", errInvalidTaskQuery, status)
+		}
+	}
+	return nil
+}
+
+// empty reports whether the filter matches all tasks.
+func (f *TaskFilter) empty() bool {
+	return len(f.Statuses) == 0 && f.CreatedAfter == nil && f.CreatedBefore == nil &&
+		f.ExitCode == nil && f.Command == ""
+}
+
+// apply adds the conditions of the filter to the query of the tasks.
+func (f *TaskFilter) apply(db *gorm.DB) *gorm.DB {
+	if len(f.Statuses) > 0 {
+		db = db.Where("status IN ?", f.Statuses)
+	}
+	if f.CreatedAfter != nil {
+		db = db.Where("date >= ?", *f.CreatedAfter)
+	}
+	if f.CreatedBefore != nil {
+		db = db.Where("date < ?", *f.CreatedBefore)
+	}
+	if f.ExitCode != nil {
+		db = db.Where("exit_code = ?", *f.ExitCode)
+	}
+	if f.Command != "" {
+		db = db.Where("command LIKE ?", "%"+escapeLike(f.Command)+"%")
+	}
+	return db
+}
+
+// columns returns the columns to load for the requested fields. The id and
+// the sort column are always loaded, as the cursor is built from them.
+func (q *TaskQuery) columns() []string {
+	if len(q.Fields) == 0 {
+		return nil
+	}
+	columns := []string{"id", strings.TrimPrefix(q.Sort, "-")}
+	for _, field := range q.Fields {
+		columns = append(columns, taskFieldColumns[field]...)
+	}
+	slices.Sort(columns)
+	return slices.Compact(columns)
+}
+
+func (q *TaskQuery) wantsField(field string) bool {
+	return len(q.Fields) == 0 || slices.Contains(q.Fields, field)
+}
+
+// apply adds the filters, the sort order and the position of the cursor to
+// the query of the tasks.
+func (q *TaskQuery) apply(db *gorm.DB) (*gorm.DB, error) {
+	db = q.TaskFilter.apply(db)
+
+	column, direction, comparison := q.Sort, "ASC", ">"
+	if strings.HasPrefix(q.Sort, "-") {
+		column, direction, comparison = q.Sort[1:], "DESC", "<"
+	}
+	if q.Cursor != "" {
+		cursor, err := decodeTaskCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		if cursor.Sort != q.Sort {
+			return nil, fmt.Errorf("%w: cursor was issued for sort %q", errInvalidTaskQuery, cursor.Sort)
+		}
+		value, err := cursorValue(column, cursor.Value)
+		if err != nil {
+			return nil, err
+		}
+		db = db.Where(fmt.Sprintf("(%s, id) %s (?, ?)", column, comparison), value, cursor.ID)
+	}
+	return db.Order(column + " " + direction).Order("id " + direction), nil
+}
+
+// nextCursor returns the cursor of the page following the given task.
+func (q *TaskQuery) nextCursor(last TaskData) (string, error) {
+	var value any
+	switch strings.TrimPrefix(q.Sort, "-") {
+	case "date":
+		value = last.Date
+	case "priority":
+		value = last.Priority
+	case "command":
+		value = last.Command
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	cursor, err := json.Marshal(taskCursor{Sort: q.Sort, Value: encoded, ID: last.ID})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(cursor), nil
+}
+
+func decodeTaskCursor(s string) (taskCursor, error) {
+	var cursor taskCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(data, &cursor)
+	}
+	if err != nil {
+		return taskCursor{}, fmt.Errorf("%w: malformed cursor", errInvalidTaskQuery)
+	}
+	return cursor, nil
+}
+
+func cursorValue(column string, data json.RawMessage) (any, error) {
+	var err error
+	var value any
+	switch column {
+	case "date":
+		var date time.Time
+		err = json.Unmarshal(data, &date)
+		value = date
+	case "priority":
+		var priority int
+		err = json.Unmarshal(data, &priority)
+		value = priority
+	case "command":
+		var command string
+		err = json.Unmarshal(data, &command)
+		value = command
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", errInvalidTaskQuery)
+	}
+	return value, nil
+}
+
+// projectTask returns the requested fields of the task as they are encoded
+// in JSON.
+func projectTask(task Task, fields []string) (map[string]json.RawMessage, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(task); err != nil {
+		return nil, err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(buf.Bytes(), &all); err != nil {
+		return nil, err
+	}
+	projected := make(map[string]json.RawMessage, len(fields))
+	for _, field := range fields {
+		projected[field] = all[field]
+	}
+	return projected, nil
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// escapeLike escapes the wildcards of a LIKE pattern.
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+func validStatus(status string) bool {
+	switch status {
+	case statusQueued, statusInProgress, statusCancelling:
+		return true
+	}
+	return (&TaskData{Status: status}).completed()
+}
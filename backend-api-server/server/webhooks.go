@@ -0,0 +1,581 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Types of the task events webhooks subscribe to. A task fails once it has no
+// attempts left, failed attempts that are retried have no event.
+const (
+	eventTaskCreated   = "task.created"
+	eventTaskPicked    = "task.picked"
+	eventTaskFinished  = "task.finished"
+	eventTaskFailed    = "task.failed"
+	eventTaskCancelled = "task.cancelled"
+	eventTaskSkipped   = "task.skipped"
+	eventTaskRequeued  = "task.requeued"
+	eventTaskDeleted   = "task.deleted"
+)
+
+var webhookEventTypes = []string{
+	eventTaskCreated, eventTaskPicked, eventTaskFinished, eventTaskFailed,
+	eventTaskCancelled, eventTaskSkipped, eventTaskRequeued, eventTaskDeleted,
+}
+
+const (
+	deliveryPending   = "pending"
+	deliverySucceeded = "succeeded"
+	deliveryFailed    = "failed"
+)
+
+// Headers of a delivery. The signature is the hex encoded HMAC-SHA256 of the
+// timestamp, a dot and the body, keyed with the secret of the webhook.
+const (
+	webhookEventHeader     = "X-Webhook-Event"
+	webhookDeliveryHeader  = "X-Webhook-Delivery"
+	webhookTimestampHeader = "X-Webhook-Timestamp"
+	webhookSignatureHeader = "X-Webhook-Signature"
+)
+
+const webhookBatchSize = 100
+
+const webhookPurgeInterval = time.Hour
+
+// webhookBackoff spaces the attempts of a failing delivery.
+var webhookBackoff = BackoffPolicy{InitialSeconds: 10, Multiplier: 2, MaxSeconds: 3600}
+
+// WebhookData is a subscription to task events. Events lists the types of
+// events it receives, all of them if empty. The signing secret is encrypted
+// like the secrets store, with the id of the webhook authenticated along
+// with it.
+type WebhookData struct {
+	ID               uuid.UUID `gorm:"type:uuid;primaryKey"`
+	URL              string
+	SecretNonce      []byte
+	SecretCiphertext []byte
+	Events           []string  `gorm:"serializer:json"`
+	Date             time.Time `gorm:"autoCreateTime"`
+}
+
+// WebhookEvent is an entry of the outbox of task events. It is saved in the
+// transaction that changes the task, so that an event is recorded if and only
+// if the change is. The dispatcher turns it into a delivery per subscribed
+// webhook.
+type WebhookEvent struct {
+	ID     uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Type   string
+	TaskID uuid.UUID `gorm:"type:uuid;index"`
+	// Task is the state of the task when the event happened.
+	Task         Task       `gorm:"serializer:json"`
+	CreatedAt    time.Time  `gorm:"index"`
+	DispatchedAt *time.Time `gorm:"index"`
+}
+
+// WebhookDelivery is the log of sending an event to a webhook. Pending
+// deliveries are attempted at NextAttemptAt until they succeed or run out of
+// attempts.
+type WebhookDelivery struct {
+	ID             uuid.UUID  `json:"id" gorm:"type:uuid;primaryKey"`
+	WebhookID      uuid.UUID  `json:"webhook_id" gorm:"type:uuid;index"`
+	EventID        uuid.UUID  `json:"event_id" gorm:"type:uuid"`
+	EventType      string     `json:"event_type"`
+	TaskID         uuid.UUID  `json:"task_id" gorm:"type:uuid"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  *time.Time `json:"next_attempt_at" gorm:"index"`
+	LastAttemptAt  *time.Time `json:"last_attempt_at"`
+	ResponseStatus *int       `json:"response_status"`
+	Error          *string    `json:"error"`
+	CreatedAt      time.Time  `json:"created_at"`
+	// RedeliveryOf is the delivery a manual redelivery repeats.
+	RedeliveryOf *uuid.UUID `json:"redelivery_of" gorm:"type:uuid"`
+}
+
+// WebhookPayload is the body of a delivery. Its id is the one of the event,
+// redeliveries of the same event carry the same id. The output of the task
+// is left out, it can be fetched from the API.
+type WebhookPayload struct {
+	ID        uuid.UUID `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Task      Task      `json:"task"`
+}
+
+func (d *WebhookData) toWebhook() Webhook {
+	return Webhook{
+		ID:     d.ID,
+		URL:    d.URL,
+		Events: d.Events,
+		Date:   d.Date,
+	}
+}
+
+func (d *WebhookData) sealSecret(aead cipher.AEAD, secret string) error {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	d.SecretNonce = nonce
+	d.SecretCiphertext = aead.Seal(nil, nonce, []byte(secret), d.ID[:])
+	return nil
+}
+
+func (d *WebhookData) openSecret(aead cipher.AEAD) (string, error) {
+	secret, err := aead.Open(nil, d.SecretNonce, d.SecretCiphertext, d.ID[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt the secret of webhook %s: %w", d.ID, err)
+	}
+	return string(secret), nil
+}
+
+func (d *WebhookData) subscribes(eventType string) bool {
+	return len(d.Events) == 0 || slices.Contains(d.Events, eventType)
+}
+
+func newWebhookEvent(eventType string, taskData *TaskData) WebhookEvent {
+	task := taskData.toTask()
+	task.Stdout = nil
+	task.Stderr = nil
+	task.Output = nil
+	return WebhookEvent{
+		ID:        uuid.New(),
+		Type:      eventType,
+		TaskID:    taskData.ID,
+		Task:      task,
+		CreatedAt: time.Now(),
+	}
+}
+
+// recordTaskEvent saves the event of the task to the outbox within the
+// transaction changing the task, and wakes up the watchers of the task. New
+// tasks have none yet.
+func recordTaskEvent(tx *gorm.DB, eventType string, taskData *TaskData) error {
+	event := newWebhookEvent(eventType, taskData)
+	if err := tx.Create(&event).Error; err != nil {
+		return err
+	}
+	if eventType == eventTaskCreated {
+		return nil
+	}
+	return notifyTaskLogs(tx, taskData.ID)
+}
+
+// recordTaskCompletion records the event of a task that has finished, failed
+// for good or been cancelled. Tasks that are retried or still cancelling have
+// none, their watchers are only woken up.
+func recordTaskCompletion(tx *gorm.DB, taskData *TaskData) error {
+	switch taskData.Status {
+	case statusFinished:
+		return recordTaskEvent(tx, eventTaskFinished, taskData)
+	case statusFailed, statusTimedOut:
+		return recordTaskEvent(tx, eventTaskFailed, taskData)
+	case statusCancelled:
+		return recordTaskEvent(tx, eventTaskCancelled, taskData)
+	}
+	return notifyTaskLogs(tx, taskData.ID)
+}
+
+var errWebhookTargetBlocked = errors.New("url must not point to a private, loopback or link-local address")
+
+// sharedAddressSpace is the carrier-grade NAT range, which is not public
+// either.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// blockedWebhookIP tells whether webhooks must not be sent to the address,
+// since it is only reachable from the network of the server.
+func blockedWebhookIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() ||
+		sharedAddressSpace.Contains(ip)
+}
+
+// checkWebhookHost makes sure the host of the webhook URL does not resolve to
+// a blocked address. The addresses are checked again when connecting, since
+// the host may resolve differently by then.
+func checkWebhookHost(ctx context.Context, rawURL string) error {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	host := target.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		if blockedWebhookIP(ip) {
+			return errWebhookTargetBlocked
+		}
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("url host %s cannot be resolved", host)
+	}
+	for _, addr := range addrs {
+		if blockedWebhookIP(addr.IP) {
+			return errWebhookTargetBlocked
+		}
+	}
+	return nil
+}
+
+// newWebhookClient creates the client deliveries are sent with. Unless
+// private targets are allowed, it refuses to connect to blocked addresses.
+// Proxies are not used, they would hide the address actually connected to.
+// Redirects are not followed, a redirect is a failed attempt.
+func newWebhookClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || blockedWebhookIP(ip) {
+				return fmt.Errorf("%w: %s", errWebhookTargetBlocked, host)
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// signWebhook returns the signature of a delivery.
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// runWebhookDispatcher periodically turns the recorded task events into
+// deliveries and sends the deliveries that are due. Events and deliveries
+// older than the retention are purged every webhookPurgeInterval.
+func (s *Server) runWebhookDispatcher(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.WebhookPollInterval)
+	defer ticker.Stop()
+	purgeTicker := time.NewTicker(webhookPurgeInterval)
+	defer purgeTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-purgeTicker.C:
+			if err := s.purgeWebhookHistory(time.Now().Add(-s.cfg.WebhookRetention)); err != nil {
+				log.Error("failed to purge webhook history: " + err.Error())
+			}
+		case <-ticker.C:
+			if err := s.dispatchWebhookEvents(); err != nil {
+				log.Error("failed to dispatch webhook events: " + err.Error())
+			}
+			if err := s.sendWebhookDeliveries(ctx); err != nil {
+				log.Error("failed to send webhook deliveries: " + err.Error())
+			}
+		}
+	}
+}
+
+// dispatchWebhookEvents creates the deliveries of the events in the outbox,
+// batch by batch until none are left. Events without any subscribed webhook
+// are deleted right away. Locked events are skipped so that
+// several server replicas can dispatch concurrently.
+func (s *Server) dispatchWebhookEvents() error {
+	for {
+		tx := s.db.Begin()
+		if tx.Error != nil {
+			return tx.Error
+		}
+
+		var events []WebhookEvent
+		err := tx.
+			Where("dispatched_at IS NULL").
+			Order("created_at").
+			Clauses(clause.Locking{Strength: "UPDATE", Options: clause.LockingOptionsSkipLocked}).
+			Limit(webhookBatchSize).
+			Find(&events).Error
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if len(events) == 0 {
+			tx.Rollback()
+			return nil
+		}
+		var webhooks []WebhookData
+		if err := tx.Find(&webhooks).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		now := time.Now()
+		var deliveries []WebhookDelivery
+		var dispatchedIDs, unsubscribedIDs []uuid.UUID
+		for _, event := range events {
+			subscribed := false
+			for _, webhook := range webhooks {
+				if webhook.subscribes(event.Type) {
+					subscribed = true
+					deliveries = append(deliveries, WebhookDelivery{
+						ID:            uuid.New(),
+						WebhookID:     webhook.ID,
+						EventID:       event.ID,
+						EventType:     event.Type,
+						TaskID:        event.TaskID,
+						Status:        deliveryPending,
+						NextAttemptAt: &now,
+					})
+				}
+			}
+			if subscribed {
+				dispatchedIDs = append(dispatchedIDs, event.ID)
+			} else {
+				unsubscribedIDs = append(unsubscribedIDs, event.ID)
+			}
+		}
+		if len(deliveries) > 0 {
+			if err := tx.CreateInBatches(&deliveries, webhookBatchSize).Error; err != nil {
+				tx.Rollback()
+				return err
+			}
+			if err := tx.Model(&WebhookEvent{}).Where("id IN ?", dispatchedIDs).Update("dispatched_at", now).Error; err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		// Events no webhook subscribes to are of no use anymore.
+		if len(unsubscribedIDs) > 0 {
+			if err := tx.Where("id IN ?", unsubscribedIDs).Delete(&WebhookEvent{}).Error; err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		if err := tx.Commit().Error; err != nil {
+			return err
+		}
+		if len(events) < webhookBatchSize {
+			return nil
+		}
+	}
+}
+
+// purgeWebhookHistory deletes the completed deliveries created before the
+// cutoff, then the events dispatched before it that have no deliveries left.
+// Pending deliveries keep their events.
+func (s *Server) purgeWebhookHistory(cutoff time.Time) error {
+	err := s.db.
+		Where("status <> ? AND created_at < ?", deliveryPending, cutoff).
+		Delete(&WebhookDelivery{}).Error
+	if err != nil {
+		return err
+	}
+	return s.db.
+		Where("dispatched_at < ?", cutoff).
+		Where("NOT EXISTS (SELECT 1 FROM webhook_deliveries d WHERE d.event_id = webhook_events.id)").
+		Delete(&WebhookEvent{}).Error
+}
+
+// sendWebhookDeliveries sends the due deliveries concurrently. They are
+// claimed for the duration of an attempt first, so that neither another
+// replica nor the next run sends them meanwhile.
+func (s *Server) sendWebhookDeliveries(ctx context.Context) error {
+	deliveries, err := s.claimWebhookDeliveries()
+	if err != nil || len(deliveries) == 0 {
+		return err
+	}
+
+	webhookIDs := make([]uuid.UUID, len(deliveries))
+	eventIDs := make([]uuid.UUID, len(deliveries))
+	for i, delivery := range deliveries {
+		webhookIDs[i] = delivery.WebhookID
+		eventIDs[i] = delivery.EventID
+	}
+	var webhooks []WebhookData
+	if err := s.db.Where("id IN ?", uniqueIDs(webhookIDs)).Find(&webhooks).Error; err != nil {
+		return err
+	}
+	var events []WebhookEvent
+	if err := s.db.Where("id IN ?", uniqueIDs(eventIDs)).Find(&events).Error; err != nil {
+		return err
+	}
+	webhooksByID := make(map[uuid.UUID]*WebhookData, len(webhooks))
+	for i := range webhooks {
+		webhooksByID[webhooks[i].ID] = &webhooks[i]
+	}
+	eventsByID := make(map[uuid.UUID]*WebhookEvent, len(events))
+	for i := range events {
+		eventsByID[events[i].ID] = &events[i]
+	}
+
+	var wg sync.WaitGroup
+	for i := range deliveries {
+		delivery := &deliveries[i]
+		webhook, event := webhooksByID[delivery.WebhookID], eventsByID[delivery.EventID]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if webhook == nil || event == nil {
+				delivery.giveUp("webhook or event no longer exists")
+			} else {
+				s.attemptDelivery(ctx, webhook, event, delivery)
+			}
+			// An update rather than a save, so that a delivery deleted
+			// along with its webhook meanwhile stays deleted.
+			err := s.db.Model(delivery).
+				Select("status", "attempts", "next_attempt_at", "last_attempt_at", "response_status", "error").
+				Updates(delivery).Error
+			if err != nil {
+				log.Errorf("failed to save webhook delivery %s: %v", delivery.ID, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// claimWebhookDeliveries returns the due pending deliveries and postpones
+// them until the attempt has timed out for sure.
+func (s *Server) claimWebhookDeliveries() ([]WebhookDelivery, error) {
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	now := time.Now()
+	var deliveries []WebhookDelivery
+	err := tx.
+		Where("status = ?", deliveryPending).
+		Where("next_attempt_at <= ?", now).
+		Order("next_attempt_at").
+		Clauses(clause.Locking{Strength: "UPDATE", Options: clause.LockingOptionsSkipLocked}).
+		Limit(webhookBatchSize).
+		Find(&deliveries).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if len(deliveries) == 0 {
+		tx.Rollback()
+		return nil, nil
+	}
+
+	ids := make([]uuid.UUID, len(deliveries))
+	for i, delivery := range deliveries {
+		ids[i] = delivery.ID
+	}
+	claimedUntil := now.Add(2 * s.cfg.WebhookTimeout)
+	err = tx.Model(&WebhookDelivery{}).Where("id IN ?", ids).Update("next_attempt_at", claimedUntil).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// attemptDelivery sends the event to the webhook and records the outcome in
+// the delivery. A failed attempt is retried with backoff until the delivery
+// has no attempts left.
+func (s *Server) attemptDelivery(ctx context.Context, webhook *WebhookData, event *WebhookEvent, delivery *WebhookDelivery) {
+	now := time.Now()
+	delivery.Attempts++
+	delivery.LastAttemptAt = &now
+
+	statusCode, err := s.postWebhook(ctx, webhook, event, delivery.ID)
+	delivery.ResponseStatus = statusCode
+	if err == nil {
+		delivery.Status = deliverySucceeded
+		delivery.NextAttemptAt = nil
+		delivery.Error = nil
+		return
+	}
+	if delivery.Attempts >= s.cfg.WebhookMaxAttempts {
+		delivery.giveUp(err.Error())
+		return
+	}
+	reason := err.Error()
+	nextAttemptAt := now.Add(webhookBackoff.delay(delivery.Attempts))
+	delivery.NextAttemptAt = &nextAttemptAt
+	delivery.Error = &reason
+}
+
+func (d *WebhookDelivery) giveUp(reason string) {
+	d.Status = deliveryFailed
+	d.NextAttemptAt = nil
+	d.Error = &reason
+}
+
+// postWebhook sends the signed event. It returns the status code of the
+// response, if any, and an error unless the webhook accepted the event with
+// a 2xx status.
+func (s *Server) postWebhook(ctx context.Context, webhook *WebhookData, event *WebhookEvent, deliveryID uuid.UUID) (*int, error) {
+	body, err := json.Marshal(WebhookPayload{
+		ID:        event.ID,
+		Type:      event.Type,
+		CreatedAt: event.CreatedAt,
+		Task:      event.Task,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := webhook.openSecret(s.secrets)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookEventHeader, event.Type)
+	req.Header.Set(webhookDeliveryHeader, deliveryID.String())
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	req.Header.Set(webhookSignatureHeader, signWebhook(secret, timestamp, body))
+
+	resp, err := s.webhookClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &resp.StatusCode, fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return &resp.StatusCode, nil
+}
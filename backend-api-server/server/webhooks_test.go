@@ -0,0 +1,227 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestWebhookCreate(t *testing.T) {
+	assert := assert.New(t)
+
+	aead, err := newSecretsCipher(testSecretsKey)
+	assert.NoError(err)
+
+	// Secret is generated and stored encrypted, duplicate events are dropped
+	create := WebhookCreate{URL: "https://example.com/hook", Events: []string{eventTaskFailed, eventTaskFailed}}
+	webhookData, secret, err := create.toWebhookData(aead)
+	assert.NoError(err)
+	assert.Len(secret, 2*webhookSecretBytes)
+	assert.NotContains(string(webhookData.SecretCiphertext), secret)
+	opened, err := webhookData.openSecret(aead)
+	assert.NoError(err)
+	assert.Equal(secret, opened)
+	assert.Equal([]string{eventTaskFailed}, webhookData.Events)
+	assert.True(webhookData.subscribes(eventTaskFailed))
+	assert.False(webhookData.subscribes(eventTaskCreated))
+
+	// No events subscribes to all of them
+	create = WebhookCreate{URL: "http://localhost:8080/hook", Secret: "s3cr3t"}
+	webhookData, secret, err = create.toWebhookData(aead)
+	assert.NoError(err)
+	assert.Equal("s3cr3t", secret)
+	assert.True(webhookData.subscribes(eventTaskPicked))
+
+	// The sealed secret is bound to its webhook
+	other := WebhookData{ID: uuid.New(), SecretNonce: webhookData.SecretNonce, SecretCiphertext: webhookData.SecretCiphertext}
+	_, err = other.openSecret(aead)
+	assert.Error(err)
+
+	for _, invalid := range []WebhookCreate{
+		{URL: ""},
+		{URL: "/hook"},
+		{URL: "ftp://example.com/hook"},
+		{URL: "https://example.com/hook", Events: []string{"task.updated"}},
+	} {
+		_, _, err := invalid.toWebhookData(aead)
+		assert.Error(err, invalid)
+	}
+}
+
+func TestCheckWebhookHost(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx := context.Background()
+	assert.NoError(checkWebhookHost(ctx, "https://93.184.215.14/hook"))
+	assert.NoError(checkWebhookHost(ctx, "https://[2606:2800:21f:cb07:6820:80da:af6b:8b2c]/hook"))
+	for _, blocked := range []string{
+		"http://localhost:8080/hook",
+		"http://127.0.0.1/hook",
+		"http://10.0.0.1/hook",
+		"http://192.168.1.10/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://100.64.0.1/hook",
+		"http://0.0.0.0/hook",
+		"http://[::1]/hook",
+		"http://[fe80::1]/hook",
+		"http://[fd00::1]/hook",
+	} {
+		assert.ErrorIs(checkWebhookHost(ctx, blocked), errWebhookTargetBlocked, blocked)
+	}
+}
+
+func TestWebhookClient(t *testing.T) {
+	assert := assert.New(t)
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/hook", http.StatusFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	// Loopback receiver is refused when connecting
+	_, err := newWebhookClient(time.Second, false).Post(receiver.URL+"/hook", "application/json", nil)
+	assert.ErrorIs(err, errWebhookTargetBlocked)
+
+	// Redirects are not followed
+	client := newWebhookClient(time.Second, true)
+	resp, err := client.Post(receiver.URL+"/redirect", "application/json", nil)
+	assert.NoError(err)
+	_ = resp.Body.Close()
+	assert.Equal(http.StatusFound, resp.StatusCode)
+
+	resp, err = client.Post(receiver.URL+"/hook", "application/json", nil)
+	assert.NoError(err)
+	_ = resp.Body.Close()
+	assert.Equal(http.StatusNoContent, resp.StatusCode)
+}
+
+func TestAttemptDelivery(t *testing.T) {
+	assert := assert.New(t)
+
+	var status int
+	var received *http.Request
+	var body []byte
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer receiver.Close()
+
+	aead, err := newSecretsCipher(testSecretsKey)
+	assert.NoError(err)
+	server := Server{
+		cfg:           &Config{WebhookMaxAttempts: 2},
+		webhookClient: receiver.Client(),
+		secrets:       aead,
+	}
+	webhook := &WebhookData{ID: uuid.New(), URL: receiver.URL}
+	assert.NoError(webhook.sealSecret(aead, "s3cr3t"))
+	taskData := &TaskData{ID: uuid.New(), Command: "deploy", Status: statusFinished}
+	event := newWebhookEvent(eventTaskFinished, taskData)
+	ctx := context.Background()
+
+	// Signed event is delivered
+	status = http.StatusNoContent
+	delivery := &WebhookDelivery{ID: uuid.New(), Status: deliveryPending}
+	server.attemptDelivery(ctx, webhook, &event, delivery)
+	assert.Equal(deliverySucceeded, delivery.Status)
+	assert.Equal(1, delivery.Attempts)
+	assert.Equal(http.StatusNoContent, *delivery.ResponseStatus)
+	assert.Nil(delivery.NextAttemptAt)
+
+	assert.Equal(eventTaskFinished, received.Header.Get(webhookEventHeader))
+	assert.Equal(delivery.ID.String(), received.Header.Get(webhookDeliveryHeader))
+	timestamp := received.Header.Get(webhookTimestampHeader)
+	assert.Equal(signWebhook("s3cr3t", timestamp, body), received.Header.Get(webhookSignatureHeader))
+	assert.NotEqual(signWebhook("other", timestamp, body), received.Header.Get(webhookSignatureHeader))
+	var payload WebhookPayload
+	assert.NoError(json.Unmarshal(body, &payload))
+	assert.Equal(event.ID, payload.ID)
+	assert.Equal(taskData.ID, payload.Task.ID)
+	assert.Equal(statusFinished, payload.Task.Status)
+
+	// Failed attempt is retried with backoff
+	status = http.StatusInternalServerError
+	delivery = &WebhookDelivery{ID: uuid.New(), Status: deliveryPending}
+	before := time.Now()
+	server.attemptDelivery(ctx, webhook, &event, delivery)
+	assert.Equal(deliveryPending, delivery.Status)
+	assert.Equal(http.StatusInternalServerError, *delivery.ResponseStatus)
+	assert.NotNil(delivery.Error)
+	assert.WithinDuration(before.Add(webhookBackoff.delay(1)), *delivery.NextAttemptAt, time.Second)
+
+	// Delivery is given up after the last attempt
+	server.attemptDelivery(ctx, webhook, &event, delivery)
+	assert.Equal(deliveryFailed, delivery.Status)
+	assert.Equal(2, delivery.Attempts)
+	assert.Nil(delivery.NextAttemptAt)
+}
+
+func TestDispatchWebhookEvents(t *testing.T) {
+	assert := assert.New(t)
+
+	mockDB, mock, err := sqlmock.New()
+	assert.NoError(err)
+	defer func() { _ = mockDB.Close() }()
+
+	dialector := postgres.New(postgres.Config{
+		Conn:                 mockDB,
+		DriverName:           "postgres",
+		PreferSimpleProtocol: true,
+	})
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	assert.NoError(err)
+	server := Server{db: db}
+
+	// Subscribed event gets a delivery, the other one is deleted
+	failedID, createdID, webhookID := uuid.New(), uuid.New(), uuid.New()
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "webhook_events" WHERE dispatched_at IS NULL ORDER BY created_at LIMIT $1 FOR UPDATE SKIP LOCKED`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "type", "task_id"}).
+			AddRow(failedID, eventTaskFailed, uuid.New()).
+			AddRow(createdID, eventTaskCreated, uuid.New()))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "webhook_data"`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "events"}).AddRow(webhookID, `["task.failed"]`))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO "webhook_deliveries"`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "webhook_events" SET "dispatched_at"=$1 WHERE id IN ($2)`)).
+		WithArgs(sqlmock.AnyArg(), failedID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "webhook_events" WHERE id IN ($1)`)).
+		WithArgs(createdID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	assert.NoError(server.dispatchWebhookEvents())
+
+	// History older than the retention is purged, pending deliveries are kept
+	cutoff := time.Now().Add(-time.Hour)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "webhook_deliveries" WHERE status <> $1 AND created_at < $2`)).
+		WithArgs(deliveryPending, cutoff).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "webhook_events" WHERE dispatched_at < $1 AND NOT EXISTS`)).
+		WithArgs(cutoff).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+	assert.NoError(server.purgeWebhookHistory(cutoff))
+
+	assert.NoError(mock.ExpectationsWereMet())
+}
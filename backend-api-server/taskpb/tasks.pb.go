@@ -0,0 +1,1465 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        (unknown)
+// source: taskpb/tasks.proto
+
+package taskpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type BackoffPolicy struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	InitialSeconds int32                  `protobuf:"varint,1,opt,name=initial_seconds,json=initialSeconds,proto3" json:"initial_seconds,omitempty"`
+	Multiplier     float64                `protobuf:"fixed64,2,opt,name=multiplier,proto3" json:"multiplier,omitempty"`
+	MaxSeconds     int32                  `protobuf:"varint,3,opt,name=max_seconds,json=maxSeconds,proto3" json:"max_seconds,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *BackoffPolicy) Reset() {
+	*x = BackoffPolicy{}
+	mi := &file_taskpb_tasks_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BackoffPolicy) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BackoffPolicy) ProtoMessage() {}
+
+func (x *BackoffPolicy) ProtoReflect() protoreflect.Message {
+	mi := &file_taskpb_tasks_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BackoffPolicy.ProtoReflect.Descriptor instead.
+func (*BackoffPolicy) Descriptor() ([]byte, []int) {
+	return file_taskpb_tasks_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *BackoffPolicy) GetInitialSeconds() int32 {
+	if x != nil {
+		return x.InitialSeconds
+	}
+	return 0
+}
+
+func (x *BackoffPolicy) GetMultiplier() float64 {
+	if x != nil {
+		return x.Multiplier
+	}
+	return 0
+}
+
+func (x *BackoffPolicy) GetMaxSeconds() int32 {
+	if x != nil {
+		return x.MaxSeconds
+	}
+	return 0
+}
+
+type OutputLine struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Stream        string                 `protobuf:"bytes,1,opt,name=stream,proto3" json:"stream,omitempty"`
+	Data          string                 `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	Time          *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=time,proto3" json:"time,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *OutputLine) Reset() {
+	*x = OutputLine{}
+	mi := &file_taskpb_tasks_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *OutputLine) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*OutputLine) ProtoMessage() {}
+
+func (x *OutputLine) ProtoReflect() protoreflect.Message {
+	mi := &file_taskpb_tasks_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use OutputLine.ProtoReflect.Descriptor instead.
+func (*OutputLine) Descriptor() ([]byte, []int) {
+	return file_taskpb_tasks_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *OutputLine) GetStream() string {
+	if x != nil {
+		return x.Stream
+	}
+	return ""
+}
+
+func (x *OutputLine) GetData() string {
+	if x != nil {
+		return x.Data
+	}
+	return ""
+}
+
+func (x *OutputLine) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+type Task struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Id             string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Command        string                 `protobuf:"bytes,2,opt,name=command,proto3" json:"command,omitempty"`
+	StartedAt      *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=started_at,json=startedAt,proto3" json:"started_at,omitempty"`
+	FinishedAt     *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=finished_at,json=finishedAt,proto3" json:"finished_at,omitempty"`
+	Status         string                 `protobuf:"bytes,5,opt,name=status,proto3" json:"status,omitempty"`
+	Stdout         *string                `protobuf:"bytes,6,opt,name=stdout,proto3,oneof" json:"stdout,omitempty"`
+	Stderr         *string                `protobuf:"bytes,7,opt,name=stderr,proto3,oneof" json:"stderr,omitempty"`
+	Output         []*OutputLine          `protobuf:"bytes,8,rep,name=output,proto3" json:"output,omitempty"`
+	ExitCode       *int32                 `protobuf:"varint,9,opt,name=exit_code,json=exitCode,proto3,oneof" json:"exit_code,omitempty"`
+	StatusReason   *string                `protobuf:"bytes,10,opt,name=status_reason,json=statusReason,proto3,oneof" json:"status_reason,omitempty"`
+	MaxAttempts    int32                  `protobuf:"varint,11,opt,name=max_attempts,json=maxAttempts,proto3" json:"max_attempts,omitempty"`
+	Attempt        int32                  `protobuf:"varint,12,opt,name=attempt,proto3" json:"attempt,omitempty"`
+	Backoff        *BackoffPolicy         `protobuf:"bytes,13,opt,name=backoff,proto3" json:"backoff,omitempty"`
+	NotBefore      *timestamppb.Timestamp `protobuf:"bytes,14,opt,name=not_before,json=notBefore,proto3" json:"not_before,omitempty"`
+	Priority       int32                  `protobuf:"varint,15,opt,name=priority,proto3" json:"priority,omitempty"`
+	ScheduleId     *string                `protobuf:"bytes,16,opt,name=schedule_id,json=scheduleId,proto3,oneof" json:"schedule_id,omitempty"`
+	ScheduledFor   *timestamppb.Timestamp `protobuf:"bytes,17,opt,name=scheduled_for,json=scheduledFor,proto3" json:"scheduled_for,omitempty"`
+	DependsOn      []string               `protobuf:"bytes,18,rep,name=depends_on,json=dependsOn,proto3" json:"depends_on,omitempty"`
+	PipelineId     *string                `protobuf:"bytes,19,opt,name=pipeline_id,json=pipelineId,proto3,oneof" json:"pipeline_id,omitempty"`
+	PipelineStep   *int32                 `protobuf:"varint,20,opt,name=pipeline_step,json=pipelineStep,proto3,oneof" json:"pipeline_step,omitempty"`
+	TimeoutSeconds *int32                 `protobuf:"varint,21,opt,name=timeout_seconds,json=timeoutSeconds,proto3,oneof" json:"timeout_seconds,omitempty"`
+	Env            map[string]string      `protobuf:"bytes,22,rep,name=env,proto3" json:"env,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Workdir        string                 `protobuf:"bytes,23,opt,name=workdir,proto3" json:"workdir,omitempty"`
+	Secrets        map[string]string      `protobuf:"bytes,24,rep,name=secrets,proto3" json:"secrets,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Artifacts      []string               `protobuf:"bytes,25,rep,name=artifacts,proto3" json:"artifacts,omitempty"`
+	BundleSize     *int64                 `protobuf:"varint,26,opt,name=bundle_size,json=bundleSize,proto3,oneof" json:"bundle_size,omitempty"`
+	AgentId        *string                `protobuf:"bytes,27,opt,name=agent_id,json=agentId,proto3,oneof" json:"agent_id,omitempty"`
+	Labels         map[string]string      `protobuf:"bytes,28,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Queue          string                 `protobuf:"bytes,29,opt,name=queue,proto3" json:"queue,omitempty"`
+	Date           *timestamppb.Timestamp `protobuf:"bytes,30,opt,name=date,proto3" json:"date,omitempty"`
+	RunAt          *timestamppb.Timestamp `protobuf:"bytes,31,opt,name=run_at,json=runAt,proto3" json:"run_at,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *Task) Reset() {
+	*x = Task{}
+	mi := &file_taskpb_tasks_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Task) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Task) ProtoMessage() {}
+
+func (x *Task) ProtoReflect() protoreflect.Message {
+	mi := &file_taskpb_tasks_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Task.ProtoReflect.Descriptor instead.
+func (*Task) Descriptor() ([]byte, []int) {
+	return file_taskpb_tasks_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Task) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Task) GetCommand() string {
+	if x != nil {
+		return x.Command
+	}
+	return ""
+}
+
+func (x *Task) GetStartedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.StartedAt
+	}
+	return nil
+}
+
+func (x *Task) GetFinishedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.FinishedAt
+	}
+	return nil
+}
+
+func (x *Task) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *Task) GetStdout() string {
+	if x != nil && x.Stdout != nil {
+		return *x.Stdout
+	}
+	return ""
+}
+
+func (x *Task) GetStderr() string {
+	if x != nil && x.Stderr != nil {
+		return *x.Stderr
+	}
+	return ""
+}
+
+func (x *Task) GetOutput() []*OutputLine {
+	if x != nil {
+		return x.Output
+	}
+	return nil
+}
+
+func (x *Task) GetExitCode() int32 {
+	if x != nil && x.ExitCode != nil {
+		return *x.ExitCode
+	}
+	return 0
+}
+
+func (x *Task) GetStatusReason() string {
+	if x != nil && x.StatusReason != nil {
+		return *x.StatusReason
+	}
+	return ""
+}
+
+func (x *Task) GetMaxAttempts() int32 {
+	if x != nil {
+		return x.MaxAttempts
+	}
+	return 0
+}
+
+func (x *Task) GetAttempt() int32 {
+	if x != nil {
+		return x.Attempt
+	}
+	return 0
+}
+
+func (x *Task) GetBackoff() *BackoffPolicy {
+	if x != nil {
+		return x.Backoff
+	}
+	return nil
+}
+
+func (x *Task) GetNotBefore() *timestamppb.Timestamp {
+	if x != nil {
+		return x.NotBefore
+	}
+	return nil
+}
+
+func (x *Task) GetPriority() int32 {
+	if x != nil {
+		return x.Priority
+	}
+	return 0
+}
+
+func (x *Task) GetScheduleId() string {
+	if x != nil && x.ScheduleId != nil {
+		return *x.ScheduleId
+	}
+	return ""
+}
+
+func (x *Task) GetScheduledFor() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ScheduledFor
+	}
+	return nil
+}
+
+func (x *Task) GetDependsOn() []string {
+	if x != nil {
+		return x.DependsOn
+	}
+	return nil
+}
+
+func (x *Task) GetPipelineId() string {
+	if x != nil && x.PipelineId != nil {
+		return *x.PipelineId
+	}
+	return ""
+}
+
+func (x *Task) GetPipelineStep() int32 {
+	if x != nil && x.PipelineStep != nil {
+		return *x.PipelineStep
+	}
+	return 0
+}
+
+func (x *Task) GetTimeoutSeconds() int32 {
+	if x != nil && x.TimeoutSeconds != nil {
+		return *x.TimeoutSeconds
+	}
+	return 0
+}
+
+func (x *Task) GetEnv() map[string]string {
+	if x != nil {
+		return x.Env
+	}
+	return nil
+}
+
+func (x *Task) GetWorkdir() string {
+	if x != nil {
+		return x.Workdir
+	}
+	return ""
+}
+
+func (x *Task) GetSecrets() map[string]string {
+	if x != nil {
+		return x.Secrets
+	}
+	return nil
+}
+
+func (x *Task) GetArtifacts() []string {
+	if x != nil {
+		return x.Artifacts
+	}
+	return nil
+}
+
+func (x *Task) GetBundleSize() int64 {
+	if x != nil && x.BundleSize != nil {
+		return *x.BundleSize
+	}
+	return 0
+}
+
+func (x *Task) GetAgentId() string {
+	if x != nil && x.AgentId != nil {
+		return *x.AgentId
+	}
+	return ""
+}
+
+func (x *Task) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *Task) GetQueue() string {
+	if x != nil {
+		return x.Queue
+	}
+	return ""
+}
+
+func (x *Task) GetDate() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Date
+	}
+	return nil
+}
+
+func (x *Task) GetRunAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.RunAt
+	}
+	return nil
+}
+
+type CreateTaskRequest struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Command        string                 `protobuf:"bytes,1,opt,name=command,proto3" json:"command,omitempty"`
+	MaxAttempts    *int32                 `protobuf:"varint,2,opt,name=max_attempts,json=maxAttempts,proto3,oneof" json:"max_attempts,omitempty"`
+	Backoff        *BackoffPolicy         `protobuf:"bytes,3,opt,name=backoff,proto3" json:"backoff,omitempty"`
+	Priority       int32                  `protobuf:"varint,4,opt,name=priority,proto3" json:"priority,omitempty"`
+	RunAt          *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=run_at,json=runAt,proto3" json:"run_at,omitempty"`
+	DependsOn      []string               `protobuf:"bytes,6,rep,name=depends_on,json=dependsOn,proto3" json:"depends_on,omitempty"`
+	TimeoutSeconds *int32                 `protobuf:"varint,7,opt,name=timeout_seconds,json=timeoutSeconds,proto3,oneof" json:"timeout_seconds,omitempty"`
+	Env            map[string]string      `protobuf:"bytes,8,rep,name=env,proto3" json:"env,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Workdir        string                 `protobuf:"bytes,9,opt,name=workdir,proto3" json:"workdir,omitempty"`
+	Secrets        map[string]string      `protobuf:"bytes,10,rep,name=secrets,proto3" json:"secrets,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Artifacts      []string               `protobuf:"bytes,11,rep,name=artifacts,proto3" json:"artifacts,omitempty"`
+	Labels         map[string]string      `protobuf:"bytes,12,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Queue          string                 `protobuf:"bytes,13,opt,name=queue,proto3" json:"queue,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *CreateTaskRequest) Reset() {
+	*x = CreateTaskRequest{}
+	mi := &file_taskpb_tasks_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateTaskRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateTaskRequest) ProtoMessage() {}
+
+func (x *CreateTaskRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_taskpb_tasks_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateTaskRequest.ProtoReflect.Descriptor instead.
+func (*CreateTaskRequest) Descriptor() ([]byte, []int) {
+	return file_taskpb_tasks_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *CreateTaskRequest) GetCommand() string {
+	if x != nil {
+		return x.Command
+	}
+	return ""
+}
+
+func (x *CreateTaskRequest) GetMaxAttempts() int32 {
+	if x != nil && x.MaxAttempts != nil {
+		return *x.MaxAttempts
+	}
+	return 0
+}
+
+func (x *CreateTaskRequest) GetBackoff() *BackoffPolicy {
+	if x != nil {
+		return x.Backoff
+	}
+	return nil
+}
+
+func (x *CreateTaskRequest) GetPriority() int32 {
+	if x != nil {
+		return x.Priority
+	}
+	return 0
+}
+
+func (x *CreateTaskRequest) GetRunAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.RunAt
+	}
+	return nil
+}
+
+func (x *CreateTaskRequest) GetDependsOn() []string {
+	if x != nil {
+		return x.DependsOn
+	}
+	return nil
+}
+
+func (x *CreateTaskRequest) GetTimeoutSeconds() int32 {
+	if x != nil && x.TimeoutSeconds != nil {
+		return *x.TimeoutSeconds
+	}
+	return 0
+}
+
+func (x *CreateTaskRequest) GetEnv() map[string]string {
+	if x != nil {
+		return x.Env
+	}
+	return nil
+}
+
+func (x *CreateTaskRequest) GetWorkdir() string {
+	if x != nil {
+		return x.Workdir
+	}
+	return ""
+}
+
+func (x *CreateTaskRequest) GetSecrets() map[string]string {
+	if x != nil {
+		return x.Secrets
+	}
+	return nil
+}
+
+func (x *CreateTaskRequest) GetArtifacts() []string {
+	if x != nil {
+		return x.Artifacts
+	}
+	return nil
+}
+
+func (x *CreateTaskRequest) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *CreateTaskRequest) GetQueue() string {
+	if x != nil {
+		return x.Queue
+	}
+	return ""
+}
+
+// ListTasksRequest takes the same filters as GET /tasks. Fields are the
+// fields of the tasks to load, named like in the JSON payloads, all of them
+// if empty.
+type ListTasksRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Statuses      []string               `protobuf:"bytes,1,rep,name=statuses,proto3" json:"statuses,omitempty"`
+	CreatedAfter  *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=created_after,json=createdAfter,proto3" json:"created_after,omitempty"`
+	CreatedBefore *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=created_before,json=createdBefore,proto3" json:"created_before,omitempty"`
+	ExitCode      *int32                 `protobuf:"varint,4,opt,name=exit_code,json=exitCode,proto3,oneof" json:"exit_code,omitempty"`
+	Command       string                 `protobuf:"bytes,5,opt,name=command,proto3" json:"command,omitempty"`
+	Sort          string                 `protobuf:"bytes,6,opt,name=sort,proto3" json:"sort,omitempty"`
+	Limit         int32                  `protobuf:"varint,7,opt,name=limit,proto3" json:"limit,omitempty"`
+	Cursor        string                 `protobuf:"bytes,8,opt,name=cursor,proto3" json:"cursor,omitempty"`
+	Fields        []string               `protobuf:"bytes,9,rep,name=fields,proto3" json:"fields,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListTasksRequest) Reset() {
+	*x = ListTasksRequest{}
+	mi := &file_taskpb_tasks_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListTasksRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListTasksRequest) ProtoMessage() {}
+
+func (x *ListTasksRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_taskpb_tasks_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListTasksRequest.ProtoReflect.Descriptor instead.
+func (*ListTasksRequest) Descriptor() ([]byte, []int) {
+	return file_taskpb_tasks_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ListTasksRequest) GetStatuses() []string {
+	if x != nil {
+		return x.Statuses
+	}
+	return nil
+}
+
+func (x *ListTasksRequest) GetCreatedAfter() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedAfter
+	}
+	return nil
+}
+
+func (x *ListTasksRequest) GetCreatedBefore() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedBefore
+	}
+	return nil
+}
+
+func (x *ListTasksRequest) GetExitCode() int32 {
+	if x != nil && x.ExitCode != nil {
+		return *x.ExitCode
+	}
+	return 0
+}
+
+func (x *ListTasksRequest) GetCommand() string {
+	if x != nil {
+		return x.Command
+	}
+	return ""
+}
+
+func (x *ListTasksRequest) GetSort() string {
+	if x != nil {
+		return x.Sort
+	}
+	return ""
+}
+
+func (x *ListTasksRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *ListTasksRequest) GetCursor() string {
+	if x != nil {
+		return x.Cursor
+	}
+	return ""
+}
+
+func (x *ListTasksRequest) GetFields() []string {
+	if x != nil {
+		return x.Fields
+	}
+	return nil
+}
+
+type ListTasksResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Tasks []*Task                `protobuf:"bytes,1,rep,name=tasks,proto3" json:"tasks,omitempty"`
+	// next_cursor is empty on the last page.
+	NextCursor    string `protobuf:"bytes,2,opt,name=next_cursor,json=nextCursor,proto3" json:"next_cursor,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListTasksResponse) Reset() {
+	*x = ListTasksResponse{}
+	mi := &file_taskpb_tasks_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListTasksResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListTasksResponse) ProtoMessage() {}
+
+func (x *ListTasksResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_taskpb_tasks_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListTasksResponse.ProtoReflect.Descriptor instead.
+func (*ListTasksResponse) Descriptor() ([]byte, []int) {
+	return file_taskpb_tasks_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ListTasksResponse) GetTasks() []*Task {
+	if x != nil {
+		return x.Tasks
+	}
+	return nil
+}
+
+func (x *ListTasksResponse) GetNextCursor() string {
+	if x != nil {
+		return x.NextCursor
+	}
+	return ""
+}
+
+type GetTaskRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetTaskRequest) Reset() {
+	*x = GetTaskRequest{}
+	mi := &file_taskpb_tasks_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetTaskRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetTaskRequest) ProtoMessage() {}
+
+func (x *GetTaskRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_taskpb_tasks_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetTaskRequest.ProtoReflect.Descriptor instead.
+func (*GetTaskRequest) Descriptor() ([]byte, []int) {
+	return file_taskpb_tasks_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *GetTaskRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type PickTaskRequest struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	AgentId string                 `protobuf:"bytes,1,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`
+	Labels  map[string]string      `protobuf:"bytes,2,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// Queues default to the default queue.
+	Queues []string `protobuf:"bytes,3,rep,name=queues,proto3" json:"queues,omitempty"`
+	// Wait holds the call until a task is queued, capped by MAX_PICK_WAIT.
+	Wait          *durationpb.Duration `protobuf:"bytes,4,opt,name=wait,proto3" json:"wait,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PickTaskRequest) Reset() {
+	*x = PickTaskRequest{}
+	mi := &file_taskpb_tasks_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PickTaskRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PickTaskRequest) ProtoMessage() {}
+
+func (x *PickTaskRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_taskpb_tasks_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PickTaskRequest.ProtoReflect.Descriptor instead.
+func (*PickTaskRequest) Descriptor() ([]byte, []int) {
+	return file_taskpb_tasks_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *PickTaskRequest) GetAgentId() string {
+	if x != nil {
+		return x.AgentId
+	}
+	return ""
+}
+
+func (x *PickTaskRequest) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *PickTaskRequest) GetQueues() []string {
+	if x != nil {
+		return x.Queues
+	}
+	return nil
+}
+
+func (x *PickTaskRequest) GetWait() *durationpb.Duration {
+	if x != nil {
+		return x.Wait
+	}
+	return nil
+}
+
+type PickedTask struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Task           *Task                  `protobuf:"bytes,1,opt,name=task,proto3" json:"task,omitempty"`
+	LeaseId        string                 `protobuf:"bytes,2,opt,name=lease_id,json=leaseId,proto3" json:"lease_id,omitempty"`
+	LeaseExpiresAt *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=lease_expires_at,json=leaseExpiresAt,proto3" json:"lease_expires_at,omitempty"`
+	TimeoutSeconds int32                  `protobuf:"varint,4,opt,name=timeout_seconds,json=timeoutSeconds,proto3" json:"timeout_seconds,omitempty"`
+	SecretEnv      map[string]string      `protobuf:"bytes,5,rep,name=secret_env,json=secretEnv,proto3" json:"secret_env,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *PickedTask) Reset() {
+	*x = PickedTask{}
+	mi := &file_taskpb_tasks_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PickedTask) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PickedTask) ProtoMessage() {}
+
+func (x *PickedTask) ProtoReflect() protoreflect.Message {
+	mi := &file_taskpb_tasks_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PickedTask.ProtoReflect.Descriptor instead.
+func (*PickedTask) Descriptor() ([]byte, []int) {
+	return file_taskpb_tasks_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *PickedTask) GetTask() *Task {
+	if x != nil {
+		return x.Task
+	}
+	return nil
+}
+
+func (x *PickedTask) GetLeaseId() string {
+	if x != nil {
+		return x.LeaseId
+	}
+	return ""
+}
+
+func (x *PickedTask) GetLeaseExpiresAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.LeaseExpiresAt
+	}
+	return nil
+}
+
+func (x *PickedTask) GetTimeoutSeconds() int32 {
+	if x != nil {
+		return x.TimeoutSeconds
+	}
+	return 0
+}
+
+func (x *PickedTask) GetSecretEnv() map[string]string {
+	if x != nil {
+		return x.SecretEnv
+	}
+	return nil
+}
+
+type FinishTaskRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	LeaseId       string                 `protobuf:"bytes,2,opt,name=lease_id,json=leaseId,proto3" json:"lease_id,omitempty"`
+	Status        string                 `protobuf:"bytes,3,opt,name=status,proto3" json:"status,omitempty"`
+	ExitCode      *int32                 `protobuf:"varint,4,opt,name=exit_code,json=exitCode,proto3,oneof" json:"exit_code,omitempty"`
+	Output        []*OutputLine          `protobuf:"bytes,5,rep,name=output,proto3" json:"output,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FinishTaskRequest) Reset() {
+	*x = FinishTaskRequest{}
+	mi := &file_taskpb_tasks_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FinishTaskRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FinishTaskRequest) ProtoMessage() {}
+
+func (x *FinishTaskRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_taskpb_tasks_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FinishTaskRequest.ProtoReflect.Descriptor instead.
+func (*FinishTaskRequest) Descriptor() ([]byte, []int) {
+	return file_taskpb_tasks_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *FinishTaskRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *FinishTaskRequest) GetLeaseId() string {
+	if x != nil {
+		return x.LeaseId
+	}
+	return ""
+}
+
+func (x *FinishTaskRequest) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *FinishTaskRequest) GetExitCode() int32 {
+	if x != nil && x.ExitCode != nil {
+		return *x.ExitCode
+	}
+	return 0
+}
+
+func (x *FinishTaskRequest) GetOutput() []*OutputLine {
+	if x != nil {
+		return x.Output
+	}
+	return nil
+}
+
+type StreamTaskLogsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Offset        int64                  `protobuf:"varint,2,opt,name=offset,proto3" json:"offset,omitempty"`
+	Follow        bool                   `protobuf:"varint,3,opt,name=follow,proto3" json:"follow,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamTaskLogsRequest) Reset() {
+	*x = StreamTaskLogsRequest{}
+	mi := &file_taskpb_tasks_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamTaskLogsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamTaskLogsRequest) ProtoMessage() {}
+
+func (x *StreamTaskLogsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_taskpb_tasks_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamTaskLogsRequest.ProtoReflect.Descriptor instead.
+func (*StreamTaskLogsRequest) Descriptor() ([]byte, []int) {
+	return file_taskpb_tasks_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *StreamTaskLogsRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *StreamTaskLogsRequest) GetOffset() int64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *StreamTaskLogsRequest) GetFollow() bool {
+	if x != nil {
+		return x.Follow
+	}
+	return false
+}
+
+type LogChunk struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Seq           int64                  `protobuf:"varint,1,opt,name=seq,proto3" json:"seq,omitempty"`
+	Attempt       int32                  `protobuf:"varint,2,opt,name=attempt,proto3" json:"attempt,omitempty"`
+	Stream        string                 `protobuf:"bytes,3,opt,name=stream,proto3" json:"stream,omitempty"`
+	Data          string                 `protobuf:"bytes,4,opt,name=data,proto3" json:"data,omitempty"`
+	Time          *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=time,proto3" json:"time,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LogChunk) Reset() {
+	*x = LogChunk{}
+	mi := &file_taskpb_tasks_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LogChunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LogChunk) ProtoMessage() {}
+
+func (x *LogChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_taskpb_tasks_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LogChunk.ProtoReflect.Descriptor instead.
+func (*LogChunk) Descriptor() ([]byte, []int) {
+	return file_taskpb_tasks_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *LogChunk) GetSeq() int64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+func (x *LogChunk) GetAttempt() int32 {
+	if x != nil {
+		return x.Attempt
+	}
+	return 0
+}
+
+func (x *LogChunk) GetStream() string {
+	if x != nil {
+		return x.Stream
+	}
+	return ""
+}
+
+func (x *LogChunk) GetData() string {
+	if x != nil {
+		return x.Data
+	}
+	return ""
+}
+
+func (x *LogChunk) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+type WatchTaskRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchTaskRequest) Reset() {
+	*x = WatchTaskRequest{}
+	mi := &file_taskpb_tasks_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchTaskRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchTaskRequest) ProtoMessage() {}
+
+func (x *WatchTaskRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_taskpb_tasks_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchTaskRequest.ProtoReflect.Descriptor instead.
+func (*WatchTaskRequest) Descriptor() ([]byte, []int) {
+	return file_taskpb_tasks_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *WatchTaskRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+var File_taskpb_tasks_proto protoreflect.FileDescriptor
+
+const file_taskpb_tasks_proto_rawDesc = "" +
+	"\n" +
+	"\x12taskpb/tasks.proto\x12\vtaskexec.v1\x1a\x1egoogle/protobuf/duration.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"y\n" +
+	"\rBackoffPolicy\x12'\n" +
+	"\x0finitial_seconds\x18\x01 \x01(\x05R\x0einitialSeconds\x12\x1e\n" +
+	"\n" +
+	"multiplier\x18\x02 \x01(\x01R\n" +
+	"multiplier\x12\x1f\n" +
+	"\vmax_seconds\x18\x03 \x01(\x05R\n" +
+	"maxSeconds\"h\n" +
+	"\n" +
+	"OutputLine\x12\x16\n" +
+	"\x06stream\x18\x01 \x01(\tR\x06stream\x12\x12\n" +
+	"\x04data\x18\x02 \x01(\tR\x04data\x12.\n" +
+	"\x04time\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\x04time\"\xa3\f\n" +
+	"\x04Task\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x18\n" +
+	"\acommand\x18\x02 \x01(\tR\acommand\x129\n" +
+	"\n" +
+	"started_at\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\tstartedAt\x12;\n" +
+	"\vfinished_at\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"finishedAt\x12\x16\n" +
+	"\x06status\x18\x05 \x01(\tR\x06status\x12\x1b\n" +
+	"\x06stdout\x18\x06 \x01(\tH\x00R\x06stdout\x88\x01\x01\x12\x1b\n" +
+	"\x06stderr\x18\a \x01(\tH\x01R\x06stderr\x88\x01\x01\x12/\n" +
+	"\x06output\x18\b \x03(\v2\x17.taskexec.v1.OutputLineR\x06output\x12 \n" +
+	"\texit_code\x18\t \x01(\x05H\x02R\bexitCode\x88\x01\x01\x12(\n" +
+	"\rstatus_reason\x18\n" +
+	" \x01(\tH\x03R\fstatusReason\x88\x01\x01\x12!\n" +
+	"\fmax_attempts\x18\v \x01(\x05R\vmaxAttempts\x12\x18\n" +
+	"\aattempt\x18\f \x01(\x05R\aattempt\x124\n" +
+	"\abackoff\x18\r \x01(\v2\x1a.taskexec.v1.BackoffPolicyR\abackoff\x129\n" +
+	"\n" +
+	"not_before\x18\x0e \x01(\v2\x1a.google.protobuf.TimestampR\tnotBefore\x12\x1a\n" +
+	"\bpriority\x18\x0f \x01(\x05R\bpriority\x12$\n" +
+	"\vschedule_id\x18\x10 \x01(\tH\x04R\n" +
+	"scheduleId\x88\x01\x01\x12?\n" +
+	"\rscheduled_for\x18\x11 \x01(\v2\x1a.google.protobuf.TimestampR\fscheduledFor\x12\x1d\n" +
+	"\n" +
+	"depends_on\x18\x12 \x03(\tR\tdependsOn\x12$\n" +
+	"\vpipeline_id\x18\x13 \x01(\tH\x05R\n" +
+	"pipelineId\x88\x01\x01\x12(\n" +
+	"\rpipeline_step\x18\x14 \x01(\x05H\x06R\fpipelineStep\x88\x01\x01\x12,\n" +
+	"\x0ftimeout_seconds\x18\x15 \x01(\x05H\aR\x0etimeoutSeconds\x88\x01\x01\x12,\n" +
+	"\x03env\x18\x16 \x03(\v2\x1a.taskexec.v1.Task.EnvEntryR\x03env\x12\x18\n" +
+	"\aworkdir\x18\x17 \x01(\tR\aworkdir\x128\n" +
+	"\asecrets\x18\x18 \x03(\v2\x1e.taskexec.v1.Task.SecretsEntryR\asecrets\x12\x1c\n" +
+	"\tartifacts\x18\x19 \x03(\tR\tartifacts\x12$\n" +
+	"\vbundle_size\x18\x1a \x01(\x03H\bR\n" +
+	"bundleSize\x88\x01\x01\x12\x1e\n" +
+	"\bagent_id\x18\x1b \x01(\tH\tR\aagentId\x88\x01\x01\x125\n" +
+	"\x06labels\x18\x1c \x03(\v2\x1d.taskexec.v1.Task.LabelsEntryR\x06labels\x12\x14\n" +
+	"\x05queue\x18\x1d \x01(\tR\x05queue\x12.\n" +
+	"\x04date\x18\x1e \x01(\v2\x1a.google.protobuf.TimestampR\x04date\x121\n" +
+	"\x06run_at\x18\x1f \x01(\v2\x1a.google.protobuf.TimestampR\x05runAt\x1a6\n" +
+	"\bEnvEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\x1a:\n" +
+	"\fSecretsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01B\t\n" +
+	"\a_stdoutB\t\n" +
+	"\a_stderrB\f\n" +
+	"\n" +
+	"_exit_codeB\x10\n" +
+	"\x0e_status_reasonB\x0e\n" +
+	"\f_schedule_idB\x0e\n" +
+	"\f_pipeline_idB\x10\n" +
+	"\x0e_pipeline_stepB\x12\n" +
+	"\x10_timeout_secondsB\x0e\n" +
+	"\f_bundle_sizeB\v\n" +
+	"\t_agent_id\"\x8f\x06\n" +
+	"\x11CreateTaskRequest\x12\x18\n" +
+	"\acommand\x18\x01 \x01(\tR\acommand\x12&\n" +
+	"\fmax_attempts\x18\x02 \x01(\x05H\x00R\vmaxAttempts\x88\x01\x01\x124\n" +
+	"\abackoff\x18\x03 \x01(\v2\x1a.taskexec.v1.BackoffPolicyR\abackoff\x12\x1a\n" +
+	"\bpriority\x18\x04 \x01(\x05R\bpriority\x121\n" +
+	"\x06run_at\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\x05runAt\x12\x1d\n" +
+	"\n" +
+	"depends_on\x18\x06 \x03(\tR\tdependsOn\x12,\n" +
+	"\x0ftimeout_seconds\x18\a \x01(\x05H\x01R\x0etimeoutSeconds\x88\x01\x01\x129\n" +
+	"\x03env\x18\b \x03(\v2'.taskexec.v1.CreateTaskRequest.EnvEntryR\x03env\x12\x18\n" +
+	"\aworkdir\x18\t \x01(\tR\aworkdir\x12E\n" +
+	"\asecrets\x18\n" +
+	" \x03(\v2+.taskexec.v1.CreateTaskRequest.SecretsEntryR\asecrets\x12\x1c\n" +
+	"\tartifacts\x18\v \x03(\tR\tartifacts\x12B\n" +
+	"\x06labels\x18\f \x03(\v2*.taskexec.v1.CreateTaskRequest.LabelsEntryR\x06labels\x12\x14\n" +
+	"\x05queue\x18\r \x01(\tR\x05queue\x1a6\n" +
+	"\bEnvEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\x1a:\n" +
+	"\fSecretsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01B\x0f\n" +
+	"\r_max_attemptsB\x12\n" +
+	"\x10_timeout_seconds\"\xd6\x02\n" +
+	"\x10ListTasksRequest\x12\x1a\n" +
+	"\bstatuses\x18\x01 \x03(\tR\bstatuses\x12?\n" +
+	"\rcreated_after\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\fcreatedAfter\x12A\n" +
+	"\x0ecreated_before\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\rcreatedBefore\x12 \n" +
+	"\texit_code\x18\x04 \x01(\x05H\x00R\bexitCode\x88\x01\x01\x12\x18\n" +
+	"\acommand\x18\x05 \x01(\tR\acommand\x12\x12\n" +
+	"\x04sort\x18\x06 \x01(\tR\x04sort\x12\x14\n" +
+	"\x05limit\x18\a \x01(\x05R\x05limit\x12\x16\n" +
+	"\x06cursor\x18\b \x01(\tR\x06cursor\x12\x16\n" +
+	"\x06fields\x18\t \x03(\tR\x06fieldsB\f\n" +
+	"\n" +
+	"_exit_code\"]\n" +
+	"\x11ListTasksResponse\x12'\n" +
+	"\x05tasks\x18\x01 \x03(\v2\x11.taskexec.v1.TaskR\x05tasks\x12\x1f\n" +
+	"\vnext_cursor\x18\x02 \x01(\tR\n" +
+	"nextCursor\" \n" +
+	"\x0eGetTaskRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\"\xf0\x01\n" +
+	"\x0fPickTaskRequest\x12\x19\n" +
+	"\bagent_id\x18\x01 \x01(\tR\aagentId\x12@\n" +
+	"\x06labels\x18\x02 \x03(\v2(.taskexec.v1.PickTaskRequest.LabelsEntryR\x06labels\x12\x16\n" +
+	"\x06queues\x18\x03 \x03(\tR\x06queues\x12-\n" +
+	"\x04wait\x18\x04 \x01(\v2\x19.google.protobuf.DurationR\x04wait\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\xc2\x02\n" +
+	"\n" +
+	"PickedTask\x12%\n" +
+	"\x04task\x18\x01 \x01(\v2\x11.taskexec.v1.TaskR\x04task\x12\x19\n" +
+	"\blease_id\x18\x02 \x01(\tR\aleaseId\x12D\n" +
+	"\x10lease_expires_at\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\x0eleaseExpiresAt\x12'\n" +
+	"\x0ftimeout_seconds\x18\x04 \x01(\x05R\x0etimeoutSeconds\x12E\n" +
+	"\n" +
+	"secret_env\x18\x05 \x03(\v2&.taskexec.v1.PickedTask.SecretEnvEntryR\tsecretEnv\x1a<\n" +
+	"\x0eSecretEnvEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\xb7\x01\n" +
+	"\x11FinishTaskRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x19\n" +
+	"\blease_id\x18\x02 \x01(\tR\aleaseId\x12\x16\n" +
+	"\x06status\x18\x03 \x01(\tR\x06status\x12 \n" +
+	"\texit_code\x18\x04 \x01(\x05H\x00R\bexitCode\x88\x01\x01\x12/\n" +
+	"\x06output\x18\x05 \x03(\v2\x17.taskexec.v1.OutputLineR\x06outputB\f\n" +
+	"\n" +
+	"_exit_code\"W\n" +
+	"\x15StreamTaskLogsRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x16\n" +
+	"\x06offset\x18\x02 \x01(\x03R\x06offset\x12\x16\n" +
+	"\x06follow\x18\x03 \x01(\bR\x06follow\"\x92\x01\n" +
+	"\bLogChunk\x12\x10\n" +
+	"\x03seq\x18\x01 \x01(\x03R\x03seq\x12\x18\n" +
+	"\aattempt\x18\x02 \x01(\x05R\aattempt\x12\x16\n" +
+	"\x06stream\x18\x03 \x01(\tR\x06stream\x12\x12\n" +
+	"\x04data\x18\x04 \x01(\tR\x04data\x12.\n" +
+	"\x04time\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\x04time\"\"\n" +
+	"\x10WatchTaskRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id2\xe9\x03\n" +
+	"\vTaskService\x12?\n" +
+	"\n" +
+	"CreateTask\x12\x1e.taskexec.v1.CreateTaskRequest\x1a\x11.taskexec.v1.Task\x12J\n" +
+	"\tListTasks\x12\x1d.taskexec.v1.ListTasksRequest\x1a\x1e.taskexec.v1.ListTasksResponse\x129\n" +
+	"\aGetTask\x12\x1b.taskexec.v1.GetTaskRequest\x1a\x11.taskexec.v1.Task\x12A\n" +
+	"\bPickTask\x12\x1c.taskexec.v1.PickTaskRequest\x1a\x17.taskexec.v1.PickedTask\x12?\n" +
+	"\n" +
+	"FinishTask\x12\x1e.taskexec.v1.FinishTaskRequest\x1a\x11.taskexec.v1.Task\x12M\n" +
+	"\x0eStreamTaskLogs\x12\".taskexec.v1.StreamTaskLogsRequest\x1a\x15.taskexec.v1.LogChunk0\x01\x12?\n" +
+	"\tWatchTask\x12\x1d.taskexec.v1.WatchTaskRequest\x1a\x11.taskexec.v1.Task0\x01B\x1bZ\x19backend-api-server/taskpbb\x06proto3"
+
+var (
+	file_taskpb_tasks_proto_rawDescOnce sync.Once
+	file_taskpb_tasks_proto_rawDescData []byte
+)
+
+func file_taskpb_tasks_proto_rawDescGZIP() []byte {
+	file_taskpb_tasks_proto_rawDescOnce.Do(func() {
+		file_taskpb_tasks_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_taskpb_tasks_proto_rawDesc), len(file_taskpb_tasks_proto_rawDesc)))
+	})
+	return file_taskpb_tasks_proto_rawDescData
+}
+
+var file_taskpb_tasks_proto_msgTypes = make([]protoimpl.MessageInfo, 21)
+var file_taskpb_tasks_proto_goTypes = []any{
+	(*BackoffPolicy)(nil),         // 0: taskexec.v1.BackoffPolicy
+	(*OutputLine)(nil),            // 1: taskexec.v1.OutputLine
+	(*Task)(nil),                  // 2: taskexec.v1.Task
+	(*CreateTaskRequest)(nil),     // 3: taskexec.v1.CreateTaskRequest
+	(*ListTasksRequest)(nil),      // 4: taskexec.v1.ListTasksRequest
+	(*ListTasksResponse)(nil),     // 5: taskexec.v1.ListTasksResponse
+	(*GetTaskRequest)(nil),        // 6: taskexec.v1.GetTaskRequest
+	(*PickTaskRequest)(nil),       // 7: taskexec.v1.PickTaskRequest
+	(*PickedTask)(nil),            // 8: taskexec.v1.PickedTask
+	(*FinishTaskRequest)(nil),     // 9: taskexec.v1.FinishTaskRequest
+	(*StreamTaskLogsRequest)(nil), // 10: taskexec.v1.StreamTaskLogsRequest
+	(*LogChunk)(nil),              // 11: taskexec.v1.LogChunk
+	(*WatchTaskRequest)(nil),      // 12: taskexec.v1.WatchTaskRequest
+	nil,                           // 13: taskexec.v1.Task.EnvEntry
+	nil,                           // 14: taskexec.v1.Task.SecretsEntry
+	nil,                           // 15: taskexec.v1.Task.LabelsEntry
+	nil,                           // 16: taskexec.v1.CreateTaskRequest.EnvEntry
+	nil,                           // 17: taskexec.v1.CreateTaskRequest.SecretsEntry
+	nil,                           // 18: taskexec.v1.CreateTaskRequest.LabelsEntry
+	nil,                           // 19: taskexec.v1.PickTaskRequest.LabelsEntry
+	nil,                           // 20: taskexec.v1.PickedTask.SecretEnvEntry
+	(*timestamppb.Timestamp)(nil), // 21: google.protobuf.Timestamp
+	(*durationpb.Duration)(nil),   // 22: google.protobuf.Duration
+}
+var file_taskpb_tasks_proto_depIdxs = []int32{
+	21, // 0: taskexec.v1.OutputLine.time:type_name -> google.protobuf.Timestamp
+	21, // 1: taskexec.v1.Task.started_at:type_name -> google.protobuf.Timestamp
+	21, // 2: taskexec.v1.Task.finished_at:type_name -> google.protobuf.Timestamp
+	1,  // 3: taskexec.v1.Task.output:type_name -> taskexec.v1.OutputLine
+	0,  // 4: taskexec.v1.Task.backoff:type_name -> taskexec.v1.BackoffPolicy
+	21, // 5: taskexec.v1.Task.not_before:type_name -> google.protobuf.Timestamp
+	21, // 6: taskexec.v1.Task.scheduled_for:type_name -> google.protobuf.Timestamp
+	13, // 7: taskexec.v1.Task.env:type_name -> taskexec.v1.Task.EnvEntry
+	14, // 8: taskexec.v1.Task.secrets:type_name -> taskexec.v1.Task.SecretsEntry
+	15, // 9: taskexec.v1.Task.labels:type_name -> taskexec.v1.Task.LabelsEntry
+	21, // 10: taskexec.v1.Task.date:type_name -> google.protobuf.Timestamp
+	21, // 11: taskexec.v1.Task.run_at:type_name -> google.protobuf.Timestamp
+	0,  // 12: taskexec.v1.CreateTaskRequest.backoff:type_name -> taskexec.v1.BackoffPolicy
+	21, // 13: taskexec.v1.CreateTaskRequest.run_at:type_name -> google.protobuf.Timestamp
+	16, // 14: taskexec.v1.CreateTaskRequest.env:type_name -> taskexec.v1.CreateTaskRequest.EnvEntry
+	17, // 15: taskexec.v1.CreateTaskRequest.secrets:type_name -> taskexec.v1.CreateTaskRequest.SecretsEntry
+	18, // 16: taskexec.v1.CreateTaskRequest.labels:type_name -> taskexec.v1.CreateTaskRequest.LabelsEntry
+	21, // 17: taskexec.v1.ListTasksRequest.created_after:type_name -> google.protobuf.Timestamp
+	21, // 18: taskexec.v1.ListTasksRequest.created_before:type_name -> google.protobuf.Timestamp
+	2,  // 19: taskexec.v1.ListTasksResponse.tasks:type_name -> taskexec.v1.Task
+	19, // 20: taskexec.v1.PickTaskRequest.labels:type_name -> taskexec.v1.PickTaskRequest.LabelsEntry
+	22, // 21: taskexec.v1.PickTaskRequest.wait:type_name -> google.protobuf.Duration
+	2,  // 22: taskexec.v1.PickedTask.task:type_name -> taskexec.v1.Task
+	21, // 23: taskexec.v1.PickedTask.lease_expires_at:type_name -> google.protobuf.Timestamp
+	20, // 24: taskexec.v1.PickedTask.secret_env:type_name -> taskexec.v1.PickedTask.SecretEnvEntry
+	1,  // 25: taskexec.v1.FinishTaskRequest.output:type_name -> taskexec.v1.OutputLine
+	21, // 26: taskexec.v1.LogChunk.time:type_name -> google.protobuf.Timestamp
+	3,  // 27: taskexec.v1.TaskService.CreateTask:input_type -> taskexec.v1.CreateTaskRequest
+	4,  // 28: taskexec.v1.TaskService.ListTasks:input_type -> taskexec.v1.ListTasksRequest
+	6,  // 29: taskexec.v1.TaskService.GetTask:input_type -> taskexec.v1.GetTaskRequest
+	7,  // 30: taskexec.v1.TaskService.PickTask:input_type -> taskexec.v1.PickTaskRequest
+	9,  // 31: taskexec.v1.TaskService.FinishTask:input_type -> taskexec.v1.FinishTaskRequest
+	10, // 32: taskexec.v1.TaskService.StreamTaskLogs:input_type -> taskexec.v1.StreamTaskLogsRequest
+	12, // 33: taskexec.v1.TaskService.WatchTask:input_type -> taskexec.v1.WatchTaskRequest
+	2,  // 34: taskexec.v1.TaskService.CreateTask:output_type -> taskexec.v1.Task
+	5,  // 35: taskexec.v1.TaskService.ListTasks:output_type -> taskexec.v1.ListTasksResponse
+	2,  // 36: taskexec.v1.TaskService.GetTask:output_type -> taskexec.v1.Task
+	8,  // 37: taskexec.v1.TaskService.PickTask:output_type -> taskexec.v1.PickedTask
+	2,  // 38: taskexec.v1.TaskService.FinishTask:output_type -> taskexec.v1.Task
+	11, // 39: taskexec.v1.TaskService.StreamTaskLogs:output_type -> taskexec.v1.LogChunk
+	2,  // 40: taskexec.v1.TaskService.WatchTask:output_type -> taskexec.v1.Task
+	34, // [34:41] is the sub-list for method output_type
+	27, // [27:34] is the sub-list for method input_type
+	27, // [27:27] is the sub-list for extension type_name
+	27, // [27:27] is the sub-list for extension extendee
+	0,  // [0:27] is the sub-list for field type_name
+}
+
+func init() { file_taskpb_tasks_proto_init() }
+func file_taskpb_tasks_proto_init() {
+	if File_taskpb_tasks_proto != nil {
+		return
+	}
+	file_taskpb_tasks_proto_msgTypes[2].OneofWrappers = []any{}
+	file_taskpb_tasks_proto_msgTypes[3].OneofWrappers = []any{}
+	file_taskpb_tasks_proto_msgTypes[4].OneofWrappers = []any{}
+	file_taskpb_tasks_proto_msgTypes[9].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_taskpb_tasks_proto_rawDesc), len(file_taskpb_tasks_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   21,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_taskpb_tasks_proto_goTypes,
+		DependencyIndexes: file_taskpb_tasks_proto_depIdxs,
+		MessageInfos:      file_taskpb_tasks_proto_msgTypes,
+	}.Build()
+	File_taskpb_tasks_proto = out.File
+	file_taskpb_tasks_proto_goTypes = nil
+	file_taskpb_tasks_proto_depIdxs = nil
+}
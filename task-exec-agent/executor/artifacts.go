@@ -0,0 +1,146 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// collectArtifacts resolves the artifact patterns of the task to the regular
+// files they match in its working directory. The names are the paths of the
+// files relative to that directory. Symlinks are skipped, as are files reached
+// through a symlinked directory, so that a task cannot upload files from
+// outside of its working directory.
+func collectArtifacts(task Task) ([]string, error) {
+	dir := task.Workdir
+	if dir == "" {
+		dir = "."
+	}
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	names := []string{}
+	for _, pattern := range task.Artifacts {
+		matches, err := filepath.Glob(filepath.Join(dir, filepath.FromSlash(pattern)))
+		if err != nil {
+			return nil, fmt.Errorf("invalid artifact pattern %q: %w", pattern, err)
+		}
+		for _, match := range matches {
+			info, err := os.Lstat(match)
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			name, err := filepath.Rel(dir, match)
+			if err != nil {
+				continue
+			}
+			resolved, err := filepath.EvalSymlinks(match)
+			if err != nil || resolved != filepath.Join(root, name) {
+				continue
+			}
+			name = filepath.ToSlash(name)
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// uploadArtifacts sends the artifacts of the task to the server in a single
+// multipart request, streaming the files without buffering them in memory.
+func (e *Executor) uploadArtifacts(task Task) error {
+	names, err := collectArtifacts(task)
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		log.Debugf("No artifacts matched for task %s", task.ID)
+		return nil
+	}
+	dir := task.Workdir
+	if dir == "" {
+		dir = "."
+	}
+
+	body, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+	go func() {
+		writer.CloseWithError(writeArtifactsForm(form, task.LeaseID, dir, names))
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.cfg.ArtifactUploadTimeout)
+	defer cancel()
+	artifactsURL := "http://" + e.cfg.BackendHost + ":" + e.cfg.BackendPort + "/tasks/" + task.ID + "/artifacts"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, artifactsURL, body)
+	if err != nil {
+		body.Close()
+		return err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	// The request is bounded by its context, uploads can take longer than
+	// the timeout of the regular client.
+	client := &http.Client{Transport: e.client.Transport}
+	log.Infof("Uploading %d artifacts of task %s", len(names), task.ID)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return errors.New(resp.Status + ": " + string(msg))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+func writeArtifactsForm(form *multipart.Writer, leaseID, dir string, names []string) error {
+	if err := form.WriteField("lease_id", leaseID); err != nil {
+		return err
+	}
+	for _, name := range names {
+		part, err := form.CreateFormFile("file", name)
+		if err != nil {
+			return err
+		}
+		// The file may have been replaced by a symlink since it was
+		// collected.
+		file, err := os.OpenFile(filepath.Join(dir, filepath.FromSlash(name)), os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(part, file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return form.Close()
+}
+
+// recordUploadFailure reports a failed upload in the output of the task, so
+// that users can see why artifacts are missing.
+func recordUploadFailure(result *TaskResult, err error) {
+	result.Output = append(result.Output, outputLine{
+		Stream: streamStderr,
+		Data:   "failed to upload artifacts: " + err.Error() + "\n",
+		Time:   time.Now(),
+	})
+}
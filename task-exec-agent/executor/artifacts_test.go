@@ -0,0 +1,32 @@
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollectArtifacts(t *testing.T) {
+	assert := assert.New(t)
+
+	outside := t.TempDir()
+	assert.NoError(os.WriteFile(filepath.Join(outside, "passwd"), []byte("root"), 0o644))
+
+	dir := t.TempDir()
+	assert.NoError(os.MkdirAll(filepath.Join(dir, "dist", "nested"), 0o755))
+	assert.NoError(os.WriteFile(filepath.Join(dir, "dist", "app.tar.gz"), []byte("app"), 0o644))
+	assert.NoError(os.WriteFile(filepath.Join(dir, "dist", "nested", "report.txt"), []byte("ok"), 0o644))
+	assert.NoError(os.Symlink(filepath.Join(outside, "passwd"), filepath.Join(dir, "dist", "passwd")))
+	assert.NoError(os.Symlink(outside, filepath.Join(dir, "linked")))
+
+	// Regular files only, symlinks and files behind them are skipped
+	task := Task{Workdir: dir, Artifacts: []string{"dist/*", "dist/*/*", "linked/*", "dist/app.tar.gz"}}
+	names, err := collectArtifacts(task)
+	assert.NoError(err)
+	assert.Equal([]string{"dist/app.tar.gz", "dist/nested/report.txt"}, names)
+
+	_, err = collectArtifacts(Task{Workdir: dir, Artifacts: []string{"dist/[a-"}})
+	assert.Error(err)
+}
@@ -1,7 +1,11 @@
 package executor
 
 import (
+	"fmt"
 	"log"
+	"os"
+	"reflect"
+	"strings"
 	"time"
 
 	"github.com/caarlos0/env"
@@ -11,13 +15,69 @@ type Config struct {
 	BackendHost  string        `env:"BACKEND_API_HOST,required"`
 	BackendPort  string        `env:"BACKEND_API_PORT,required"`
 	PollInterval time.Duration `env:"POLL_INTERVAL,required"`
+	// PickWait is how long the server holds a pick request until a task is
+	// queued, zero disables long polling.
+	PickWait time.Duration `env:"PICK_WAIT" envDefault:"30s"`
+	// DispatchMode is "pull" to poll for tasks, or "push" to get them
+	// assigned over a socket. Push mode falls back to polling for
+	// SocketRetryInterval whenever the socket cannot be opened.
+	DispatchMode        string        `env:"DISPATCH_MODE" envDefault:"pull"`
+	SocketRetryInterval time.Duration `env:"SOCKET_RETRY_INTERVAL" envDefault:"30s"`
+
+	// AgentID identifies the agent to the server, the hostname by default.
+	AgentID      string   `env:"AGENT_ID"`
+	Capabilities []string `env:"CAPABILITIES" envSeparator:","`
+	// Labels are matched against the label selectors of tasks, the agent
+	// only picks tasks whose labels it has all of. They are given as
+	// LABELS=os=linux,tool=terraform.
+	Labels map[string]string `env:"LABELS"`
+	// Queues are the queues the agent picks tasks from.
+	Queues []string `env:"QUEUES" envSeparator:"," envDefault:"default"`
+
+	HeartbeatInterval time.Duration `env:"HEARTBEAT_INTERVAL" envDefault:"10s"`
+	LogFlushInterval  time.Duration `env:"LOG_FLUSH_INTERVAL" envDefault:"1s"`
+
+	ArtifactUploadTimeout time.Duration `env:"ARTIFACT_UPLOAD_TIMEOUT" envDefault:"10m"`
+
+	// BundleDownloadTimeout limits the download of the input files of a
+	// task, MaxBundleSize the total size of the files unpacked from them.
+	BundleDownloadTimeout time.Duration `env:"BUNDLE_DOWNLOAD_TIMEOUT" envDefault:"10m"`
+	MaxBundleSize         int64         `env:"MAX_BUNDLE_SIZE" envDefault:"4294967296"`
+	// WorkspaceDir is where the working directories of tasks with input
+	// files are created, the temporary directory by default.
+	WorkspaceDir string `env:"WORKSPACE_DIR"`
 }
 
 func NewConfig() *Config {
 	cfg := Config{}
-	if err := env.Parse(&cfg); err != nil {
+	parsers := env.CustomParsers{
+		reflect.TypeOf(map[string]string{}): parseLabels,
+	}
+	if err := env.ParseWithFuncs(&cfg, parsers); err != nil {
 		log.Fatalf("Failed to parse env: %v", err)
 	}
+	if cfg.DispatchMode != dispatchPull && cfg.DispatchMode != dispatchPush {
+		log.Fatalf("DISPATCH_MODE must be %s or %s", dispatchPull, dispatchPush)
+	}
+	if cfg.AgentID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			log.Fatalf("Failed to get hostname: %v", err)
+		}
+		cfg.AgentID = hostname
+	}
 
 	return &cfg
 }
+
+func parseLabels(value string) (interface{}, error) {
+	labels := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("label %q must be given as key=value", pair)
+		}
+		labels[key] = val
+	}
+	return labels, nil
+}
@@ -2,10 +2,17 @@ package executor
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"os"
 	"os/exec"
+	"sync"
+	"syscall"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -13,16 +20,23 @@ import (
 
 type Executor struct {
 	client *http.Client
-	cfg    *Config
+	// pickClient outlasts the time the server holds pick requests.
+	pickClient *http.Client
+	cfg        *Config
 }
 
 func New(cfg *Config) *Executor {
+	transport := &http.Transport{}
 	e := Executor{
 		cfg: cfg,
 		client: &http.Client{
-			Transport: &http.Transport{},
+			Transport: transport,
 			Timeout:   10 * time.Second,
 		},
+		pickClient: &http.Client{
+			Transport: transport,
+			Timeout:   cfg.PickWait + 10*time.Second,
+		},
 	}
 
 	return &e
@@ -32,84 +46,319 @@ const (
 	pickTaskPath = "/tasks/pick"
 )
 
+const (
+	statusFinished   = "finished"
+	statusFailed     = "failed"
+	statusCancelling = "cancelling"
+	statusCancelled  = "cancelled"
+	statusTimedOut   = "timed_out"
+)
+
+var (
+	errTaskCancelled = errors.New("task was cancelled")
+	errLeaseLost     = errors.New("task lease was lost")
+	errTaskTimedOut  = errors.New("task timed out")
+)
+
 type Task struct {
-	ID      string `json:"id"`
-	Command string `json:"command"`
+	ID             string            `json:"id"`
+	Command        string            `json:"command"`
+	LeaseID        string            `json:"lease_id"`
+	TimeoutSeconds int               `json:"timeout_seconds"`
+	Env            map[string]string `json:"env"`
+	Workdir        string            `json:"workdir"`
+	SecretEnv      map[string]string `json:"secret_env"`
+	Artifacts      []string          `json:"artifacts"`
+	BundleSize     *int64            `json:"bundle_size"`
 }
 
+// TaskResult reports the outcome of a task, the server derives stdout and
+// stderr from its output lines.
 type TaskResult struct {
-	Status   string `json:"status"`
-	Stdout   string `json:"stdout,omitempty"`
-	Stderr   string `json:"stderr,omitempty"`
-	ExitCode *int   `json:"exit_code"`
+	Status   string       `json:"status"`
+	Output   []outputLine `json:"output"`
+	ExitCode *int         `json:"exit_code"`
+	LeaseID  string       `json:"lease_id,omitempty"`
 }
 
+type taskHeartbeat struct {
+	LeaseID string `json:"lease_id"`
+}
+
+type taskLease struct {
+	Status string `json:"status"`
+}
+
+// Run runs tasks one after the other, picking them in pull mode or getting
+// them assigned over the agent socket in push mode.
 func (e *Executor) Run() {
 	log.Info("Executor started running")
-	ticker := time.NewTicker(e.cfg.PollInterval)
+	go e.runAgentHeartbeat()
+
+	if e.cfg.DispatchMode == dispatchPush {
+		e.runPush()
+		return
+	}
+	for {
+		e.poll()
+	}
+}
+
+// poll picks a task and runs it. Picks wait on the server for a task to be
+// queued, they are at least PollInterval apart unless a task was picked, so
+// that servers without long polling are not flooded.
+func (e *Executor) poll() {
+	start := time.Now()
+	task, err := e.pickTask()
+	if err != nil {
+		log.Errorf("error picking task: %v", err)
+	}
+	if task == nil {
+		time.Sleep(e.cfg.PollInterval - time.Since(start))
+		return
+	}
+	e.runTask(*task, e)
+}
+
+// pickTask returns the task leased to the agent, or nil if none was queued
+// within PickWait.
+func (e *Executor) pickTask() (*Task, error) {
+	log.Info("Picking a task")
+	query := e.pickQuery()
+	if e.cfg.PickWait > 0 {
+		query += "&wait=" + url.QueryEscape(e.cfg.PickWait.String())
+	}
+	pickURL := "http://" + e.cfg.BackendHost + ":" + e.cfg.BackendPort + pickTaskPath + "?" + query
+	resp, err := e.pickClient.Get(pickURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		log.Debugf("Picking returned status code: %d", resp.StatusCode)
+		io.Copy(io.Discard, resp.Body)
+		return nil, nil
+	}
+
+	var task Task
+	if err := json.NewDecoder(resp.Body).Decode(&task); err != nil {
+		return nil, fmt.Errorf("decoding picked task: %w", err)
+	}
+	return &task, nil
+}
+
+// pickQuery identifies the agent, its labels and its queues to the server,
+// which only hands out tasks of those queues the agent satisfies the label
+// selector of.
+func (e *Executor) pickQuery() string {
+	query := url.Values{}
+	query.Set("agent_id", e.cfg.AgentID)
+	for _, queue := range e.cfg.Queues {
+		query.Add("queue", queue)
+	}
+	for key, value := range e.cfg.Labels {
+		query.Add("label", key+"="+value)
+	}
+	return query.Encode()
+}
+
+func (e *Executor) runTask(task Task, reporter taskReporter) {
+	log.Infof("Executing task %s: %s", task.ID, task.Command)
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	go e.heartbeat(ctx, task, reporter, cancel)
+
+	execCtx := ctx
+	if task.TimeoutSeconds > 0 {
+		timeout := time.Duration(task.TimeoutSeconds) * time.Second
+		var cancelTimeout context.CancelFunc
+		execCtx, cancelTimeout = context.WithTimeoutCause(ctx, timeout, errTaskTimedOut)
+		defer cancelTimeout()
+	}
+
+	if task.BundleSize != nil {
+		dir, err := e.prepareWorkspace(task)
+		if dir != "" {
+			defer cleanupWorkspace(dir)
+		}
+		if err != nil {
+			log.Errorf("error preparing workspace of task %s: %v", task.ID, err)
+			result := TaskResult{
+				Status: statusFailed,
+				Output: []outputLine{{
+					Stream: streamStderr,
+					Data:   "failed to prepare input files: " + err.Error() + "\n",
+					Time:   time.Now(),
+				}},
+				ExitCode: intPointer(1),
+				LeaseID:  task.LeaseID,
+			}
+			finishTask(reporter, task, result)
+			return
+		}
+		task.Workdir = dir
+	}
+
+	logs := e.newLogStreamer(task)
+	go logs.run()
+	result := executeCommand(execCtx, task, logs.write)
+	logs.close()
+	if errors.Is(context.Cause(ctx), errLeaseLost) {
+		log.Warnf("Dropping result of task %s, its lease was lost", task.ID)
+		return
+	}
+	result.LeaseID = task.LeaseID
+
+	if len(task.Artifacts) > 0 {
+		if err := e.uploadArtifacts(task); err != nil {
+			log.Errorf("error uploading artifacts of task %s: %v", task.ID, err)
+			recordUploadFailure(&result, err)
+		}
+	}
+
+	finishTask(reporter, task, result)
+}
+
+func finishTask(reporter taskReporter, task Task, result TaskResult) {
+	if err := reporter.reportResult(task, result); err != nil {
+		log.Errorf("error finishing task %s: %v", task.ID, err)
+	}
+}
+
+// taskReporter sends the heartbeats and the result of a running task to the
+// server, over plain requests in pull mode or over the agent socket in push
+// mode.
+type taskReporter interface {
+	// renewLease fails with errLeaseLost once the server has taken the task
+	// back from this agent.
+	renewLease(ctx context.Context, task Task) (taskLease, error)
+	reportResult(task Task, result TaskResult) error
+}
+
+// heartbeat renews the lease of the task while it runs. It cancels ctx once a
+// user has requested the abortion of the task, or when the server has taken
+// the task back from this agent.
+func (e *Executor) heartbeat(ctx context.Context, task Task, reporter taskReporter, cancel context.CancelCauseFunc) {
+	ticker := time.NewTicker(e.cfg.HeartbeatInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
+		case <-ctx.Done():
+			return
 		case <-ticker.C:
-			log.Info("Picking a task")
-			pickURL := "http://" + e.cfg.BackendHost + ":" + e.cfg.BackendPort + pickTaskPath
-			resp, err := e.client.Get(pickURL)
+			lease, err := reporter.renewLease(ctx, task)
+			if errors.Is(err, errLeaseLost) {
+				log.Warnf("Task %s is no longer leased to this agent, killing its command", task.ID)
+				cancel(errLeaseLost)
+				return
+			}
 			if err != nil {
-				log.Errorf("error picking task: %v", err)
+				log.Errorf("error sending heartbeat: %v", err)
 				continue
 			}
 
-			if resp.StatusCode != http.StatusOK {
-				log.Debugf("Picking returned status code: %d", resp.StatusCode)
-				io.Copy(io.Discard, resp.Body)
-				resp.Body.Close()
-				continue
+			if lease.Status == statusCancelling {
+				log.Infof("Task %s was aborted, killing its command", task.ID)
+				cancel(errTaskCancelled)
+				return
 			}
+		}
+	}
+}
 
-			var task Task
-			if err := json.NewDecoder(resp.Body).Decode(&task); err != nil {
-				log.Errorf("error decoding picked task: %v", err)
-				resp.Body.Close()
-				continue
-			}
-			resp.Body.Close()
+func (e *Executor) renewLease(ctx context.Context, task Task) (taskLease, error) {
+	body, err := json.Marshal(taskHeartbeat{LeaseID: task.LeaseID})
+	if err != nil {
+		return taskLease{}, err
+	}
+	heartbeatURL := "http://" + e.cfg.BackendHost + ":" + e.cfg.BackendPort + "/tasks/" + task.ID + "/heartbeat"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, heartbeatURL, bytes.NewReader(body))
+	if err != nil {
+		return taskLease{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return taskLease{}, err
+	}
+	defer resp.Body.Close()
+	defer io.Copy(io.Discard, resp.Body)
 
-			log.Infof("Executing task %s: %s", task.ID, task.Command)
-			result := executeCommand(task.Command)
+	if resp.StatusCode == http.StatusConflict || resp.StatusCode == http.StatusNotFound {
+		return taskLease{}, errLeaseLost
+	}
+	if resp.StatusCode != http.StatusOK {
+		return taskLease{}, fmt.Errorf("heartbeat returned status: %s", resp.Status)
+	}
 
-			e.finishTask(task.ID, result)
-		}
+	var lease taskLease
+	if err := json.NewDecoder(resp.Body).Decode(&lease); err != nil {
+		return taskLease{}, fmt.Errorf("decoding heartbeat response: %w", err)
 	}
+	return lease, nil
 }
 
-func executeCommand(command string) TaskResult {
+// executeCommand runs the command of the task, passing each line of its
+// output to onLine as it is produced.
+func executeCommand(ctx context.Context, task Task, onLine func(outputLine)) TaskResult {
 	exitCode := 0
+	output := &outputRecorder{secretEnv: task.SecretEnv, onLine: onLine}
 
-	cmd := exec.Command("sh", "-c", command)
-	log.Debugf("Executing command: %+v", cmd)
+	cmd := exec.CommandContext(ctx, "sh", "-c", task.Command)
+	cmd.Env = os.Environ()
+	for name, value := range task.Env {
+		cmd.Env = append(cmd.Env, name+"="+value)
+	}
+	for name, value := range task.SecretEnv {
+		cmd.Env = append(cmd.Env, name+"="+value)
+	}
+	cmd.Dir = task.Workdir
+	// Run the shell in its own process group so that an abort or a timeout kills
+	// every process it spawned, not only the shell itself.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	// The environment is not logged, it contains the secrets of the task.
+	log.Debugf("Executing command: %q in %q", cmd.Args, cmd.Dir)
 
 	stdoutPipe, err := cmd.StdoutPipe()
 	if err != nil {
 		errMsg := "failed to get stdout pipe: " + err.Error()
 		log.Error(errMsg)
-		return TaskResult{Status: "failed", Stderr: errMsg, ExitCode: intPointer(1)}
+		output.record(streamStderr, errMsg)
+		return TaskResult{Status: statusFailed, Output: output.lines, ExitCode: intPointer(1)}
 	}
 	stderrPipe, err := cmd.StderrPipe()
 	if err != nil {
 		errMsg := "failed to get stderr pipe: " + err.Error()
 		log.Error(errMsg)
-		return TaskResult{Status: "failed", Stderr: errMsg, ExitCode: intPointer(1)}
+		output.record(streamStderr, errMsg)
+		return TaskResult{Status: statusFailed, Output: output.lines, ExitCode: intPointer(1)}
 	}
 
 	if err := cmd.Start(); err != nil {
 		errMsg := "failed to start command: " + err.Error()
 		log.Error(errMsg)
-		return TaskResult{Status: "failed", Stderr: errMsg, ExitCode: intPointer(1)}
+		output.record(streamStderr, errMsg)
+		return TaskResult{Status: statusFailed, Output: output.lines, ExitCode: intPointer(1)}
 	}
 
-	stdoutBytes, _ := io.ReadAll(stdoutPipe)
-	stderrBytes, _ := io.ReadAll(stderrPipe)
+	// Both pipes are drained concurrently, a command filling one of them must
+	// not block while the other is being read.
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		output.readStream(stdoutPipe, streamStdout)
+	}()
+	go func() {
+		defer wg.Done()
+		output.readStream(stderrPipe, streamStderr)
+	}()
+	wg.Wait()
 
 	err = cmd.Wait()
 	if err != nil {
@@ -121,11 +370,20 @@ func executeCommand(command string) TaskResult {
 	}
 
 	taskResult := TaskResult{
-		Status:   "finished",
-		Stdout:   string(stdoutBytes),
-		Stderr:   string(stderrBytes),
+		Status:   statusFinished,
+		Output:   output.lines,
 		ExitCode: intPointer(exitCode),
 	}
+	switch cause := context.Cause(ctx); {
+	case errors.Is(cause, errTaskCancelled):
+		taskResult.Status = statusCancelled
+		log.Debugf("Task has been cancelled: %+v", taskResult)
+		return taskResult
+	case errors.Is(cause, errTaskTimedOut):
+		taskResult.Status = statusTimedOut
+		log.Debugf("Task has timed out: %+v", taskResult)
+		return taskResult
+	}
 	log.Debugf("Task has successfuly executed: %+v", taskResult)
 
 	return taskResult
@@ -135,32 +393,30 @@ func intPointer(i int) *int {
 	return &i
 }
 
-func (e *Executor) finishTask(taskID string, result TaskResult) {
-	finishURL := "http://" + e.cfg.BackendHost + ":" + e.cfg.BackendPort + "/tasks/" + taskID + "/finish"
+func (e *Executor) reportResult(task Task, result TaskResult) error {
+	finishURL := "http://" + e.cfg.BackendHost + ":" + e.cfg.BackendPort + "/tasks/" + task.ID + "/finish"
 
 	body, err := json.Marshal(result)
 	if err != nil {
-		log.Errorf("error marshaling task result: %v", err)
-		return
+		return err
 	}
 
 	req, err := http.NewRequest(http.MethodPost, finishURL, bytes.NewReader(body))
 	if err != nil {
-		log.Errorf("error creating finish request: %v", err)
-		return
+		return err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 	log.Debugf("Sending finished task: %+v", result)
 	resp, err := e.client.Do(req)
 	if err != nil {
-		log.Errorf("error sending finish request: %v", err)
-		return
+		return err
 	}
 	io.Copy(io.Discard, resp.Body)
 	resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		log.Printf("Finish request for task %s returned status: %s", taskID, resp.Status)
+		return fmt.Errorf("finish request returned status: %s", resp.Status)
 	}
+	return nil
 }
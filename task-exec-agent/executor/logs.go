@@ -0,0 +1,105 @@
+package executor
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type taskLogs struct {
+	LeaseID string       `json:"lease_id"`
+	Chunks  []outputLine `json:"chunks"`
+}
+
+// logStreamer batches the output of a running task and ships it to the server
+// every LogFlushInterval, so that users can follow the task while it runs.
+type logStreamer struct {
+	e    *Executor
+	task Task
+
+	mu      sync.Mutex
+	pending []outputLine
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func (e *Executor) newLogStreamer(task Task) *logStreamer {
+	return &logStreamer{
+		e:    e,
+		task: task,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+// write queues a line of output.
+func (l *logStreamer) write(line outputLine) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pending = append(l.pending, line)
+}
+
+func (l *logStreamer) run() {
+	defer close(l.done)
+	ticker := time.NewTicker(l.e.cfg.LogFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			l.flush()
+			return
+		case <-ticker.C:
+			l.flush()
+		}
+	}
+}
+
+// close sends the remaining output and waits for it to be delivered, so that
+// every chunk reaches the server before the task is finished.
+func (l *logStreamer) close() {
+	close(l.stop)
+	<-l.done
+}
+
+// flush sends the queued chunks. Chunks that cannot be delivered are dropped,
+// the complete output is still part of the task result.
+func (l *logStreamer) flush() {
+	l.mu.Lock()
+	chunks := l.pending
+	l.pending = nil
+	l.mu.Unlock()
+	if len(chunks) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(taskLogs{LeaseID: l.task.LeaseID, Chunks: chunks})
+	if err != nil {
+		log.Errorf("error marshaling task logs: %v", err)
+		return
+	}
+	logsURL := "http://" + l.e.cfg.BackendHost + ":" + l.e.cfg.BackendPort + "/tasks/" + l.task.ID + "/logs"
+	req, err := http.NewRequest(http.MethodPost, logsURL, bytes.NewReader(body))
+	if err != nil {
+		log.Errorf("error creating logs request: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := l.e.client.Do(req)
+	if err != nil {
+		log.Errorf("error sending task logs: %v", err)
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		log.Warnf("Logs request for task %s returned status: %s", l.task.ID, resp.Status)
+	}
+}
@@ -0,0 +1,114 @@
+package executor
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	streamStdout = "stdout"
+	streamStderr = "stderr"
+)
+
+// maxLineBytes bounds the memory taken by a single line, longer lines are
+// split into several records.
+const maxLineBytes = 64 * 1024
+
+// outputLine is a line of output of the command, stamped with the stream it
+// was written to and the time the agent read it.
+type outputLine struct {
+	Stream string    `json:"stream"`
+	Data   string    `json:"data"`
+	Time   time.Time `json:"time"`
+}
+
+// outputRecorder collects the lines of both streams of a command in a single
+// sequence, in the order they were read.
+type outputRecorder struct {
+	secretEnv map[string]string
+	onLine    func(outputLine)
+
+	mu    sync.Mutex
+	lines []outputLine
+}
+
+func (o *outputRecorder) record(stream, data string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	line := outputLine{
+		Stream: stream,
+		Data:   maskSecrets(data, o.secretEnv),
+		Time:   time.Now(),
+	}
+	o.lines = append(o.lines, line)
+	if o.onLine != nil {
+		o.onLine(line)
+	}
+}
+
+// readStream records the output of a stream line by line until the stream is
+// closed. Lines longer than the buffer are recorded in parts, the end of a
+// part that may hold the beginning of a secret is held back and recorded with
+// the next part, so that values crossing the boundary are masked as well.
+func (o *outputRecorder) readStream(r io.Reader, stream string) {
+	reader := bufio.NewReaderSize(r, maxLineBytes)
+	carry := ""
+	for {
+		line, err := reader.ReadSlice('\n')
+		data := carry + string(line)
+		carry = ""
+		if errors.Is(err, bufio.ErrBufferFull) {
+			data, carry = splitMaskable(data, o.secretEnv)
+		}
+		if len(data) > 0 {
+			o.record(stream, data)
+		}
+		if err != nil && !errors.Is(err, bufio.ErrBufferFull) {
+			return
+		}
+	}
+}
+
+// splitMaskable splits a part of a line into the head that can be masked and
+// recorded right away, and the tail that may continue into a secret value in
+// the next part.
+func splitMaskable(data string, secretEnv map[string]string) (string, string) {
+	longest := 0
+	for _, value := range secretEnv {
+		longest = max(longest, len(value))
+	}
+	if longest == 0 {
+		return data, ""
+	}
+	cut := max(len(data)-longest+1, 0)
+	// A value crossing the cut is moved to the tail entirely, which may make
+	// another value cross the new cut.
+	for moved := true; moved; {
+		moved = false
+		for _, value := range secretEnv {
+			if value == "" {
+				continue
+			}
+			from := max(cut-len(value)+1, 0)
+			if i := strings.Index(data[from:], value); i >= 0 && from+i < cut {
+				cut = from + i
+				moved = true
+			}
+		}
+	}
+	return data[:cut], data[cut:]
+}
+
+// maskSecrets hides the values of the secrets in the output of the command.
+func maskSecrets(output string, secretEnv map[string]string) string {
+	for _, value := range secretEnv {
+		if value != "" {
+			output = strings.ReplaceAll(output, value, "***")
+		}
+	}
+	return output
+}
@@ -0,0 +1,50 @@
+package executor
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadStreamMasksSecrets(t *testing.T) {
+	assert := assert.New(t)
+
+	secret := "s3cr3t-t0k3n"
+	recorder := &outputRecorder{secretEnv: map[string]string{"TOKEN": secret}}
+
+	// The secret crosses the boundary of the line buffer
+	long := strings.Repeat("a", maxLineBytes-5) + secret + strings.Repeat("b", 10) + "\n"
+	recorder.readStream(strings.NewReader("token is "+secret+"\n"+long+"tail "+secret), streamStdout)
+
+	var output strings.Builder
+	for _, line := range recorder.lines {
+		assert.NotContains(line.Data, secret)
+		assert.Equal(streamStdout, line.Stream)
+		output.WriteString(line.Data)
+	}
+	expected := "token is ***\n" + strings.Repeat("a", maxLineBytes-5) + "***" + strings.Repeat("b", 10) + "\ntail ***"
+	assert.Equal(expected, output.String())
+	assert.Greater(len(recorder.lines), 3)
+}
+
+func TestSplitMaskable(t *testing.T) {
+	assert := assert.New(t)
+
+	secretEnv := map[string]string{"A": "abcd", "B": "cdxyz"}
+
+	// The tail may start a secret
+	head, tail := splitMaskable("0123456789", secretEnv)
+	assert.Equal("012345", head)
+	assert.Equal("6789", tail)
+
+	// Secrets crossing the cut are moved to the tail
+	head, tail = splitMaskable("0123abcdxy", secretEnv)
+	assert.Equal("0123", head)
+	assert.Equal("abcdxy", tail)
+
+	// Nothing is held back without secrets
+	head, tail = splitMaskable("0123", nil)
+	assert.Equal("0123", head)
+	assert.Empty(tail)
+}
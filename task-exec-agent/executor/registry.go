@@ -0,0 +1,91 @@
+package executor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Version of the agent, set at build time.
+var Version = "dev"
+
+type agentRegistration struct {
+	Hostname     string            `json:"hostname"`
+	Version      string            `json:"version"`
+	Capabilities []string          `json:"capabilities"`
+	Labels       map[string]string `json:"labels"`
+}
+
+func (e *Executor) agentURL() string {
+	return "http://" + e.cfg.BackendHost + ":" + e.cfg.BackendPort + "/agents/" + url.PathEscape(e.cfg.AgentID)
+}
+
+// register announces the agent to the server, registering again after a
+// restart only updates its details.
+func (e *Executor) register() error {
+	hostname, _ := os.Hostname()
+	body, err := json.Marshal(agentRegistration{
+		Hostname:     hostname,
+		Version:      Version,
+		Capabilities: e.cfg.Capabilities,
+		Labels:       e.cfg.Labels,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, e.agentURL(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("registration returned status: %s", resp.Status)
+	}
+	log.Infof("Registered as agent %s", e.cfg.AgentID)
+	return nil
+}
+
+// runAgentHeartbeat keeps the agent registered and online for as long as it
+// runs. The agent registers again whenever the server does not know it.
+func (e *Executor) runAgentHeartbeat() {
+	ticker := time.NewTicker(e.cfg.HeartbeatInterval)
+	defer ticker.Stop()
+
+	registered := false
+	for {
+		if !registered {
+			if err := e.register(); err != nil {
+				log.Errorf("error registering agent: %v", err)
+			} else {
+				registered = true
+			}
+		} else {
+			resp, err := e.client.Post(e.agentURL()+"/heartbeat", "application/json", nil)
+			if err != nil {
+				log.Errorf("error sending agent heartbeat: %v", err)
+			} else {
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				if resp.StatusCode == http.StatusNotFound {
+					log.Warn("Agent is unknown to the server, registering again")
+					registered = false
+					continue
+				}
+			}
+		}
+		<-ticker.C
+	}
+}
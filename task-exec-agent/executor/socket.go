@@ -0,0 +1,247 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	dispatchPull = "pull"
+	dispatchPush = "push"
+)
+
+// Types of the messages exchanged over the agent socket. The agent sends ready
+// whenever it is idle and the server assigns it the next queued task.
+// Heartbeats and results are answered by the server, replies carry the id of
+// the message they answer.
+const (
+	socketReady     = "ready"
+	socketHeartbeat = "heartbeat"
+	socketResult    = "result"
+
+	socketAssign    = "assign"
+	socketLease     = "lease"
+	socketCancel    = "cancel"
+	socketLeaseLost = "lease_lost"
+	socketFinished  = "finished"
+)
+
+const (
+	// socketReadTimeout outlasts the interval the server pings the agent at,
+	// a socket without any message for longer is considered dropped.
+	socketReadTimeout  = 75 * time.Second
+	socketWriteTimeout = 10 * time.Second
+	socketReplyTimeout = 10 * time.Second
+)
+
+var errSocketClosed = errors.New("agent socket is closed")
+
+type socketMessage struct {
+	Type    string      `json:"type"`
+	ID      string      `json:"id,omitempty"`
+	TaskID  string      `json:"task_id,omitempty"`
+	LeaseID string      `json:"lease_id,omitempty"`
+	Task    *Task       `json:"task,omitempty"`
+	Lease   *taskLease  `json:"lease,omitempty"`
+	Result  *TaskResult `json:"result,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// agentSocket is the connection of an agent in push mode. It reports the
+// heartbeats and results of tasks over the socket, and over plain requests
+// once the socket has dropped.
+type agentSocket struct {
+	e           *Executor
+	conn        *websocket.Conn
+	assignments chan Task
+	closed      chan struct{}
+
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	nextID  int
+	pending map[string]chan socketMessage
+}
+
+// runPush runs the tasks assigned over the agent socket. While the socket
+// cannot be opened, the agent polls for tasks instead.
+func (e *Executor) runPush() {
+	for {
+		socket, err := e.openSocket()
+		if err != nil {
+			log.Errorf("error opening agent socket, polling for %s: %v", e.cfg.SocketRetryInterval, err)
+			for retryAt := time.Now().Add(e.cfg.SocketRetryInterval); time.Now().Before(retryAt); {
+				e.poll()
+			}
+			continue
+		}
+		log.Info("Agent socket opened, waiting for assigned tasks")
+		socket.serve()
+		log.Warn("Agent socket closed, reconnecting")
+		time.Sleep(e.cfg.PollInterval)
+	}
+}
+
+func (e *Executor) socketURL() string {
+	return "ws://" + e.cfg.BackendHost + ":" + e.cfg.BackendPort + "/agents/" + url.PathEscape(e.cfg.AgentID) + "/socket?" + e.pickQuery()
+}
+
+func (e *Executor) openSocket() (*agentSocket, error) {
+	dialer := websocket.Dialer{HandshakeTimeout: e.client.Timeout}
+	conn, _, err := dialer.Dial(e.socketURL(), nil)
+	if err != nil {
+		return nil, err
+	}
+	socket := &agentSocket{
+		e:           e,
+		conn:        conn,
+		assignments: make(chan Task, 1),
+		closed:      make(chan struct{}),
+		pending:     map[string]chan socketMessage{},
+	}
+	go socket.readMessages()
+	return socket, nil
+}
+
+// serve runs the tasks assigned over the socket until it drops.
+func (a *agentSocket) serve() {
+	defer a.conn.Close()
+	for {
+		if err := a.send(socketMessage{Type: socketReady}); err != nil {
+			return
+		}
+		select {
+		case task := <-a.assignments:
+			a.e.runTask(task, a)
+		case <-a.closed:
+			return
+		}
+	}
+}
+
+// readMessages hands assigned tasks to serve and replies to the requests
+// waiting for them, until the socket drops.
+func (a *agentSocket) readMessages() {
+	defer close(a.closed)
+	defer a.conn.Close()
+
+	_ = a.conn.SetReadDeadline(time.Now().Add(socketReadTimeout))
+	a.conn.SetPingHandler(func(data string) error {
+		_ = a.conn.SetReadDeadline(time.Now().Add(socketReadTimeout))
+		err := a.conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(socketWriteTimeout))
+		if errors.Is(err, websocket.ErrCloseSent) {
+			return nil
+		}
+		return err
+	})
+
+	for {
+		var msg socketMessage
+		if err := a.conn.ReadJSON(&msg); err != nil {
+			log.Warnf("Agent socket dropped: %v", err)
+			return
+		}
+		_ = a.conn.SetReadDeadline(time.Now().Add(socketReadTimeout))
+
+		if msg.Type == socketAssign && msg.Task != nil {
+			// The server assigns one task per ready message, the buffer of
+			// assignments never fills up.
+			a.assignments <- *msg.Task
+			continue
+		}
+		a.mu.Lock()
+		reply, ok := a.pending[msg.ID]
+		a.mu.Unlock()
+		if ok {
+			reply <- msg
+		} else if msg.Error != "" {
+			log.Errorf("error reported over agent socket: %s", msg.Error)
+		}
+	}
+}
+
+func (a *agentSocket) send(msg socketMessage) error {
+	a.writeMu.Lock()
+	defer a.writeMu.Unlock()
+	_ = a.conn.SetWriteDeadline(time.Now().Add(socketWriteTimeout))
+	if err := a.conn.WriteJSON(msg); err != nil {
+		// Closing the connection stops readMessages, which marks the socket
+		// as closed.
+		a.conn.Close()
+		return fmt.Errorf("%w: %v", errSocketClosed, err)
+	}
+	return nil
+}
+
+// request sends the message and waits for the reply of the server.
+func (a *agentSocket) request(ctx context.Context, msg socketMessage) (socketMessage, error) {
+	reply := make(chan socketMessage, 1)
+	a.mu.Lock()
+	a.nextID++
+	msg.ID = strconv.Itoa(a.nextID)
+	a.pending[msg.ID] = reply
+	a.mu.Unlock()
+	defer func() {
+		a.mu.Lock()
+		delete(a.pending, msg.ID)
+		a.mu.Unlock()
+	}()
+
+	if err := a.send(msg); err != nil {
+		return socketMessage{}, err
+	}
+	timer := time.NewTimer(socketReplyTimeout)
+	defer timer.Stop()
+
+	select {
+	case msg := <-reply:
+		return msg, nil
+	case <-a.closed:
+		return socketMessage{}, errSocketClosed
+	case <-timer.C:
+		return socketMessage{}, errors.New("server did not reply over agent socket")
+	case <-ctx.Done():
+		return socketMessage{}, ctx.Err()
+	}
+}
+
+func (a *agentSocket) renewLease(ctx context.Context, task Task) (taskLease, error) {
+	reply, err := a.request(ctx, socketMessage{Type: socketHeartbeat, TaskID: task.ID, LeaseID: task.LeaseID})
+	if errors.Is(err, errSocketClosed) {
+		return a.e.renewLease(ctx, task)
+	}
+	if err != nil {
+		return taskLease{}, err
+	}
+
+	switch {
+	case reply.Type == socketLeaseLost:
+		return taskLease{}, errLeaseLost
+	case (reply.Type == socketLease || reply.Type == socketCancel) && reply.Lease != nil:
+		return *reply.Lease, nil
+	default:
+		return taskLease{}, fmt.Errorf("heartbeat failed: %s", reply.Error)
+	}
+}
+
+func (a *agentSocket) reportResult(task Task, result TaskResult) error {
+	log.Debugf("Sending finished task: %+v", result)
+	reply, err := a.request(context.Background(), socketMessage{Type: socketResult, TaskID: task.ID, Result: &result})
+	if errors.Is(err, errSocketClosed) {
+		return a.e.reportResult(task, result)
+	}
+	if err != nil {
+		return err
+	}
+	if reply.Type != socketFinished {
+		return fmt.Errorf("finishing task failed: %s", reply.Error)
+	}
+	return nil
+}
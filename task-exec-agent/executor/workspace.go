@@ -0,0 +1,115 @@
+package executor
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// prepareWorkspace creates a fresh working directory for the task and unpacks
+// its input files into it. The directory is returned even on failure, the
+// caller has to remove it.
+func (e *Executor) prepareWorkspace(task Task) (string, error) {
+	dir, err := os.MkdirTemp(e.cfg.WorkspaceDir, "task-"+task.ID+"-")
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.cfg.BundleDownloadTimeout)
+	defer cancel()
+	bundleURL := "http://" + e.cfg.BackendHost + ":" + e.cfg.BackendPort + "/tasks/" + task.ID + "/bundle"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, bundleURL, nil)
+	if err != nil {
+		return dir, err
+	}
+	client := &http.Client{Transport: e.client.Transport}
+	log.Infof("Downloading input files of task %s into %s", task.ID, dir)
+	resp, err := client.Do(req)
+	if err != nil {
+		return dir, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return dir, fmt.Errorf("downloading input files returned status: %s", resp.Status)
+	}
+
+	return dir, extractBundle(resp.Body, dir, e.cfg.MaxBundleSize)
+}
+
+// extractBundle unpacks a tar or tar.gz archive into dir. Only directories and
+// regular files are supported, entries escaping dir are rejected. The files
+// may take up to maxSize bytes in total, however well the archive compresses.
+func extractBundle(r io.Reader, dir string, maxSize int64) error {
+	buffered := bufio.NewReader(r)
+	var contents io.Reader = buffered
+	if magic, _ := buffered.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		uncompressed, err := gzip.NewReader(buffered)
+		if err != nil {
+			return err
+		}
+		defer uncompressed.Close()
+		contents = uncompressed
+	}
+
+	archive := tar.NewReader(contents)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := path.Clean(header.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("bundle entry %q escapes the working directory", header.Name)
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if header.Size > maxSize {
+				return fmt.Errorf("bundle is larger than %d bytes unpacked", maxSize)
+			}
+			maxSize -= header.Size
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			file, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, header.FileInfo().Mode().Perm())
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(file, archive)
+			file.Close()
+			if err != nil {
+				return err
+			}
+		case tar.TypeXGlobalHeader:
+			continue
+		default:
+			return fmt.Errorf("bundle entry %q has an unsupported type", header.Name)
+		}
+	}
+}
+
+// cleanupWorkspace removes the working directory created for the task.
+func cleanupWorkspace(dir string) {
+	if err := os.RemoveAll(dir); err != nil {
+		log.Errorf("error removing workspace %s: %v", dir, err)
+	}
+}
@@ -0,0 +1,94 @@
+package executor
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// tarball packs the entries into a gzipped tar archive, regular files are
+// filled with as many x as their size.
+func tarball(t *testing.T, entries ...*tar.Header) []byte {
+	var buf bytes.Buffer
+	compressed := gzip.NewWriter(&buf)
+	archive := tar.NewWriter(compressed)
+	for _, header := range entries {
+		assert.NoError(t, archive.WriteHeader(header))
+		if header.Typeflag == tar.TypeReg {
+			_, err := archive.Write(bytes.Repeat([]byte{'x'}, int(header.Size)))
+			assert.NoError(t, err)
+		}
+	}
+	assert.NoError(t, archive.Close())
+	assert.NoError(t, compressed.Close())
+	return buf.Bytes()
+}
+
+func TestExtractBundle(t *testing.T) {
+	assert := assert.New(t)
+
+	// Directories and files are unpacked with their modes
+	dir := t.TempDir()
+	bundle := tarball(t,
+		&tar.Header{Typeflag: tar.TypeDir, Name: "src/", Mode: 0o755},
+		&tar.Header{Typeflag: tar.TypeReg, Name: "build.sh", Mode: 0o755, Size: 3},
+		&tar.Header{Typeflag: tar.TypeReg, Name: "src/main.c", Mode: 0o644, Size: 5},
+	)
+	assert.NoError(extractBundle(bytes.NewReader(bundle), dir, 8))
+	info, err := os.Stat(filepath.Join(dir, "build.sh"))
+	assert.NoError(err)
+	assert.Equal(os.FileMode(0o755), info.Mode().Perm())
+	data, err := os.ReadFile(filepath.Join(dir, "src", "main.c"))
+	assert.NoError(err)
+	assert.Equal("xxxxx", string(data))
+
+	// Uncompressed archives are accepted as well
+	var plain bytes.Buffer
+	archive := tar.NewWriter(&plain)
+	assert.NoError(archive.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "a.txt", Mode: 0o644, Size: 1}))
+	_, err = archive.Write([]byte("a"))
+	assert.NoError(err)
+	assert.NoError(archive.Close())
+	assert.NoError(extractBundle(&plain, t.TempDir(), 8))
+
+	for name, header := range map[string]*tar.Header{
+		"traversal":     {Typeflag: tar.TypeReg, Name: "../evil.sh", Mode: 0o755, Size: 1},
+		"nested escape": {Typeflag: tar.TypeReg, Name: "src/../../evil.sh", Mode: 0o755, Size: 1},
+		"absolute":      {Typeflag: tar.TypeReg, Name: "/etc/evil", Mode: 0o644, Size: 1},
+		"symlink":       {Typeflag: tar.TypeSymlink, Name: "passwd", Linkname: "/etc/passwd"},
+		"hard link":     {Typeflag: tar.TypeLink, Name: "passwd", Linkname: "/etc/passwd"},
+	} {
+		dir := t.TempDir()
+		assert.Error(extractBundle(bytes.NewReader(tarball(t, header)), dir, 8), name)
+		_, err := os.Lstat(filepath.Join(dir, "passwd"))
+		assert.True(os.IsNotExist(err), name)
+		_, err = os.Lstat(filepath.Join(filepath.Dir(dir), "evil.sh"))
+		assert.True(os.IsNotExist(err), name)
+	}
+
+	// Files over the unpacked size limit, in total as well, even if they
+	// compress well
+	bundle = tarball(t, &tar.Header{Typeflag: tar.TypeReg, Name: "big.bin", Mode: 0o644, Size: 1 << 20})
+	assert.Less(len(bundle), 1<<20/100)
+	err = extractBundle(bytes.NewReader(bundle), t.TempDir(), 1<<19)
+	assert.ErrorContains(err, "larger than")
+
+	bundle = tarball(t,
+		&tar.Header{Typeflag: tar.TypeReg, Name: "a.bin", Mode: 0o644, Size: 6},
+		&tar.Header{Typeflag: tar.TypeReg, Name: "b.bin", Mode: 0o644, Size: 6},
+	)
+	dir = t.TempDir()
+	err = extractBundle(bytes.NewReader(bundle), dir, 8)
+	assert.ErrorContains(err, "larger than")
+	_, err = os.Stat(filepath.Join(dir, "b.bin"))
+	assert.True(os.IsNotExist(err))
+
+	// Corrupt archives are rejected
+	assert.Error(extractBundle(strings.NewReader("\x1f\x8bnot gzip"), t.TempDir(), 8))
+}